@@ -0,0 +1,228 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying out and
+// testing the social login locally. It approves every authorization request
+// without asking, for the user given by the flags or by a login_hint query
+// parameter, and checks PKCE and the client credentials like a real provider.
+//
+//	go run ./cmd/mockoidc -addr :9999
+//	OIDC_ISSUER=http://localhost:9999 OIDC_CLIENT_ID=shop OIDC_CLIENT_SECRET=secret go run .
+//
+// then open http://localhost:8000/users/oidc/oidc/login in a browser.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type grant struct {
+	clientID      string
+	redirectURI   string
+	challenge     string
+	nonce         string
+	email         string
+	subject       string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+type provider struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	email         string
+	subject       string
+	givenName     string
+	familyName    string
+	emailVerified bool
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+func main() {
+	p := &provider{grants: make(map[string]grant)}
+	addr := flag.String("addr", ":9999", "listen address")
+	flag.StringVar(&p.issuer, "issuer", "http://localhost:9999", "issuer URL, as configured in OIDC_ISSUER")
+	flag.StringVar(&p.clientID, "client-id", "shop", "accepted client id")
+	flag.StringVar(&p.clientSecret, "client-secret", "secret", "accepted client secret")
+	flag.StringVar(&p.email, "email", "jane@example.com", "email of the user who logs in")
+	flag.StringVar(&p.subject, "subject", "", "subject of the user who logs in; derived from the email when empty")
+	flag.StringVar(&p.givenName, "given-name", "Jane", "given name of the user who logs in")
+	flag.StringVar(&p.familyName, "family-name", "Doe", "family name of the user who logs in")
+	flag.BoolVar(&p.emailVerified, "email-verified", true, "whether the email is reported as verified")
+	flag.Parse()
+
+	var err error
+	if p.key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		log.Fatal(err)
+	}
+	p.kid = randomString(8)
+
+	http.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	http.HandleFunc("/authorize", p.authorize)
+	http.HandleFunc("/token", p.token)
+	http.HandleFunc("/jwks", p.jwks)
+	log.Printf("mock OIDC provider %s listening on %s", p.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize approves the request straight away and redirects back with a
+// code. login_hint picks another user; email_verified=false reports the
+// email as unverified.
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if q.Get("client_id") != p.clientID || err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "unknown client or bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "only the code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	g := grant{
+		clientID:      p.clientID,
+		redirectURI:   redirectURI.String(),
+		challenge:     q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		email:         p.email,
+		subject:       p.subject,
+		emailVerified: p.emailVerified && q.Get("email_verified") != "false",
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	if hint := q.Get("login_hint"); hint != "" {
+		g.email, g.subject = hint, ""
+	}
+	if g.subject == "" {
+		sum := sha256.Sum256([]byte(g.email))
+		g.subject = base64.RawURLEncoding.EncodeToString(sum[:12])
+	}
+
+	code := randomString(16)
+	p.mu.Lock()
+	p.grants[code] = g
+	p.mu.Unlock()
+
+	back := redirectURI.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirectURI.RawQuery = back.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code",
+		!ok, time.Now().After(g.expiresAt),
+		g.redirectURI != r.PostForm.Get("redirect_uri"),
+		base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.issuer,
+		"sub":            g.subject,
+		"aud":            g.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.email,
+		"email_verified": g.emailVerified,
+		"given_name":     p.givenName,
+		"family_name":    p.familyName,
+	})
+	idToken.Header["kid"] = p.kid
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(16),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	public := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": p.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
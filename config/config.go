@@ -0,0 +1,476 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the typed runtime configuration. Values come from defaults, then
+// the optional YAML file named by CONFIG_FILE, then environment variables.
+type Config struct {
+	Port      string    `yaml:"port"`
+	Database  Database  `yaml:"database"`
+	Tokens    Tokens    `yaml:"tokens"`
+	Timeouts  Timeouts  `yaml:"timeouts"`
+	Mail      Mail      `yaml:"mail"`
+	Lockout   Lockout   `yaml:"lockout"`
+	Passwords Passwords `yaml:"passwords"`
+	Privacy   Privacy   `yaml:"privacy"`
+	Orders    Orders    `yaml:"orders"`
+	Search    Search    `yaml:"search"`
+	// PublicURL is the externally reachable base URL used in mailed links.
+	PublicURL string `yaml:"public_url"`
+	// AdminEmail names the account that is made an admin, to bootstrap the
+	// first admin. It is promoted once it has verified this address.
+	AdminEmail string `yaml:"admin_email"`
+	// OIDC holds the OpenID Connect providers users can log in with, keyed
+	// by the name used in their login URLs.
+	OIDC map[string]OIDCProvider `yaml:"oidc"`
+	// TrustedProxies are the proxy addresses or CIDRs whose X-Forwarded-For
+	// header is believed. Empty means clients connect directly, so a client
+	// cannot pick the address that login throttling sees.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type Database struct {
+	// Driver is one of mongo, memory, sqlite or postgres.
+	Driver string `yaml:"driver"`
+	// URL is the MongoDB URI or the SQL DSN, depending on Driver.
+	URL string `yaml:"url"`
+	// Name is the MongoDB database name.
+	Name           string        `yaml:"name"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+}
+
+type Tokens struct {
+	// Algorithm is HS256 (signed with SecretKey), RS256 or EdDSA.
+	Algorithm string `yaml:"algorithm"`
+	// SecretKey is the HS256 shared secret.
+	SecretKey string        `yaml:"secret_key"`
+	AccessTTL time.Duration `yaml:"access_ttl"`
+	// RefreshTTL is how long a refresh token stays valid.
+	RefreshTTL time.Duration `yaml:"refresh_ttl"`
+	// KeyDir stores the RS256/EdDSA private keys. When empty the keys live
+	// in memory only and every restart invalidates issued tokens.
+	KeyDir           string        `yaml:"key_dir"`
+	RotationInterval time.Duration `yaml:"rotation_interval"`
+	// PasswordResetTTL is how long a mailed password reset link works.
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl"`
+	// EmailVerificationTTL is how long a mailed verification link works.
+	EmailVerificationTTL time.Duration `yaml:"email_verification_ttl"`
+	// TwoFactorChallengeTTL is how long a user with 2FA has to enter their
+	// code after the password was accepted.
+	TwoFactorChallengeTTL time.Duration `yaml:"two_factor_challenge_ttl"`
+	// TOTPIssuer names this service in authenticator apps.
+	TOTPIssuer string `yaml:"totp_issuer"`
+}
+
+type Mail struct {
+	// Driver is log (write mail to the log) or file (append JSON lines to File).
+	Driver string `yaml:"driver"`
+	File   string `yaml:"file"`
+	From   string `yaml:"from"`
+}
+
+type Lockout struct {
+	// MaxFailures failed logins for one account within Window lock the
+	// account for Duration.
+	MaxFailures int `yaml:"max_failures"`
+	// IPMaxFailures failed logins from one client address within Window
+	// block that address for Duration.
+	IPMaxFailures int           `yaml:"ip_max_failures"`
+	Window        time.Duration `yaml:"window"`
+	Duration      time.Duration `yaml:"duration"`
+	// BaseDelay is the wait enforced after the first failure. It doubles
+	// with every further failure, up to MaxDelay.
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	// WebhookURL, when set, is sent a JSON event for every locked account.
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+type Passwords struct {
+	MinLength int `yaml:"min_length"`
+	MaxLength int `yaml:"max_length"`
+	// MinClasses is how many of lower case letters, upper case letters,
+	// digits and symbols a new password must mix.
+	MinClasses int `yaml:"min_classes"`
+	// BlocklistFile lists breached or common passwords, one per line, that
+	// cannot be chosen. Empty uses the list built into the passwords
+	// package.
+	BlocklistFile string `yaml:"blocklist_file"`
+	// Algorithm hashes new passwords: argon2id or bcrypt. Stored hashes in
+	// the other format or with other parameters are upgraded at login.
+	Algorithm       string `yaml:"algorithm"`
+	BcryptCost      int    `yaml:"bcrypt_cost"`
+	Argon2Time      int    `yaml:"argon2_time"`
+	Argon2MemoryKiB int    `yaml:"argon2_memory_kib"`
+	Argon2Threads   int    `yaml:"argon2_threads"`
+}
+
+type Privacy struct {
+	// ErasureGracePeriod is how long a user can change their mind after
+	// asking for their personal data to be erased.
+	ErasureGracePeriod time.Duration `yaml:"erasure_grace_period"`
+	// ErasureCheckInterval is how often due erasures are carried out.
+	ErasureCheckInterval time.Duration `yaml:"erasure_check_interval"`
+}
+
+type Orders struct {
+	// PaymentTimeout is how long a digitally paid order holds its reserved
+	// stock before it is cancelled for want of payment.
+	PaymentTimeout time.Duration `yaml:"payment_timeout"`
+	// PaymentCheckInterval is how often unpaid orders are looked for.
+	PaymentCheckInterval time.Duration `yaml:"payment_check_interval"`
+}
+
+type Search struct {
+	// ReindexInterval is how often the search index is rebuilt from the
+	// database, picking up products written by other instances.
+	ReindexInterval time.Duration `yaml:"reindex_interval"`
+}
+
+type OIDCProvider struct {
+	// Issuer is the provider's issuer URL. Its endpoints are discovered from
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// Scopes are requested on top of openid.
+	Scopes []string `yaml:"scopes"`
+}
+
+type Timeouts struct {
+	// Request bounds the store calls made by a regular handler.
+	Request time.Duration `yaml:"request"`
+	// Cart bounds the quicker cart and checkout store calls.
+	Cart time.Duration `yaml:"cart"`
+}
+
+func Default() Config {
+	return Config{
+		Port: "8000",
+		Database: Database{
+			Driver:         "mongo",
+			Name:           "Ecommerce",
+			ConnectTimeout: 10 * time.Second,
+		},
+		Tokens: Tokens{
+			Algorithm:             "HS256",
+			AccessTTL:             2 * time.Hour,
+			RefreshTTL:            7 * 24 * time.Hour,
+			RotationInterval:      30 * 24 * time.Hour,
+			PasswordResetTTL:      30 * time.Minute,
+			EmailVerificationTTL:  24 * time.Hour,
+			TwoFactorChallengeTTL: 5 * time.Minute,
+			TOTPIssuer:            "ecommerce-gin",
+		},
+		Mail: Mail{
+			Driver: "log",
+			File:   "mail.jsonl",
+			From:   "no-reply@localhost",
+		},
+		Lockout: Lockout{
+			MaxFailures:   5,
+			IPMaxFailures: 50,
+			Window:        15 * time.Minute,
+			Duration:      15 * time.Minute,
+			BaseDelay:     time.Second,
+			MaxDelay:      time.Minute,
+		},
+		Passwords: Passwords{
+			MinLength:       8,
+			MaxLength:       128,
+			MinClasses:      1,
+			Algorithm:       "argon2id",
+			BcryptCost:      12,
+			Argon2Time:      3,
+			Argon2MemoryKiB: 64 * 1024,
+			Argon2Threads:   2,
+		},
+		Privacy: Privacy{
+			ErasureGracePeriod:   30 * 24 * time.Hour,
+			ErasureCheckInterval: time.Hour,
+		},
+		Orders: Orders{
+			PaymentTimeout:       30 * time.Minute,
+			PaymentCheckInterval: time.Minute,
+		},
+		Search: Search{
+			ReindexInterval: 5 * time.Minute,
+		},
+		Timeouts: Timeouts{
+			Request: 100 * time.Second,
+			Cart:    5 * time.Second,
+		},
+	}
+}
+
+// Load builds the configuration and validates it.
+func Load() (Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("config: %w", err)
+		}
+		if err = yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Database.URL == "" {
+		cfg.Database.URL = defaultURL(cfg.Database.Driver)
+	}
+	if cfg.PublicURL == "" {
+		cfg.PublicURL = "http://localhost:" + cfg.Port
+	}
+	return cfg, cfg.Validate()
+}
+
+// MustLoad is Load for main: it exits when the configuration is unusable.
+func MustLoad() Config {
+	cfg, err := Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+func (cfg *Config) applyEnv() error {
+	setString(&cfg.Port, "PORT")
+	setString(&cfg.Database.Driver, "DB_DRIVER")
+	setString(&cfg.Database.URL, "DATABASE_URL")
+	setString(&cfg.Database.Name, "DATABASE_NAME")
+	setString(&cfg.Tokens.SecretKey, "SECRET_KEY")
+	setString(&cfg.Tokens.Algorithm, "JWT_ALGORITHM")
+	setString(&cfg.Tokens.KeyDir, "JWT_KEY_DIR")
+	setString(&cfg.Tokens.TOTPIssuer, "TOTP_ISSUER")
+	setString(&cfg.AdminEmail, "ADMIN_EMAIL")
+	setString(&cfg.Mail.Driver, "MAIL_DRIVER")
+	setString(&cfg.Mail.File, "MAIL_FILE")
+	setString(&cfg.Mail.From, "MAIL_FROM")
+	setString(&cfg.PublicURL, "PUBLIC_URL")
+	setString(&cfg.Lockout.WebhookURL, "LOCKOUT_WEBHOOK_URL")
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		cfg.TrustedProxies = strings.Split(value, ",")
+	}
+	setString(&cfg.Passwords.BlocklistFile, "PASSWORD_BLOCKLIST_FILE")
+	setString(&cfg.Passwords.Algorithm, "PASSWORD_HASH_ALGORITHM")
+
+	for _, n := range cfg.ints() {
+		if err := setInt(n.target, n.key); err != nil {
+			return err
+		}
+	}
+	cfg.applyOIDCEnv()
+
+	for _, d := range cfg.durations() {
+		if err := setDuration(d.target, d.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyOIDCEnv configures one provider from OIDC_ISSUER, OIDC_CLIENT_ID and
+// OIDC_CLIENT_SECRET, named by OIDC_PROVIDER (default "oidc").
+func (cfg *Config) applyOIDCEnv() {
+	issuer, ok := os.LookupEnv("OIDC_ISSUER")
+	if !ok {
+		return
+	}
+	name := "oidc"
+	setString(&name, "OIDC_PROVIDER")
+	if cfg.OIDC == nil {
+		cfg.OIDC = make(map[string]OIDCProvider)
+	}
+	provider := cfg.OIDC[name]
+	provider.Issuer = issuer
+	setString(&provider.ClientID, "OIDC_CLIENT_ID")
+	setString(&provider.ClientSecret, "OIDC_CLIENT_SECRET")
+	cfg.OIDC[name] = provider
+}
+
+type intSetting struct {
+	target *int
+	key    string
+}
+
+// ints are the integer settings; all must be positive.
+func (cfg *Config) ints() []intSetting {
+	return []intSetting{
+		{&cfg.Lockout.MaxFailures, "LOCKOUT_MAX_FAILURES"},
+		{&cfg.Lockout.IPMaxFailures, "LOCKOUT_IP_MAX_FAILURES"},
+		{&cfg.Passwords.MinLength, "PASSWORD_MIN_LENGTH"},
+		{&cfg.Passwords.MaxLength, "PASSWORD_MAX_LENGTH"},
+		{&cfg.Passwords.MinClasses, "PASSWORD_MIN_CLASSES"},
+		{&cfg.Passwords.BcryptCost, "BCRYPT_COST"},
+		{&cfg.Passwords.Argon2Time, "ARGON2_TIME"},
+		{&cfg.Passwords.Argon2MemoryKiB, "ARGON2_MEMORY_KIB"},
+		{&cfg.Passwords.Argon2Threads, "ARGON2_THREADS"},
+	}
+}
+
+type durationSetting struct {
+	target *time.Duration
+	key    string
+}
+
+func (cfg *Config) durations() []durationSetting {
+	return []durationSetting{
+		{&cfg.Database.ConnectTimeout, "DB_CONNECT_TIMEOUT"},
+		{&cfg.Tokens.AccessTTL, "ACCESS_TOKEN_TTL"},
+		{&cfg.Tokens.RefreshTTL, "REFRESH_TOKEN_TTL"},
+		{&cfg.Tokens.RotationInterval, "JWT_ROTATION_INTERVAL"},
+		{&cfg.Tokens.PasswordResetTTL, "PASSWORD_RESET_TTL"},
+		{&cfg.Tokens.EmailVerificationTTL, "EMAIL_VERIFICATION_TTL"},
+		{&cfg.Tokens.TwoFactorChallengeTTL, "TWO_FACTOR_CHALLENGE_TTL"},
+		{&cfg.Lockout.Window, "LOCKOUT_WINDOW"},
+		{&cfg.Lockout.Duration, "LOCKOUT_DURATION"},
+		{&cfg.Lockout.BaseDelay, "LOGIN_BACKOFF_BASE"},
+		{&cfg.Lockout.MaxDelay, "LOGIN_BACKOFF_MAX"},
+		{&cfg.Privacy.ErasureGracePeriod, "ERASURE_GRACE_PERIOD"},
+		{&cfg.Privacy.ErasureCheckInterval, "ERASURE_CHECK_INTERVAL"},
+		{&cfg.Orders.PaymentTimeout, "PAYMENT_TIMEOUT"},
+		{&cfg.Orders.PaymentCheckInterval, "PAYMENT_CHECK_INTERVAL"},
+		{&cfg.Search.ReindexInterval, "SEARCH_REINDEX_INTERVAL"},
+		{&cfg.Timeouts.Request, "REQUEST_TIMEOUT"},
+		{&cfg.Timeouts.Cart, "CART_TIMEOUT"},
+	}
+}
+
+func (cfg Config) Validate() error {
+	var errs []error
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port <= 0 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT %q is not a valid port", cfg.Port))
+	}
+	switch cfg.Database.Driver {
+	case "mongo", "memory", "sqlite", "postgres":
+	default:
+		errs = append(errs, fmt.Errorf("DB_DRIVER %q is not one of mongo, memory, sqlite, postgres", cfg.Database.Driver))
+	}
+	if cfg.Database.Driver == "postgres" && cfg.Database.URL == "" {
+		errs = append(errs, errors.New("DATABASE_URL is required for postgres"))
+	}
+	switch cfg.Tokens.Algorithm {
+	case "HS256":
+		if cfg.Tokens.SecretKey == "" {
+			errs = append(errs, errors.New("SECRET_KEY is required"))
+		}
+	case "RS256", "EdDSA":
+	default:
+		errs = append(errs, fmt.Errorf("JWT_ALGORITHM %q is not one of HS256, RS256, EdDSA", cfg.Tokens.Algorithm))
+	}
+	if cfg.Tokens.TOTPIssuer == "" {
+		errs = append(errs, errors.New("TOTP_ISSUER must not be empty"))
+	}
+	switch cfg.Mail.Driver {
+	case "log":
+	case "file":
+		if cfg.Mail.File == "" {
+			errs = append(errs, errors.New("MAIL_FILE is required for the file mail driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MAIL_DRIVER %q is not one of log, file", cfg.Mail.Driver))
+	}
+	for _, n := range cfg.ints() {
+		if *n.target <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", n.key))
+		}
+	}
+	errs = append(errs, cfg.Passwords.validate()...)
+	for name, provider := range cfg.OIDC {
+		if u, err := url.Parse(provider.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("oidc %s: issuer %q is not an http(s) URL", name, provider.Issuer))
+		}
+		if provider.ClientID == "" {
+			errs = append(errs, fmt.Errorf("oidc %s: client_id is required", name))
+		}
+	}
+	for _, d := range cfg.durations() {
+		if *d.target <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", d.key))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (p Passwords) validate() []error {
+	var errs []error
+	if p.MaxLength < p.MinLength {
+		errs = append(errs, errors.New("PASSWORD_MAX_LENGTH must not be below PASSWORD_MIN_LENGTH"))
+	}
+	if p.MinClasses > 4 {
+		errs = append(errs, errors.New("PASSWORD_MIN_CLASSES must be between 1 and 4"))
+	}
+	switch p.Algorithm {
+	case "argon2id":
+		if p.Argon2Threads > 255 {
+			errs = append(errs, errors.New("ARGON2_THREADS must be at most 255"))
+		}
+	case "bcrypt":
+		if p.BcryptCost < 4 || p.BcryptCost > 31 {
+			errs = append(errs, errors.New("BCRYPT_COST must be between 4 and 31"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("PASSWORD_HASH_ALGORITHM %q is not one of argon2id, bcrypt", p.Algorithm))
+	}
+	return errs
+}
+
+func defaultURL(driver string) string {
+	switch driver {
+	case "mongo":
+		return "mongodb://localhost:27017"
+	case "sqlite":
+		return "file:ecommerce.db"
+	}
+	return ""
+}
+
+func setString(target *string, key string) {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		*target = value
+	}
+}
+
+func setInt(target *int, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", key, err)
+	}
+	*target = n
+	return nil
+}
+
+func setDuration(target *time.Duration, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", key, err)
+	}
+	*target = d
+	return nil
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"log"
+	"net/http"
+)
+
+// BootstrapAdmin promotes the account registered under email to admin. It is
+// run at startup so the first admin can be created without database access.
+// Only an account that has verified that address is promoted; one that has
+// not is promoted when it verifies instead.
+func BootstrapAdmin(ctx context.Context, users database.UserStore, email string) error {
+	if email == "" {
+		return nil
+	}
+	user, err := users.FindByEmail(ctx, email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		log.Printf("admin account %s does not exist yet, it will be promoted once its email is verified", email)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerified || normalizeEmail(user.Email) != normalizeEmail(email) {
+		log.Printf("admin account %s has not verified its email, it will be promoted once it does", email)
+		return nil
+	}
+	if user.Role == models.RoleAdmin {
+		return nil
+	}
+	if err = users.SetRole(ctx, user.UserID, models.RoleAdmin); err != nil {
+		return err
+	}
+	log.Printf("promoted %s to admin", email)
+	return nil
+}
+
+// promoteVerifiedAdmin makes the user admin when email, the address they
+// have just verified, is the configured admin email. Signing up with the
+// address is not enough on its own.
+func (app *Application) promoteVerifiedAdmin(ctx context.Context, userID, email string) error {
+	if app.roleFor(email) != models.RoleAdmin {
+		return nil
+	}
+	if err := app.users.SetRole(ctx, userID, models.RoleAdmin); err != nil {
+		return err
+	}
+	log.Printf("promoted %s to admin", email)
+	return nil
+}
+
+// OnBehalfOf lets an admin run the cart, order and address handlers for the
+// customer named by ?userID=. Every such request is written to the audit log
+// before the handler runs, and refused if the record cannot be written.
+func (app *Application) OnBehalfOf() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID := c.Query("userID")
+		if userQueryID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user id is empty"})
+			c.Abort()
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		if _, err := app.users.FindByID(ctx, userQueryID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		record := newAuditRecord(c, userQueryID, c.Request.URL.RawQuery)
+		if err := app.audit.Record(ctx, record); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to write the audit record"})
+			c.Abort()
+			return
+		}
+
+		c.Set("acting_uid", userQueryID)
+		c.Next()
+	}
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"github.com/mukulmantosh/ecommerce-gin/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAdminRoleNeedsVerifiedEmail(t *testing.T) {
+	app, stores, _ := newTestApp(t)
+	app.adminEmail = "boss@example.com"
+	router := gin.New()
+	router.POST("/users/signup", app.Signup())
+	router.GET("/users/verify-email", app.VerifyEmail())
+	ctx := context.Background()
+
+	role := func(email string) string {
+		t.Helper()
+		user, err := stores.Users.FindByEmail(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Role
+	}
+
+	signup(t, router, "Boss@example.com", "100")
+	if got := role("boss@example.com"); got != models.RoleCustomer {
+		t.Fatalf("role after signup = %q, want %q", got, models.RoleCustomer)
+	}
+	if err := BootstrapAdmin(ctx, stores.Users, app.adminEmail); err != nil {
+		t.Fatal(err)
+	}
+	if got := role("boss@example.com"); got != models.RoleCustomer {
+		t.Fatalf("role after bootstrapping an unverified account = %q, want %q", got, models.RoleCustomer)
+	}
+
+	user, err := stores.Users.FindByEmail(ctx, "boss@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := tokens.EmailVerificationToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(t, router, http.MethodGet, "/users/verify-email?token="+url.QueryEscape(token), "", nil); w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	if got := role("boss@example.com"); got != models.RoleAdmin {
+		t.Fatalf("role after verifying = %q, want %q", got, models.RoleAdmin)
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		verified bool
+		want     string
+	}{
+		{"verified", "boss@example.com", true, models.RoleAdmin},
+		{"verified in another case", "BOSS@example.com", true, models.RoleAdmin},
+		{"unverified", "boss@example.com", false, models.RoleCustomer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := database.NewMemoryUserStore(database.NewMemoryDB())
+			id := primitive.NewObjectID()
+			user := models.User{ID: id, UserID: id.Hex(), Email: tt.email, EmailVerified: tt.verified, Role: models.RoleCustomer}
+			if err := users.Create(ctx, &user); err != nil {
+				t.Fatal(err)
+			}
+			if err := BootstrapAdmin(ctx, users, "boss@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			got, err := users.FindByID(ctx, user.UserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Role != tt.want {
+				t.Errorf("role = %q, want %q", got.Role, tt.want)
+			}
+		})
+	}
+}
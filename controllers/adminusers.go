@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// parsePage reads the 1-based ?page= and ?per_page= query parameters.
+func parsePage(c *gin.Context) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	if raw := c.Query("page"); raw != "" {
+		if page, err = strconv.Atoi(raw); err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive number")
+		}
+	}
+	if raw := c.Query("per_page"); raw != "" {
+		if perPage, err = strconv.Atoi(raw); err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, errors.New("per_page must be between 1 and " + strconv.Itoa(maxPerPage))
+		}
+	}
+	return page, perPage, nil
+}
+
+// parseBoolQuery reads an optional boolean query parameter.
+func parseBoolQuery(c *gin.Context, name string) (*bool, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, errors.New(name + " must be true or false")
+	}
+	return &value, nil
+}
+
+// newAuditRecord records the current request, made by the logged in user,
+// against target.
+func newAuditRecord(c *gin.Context, target, detail string) models.AuditRecord {
+	return models.AuditRecord{
+		ID:           primitive.NewObjectID(),
+		ActorID:      c.GetString("uid"),
+		TargetUserID: target,
+		Action:       c.Request.Method + " " + c.FullPath(),
+		Detail:       detail,
+		At:           time.Now(),
+	}
+}
+
+// ListUsers returns a page of users, newest first. They can be filtered by
+// ?role=, ?disabled= and ?email_verified=, and searched by name, email or
+// phone with ?q=.
+func (app *Application) ListUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, perPage, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter := database.UserFilter{Role: c.Query("role"), Search: c.Query("q"),
+			Offset: (page - 1) * perPage, Limit: perPage}
+		if filter.Disabled, err = parseBoolQuery(c, "disabled"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if filter.EmailVerified, err = parseBoolQuery(c, "email_verified"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		users, total, err := app.users.List(ctx, filter)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to list the users"})
+			return
+		}
+		profiles := make([]models.UserProfile, 0, len(users))
+		for _, user := range users {
+			profiles = append(profiles, user.Profile())
+		}
+		c.JSON(http.StatusOK, gin.H{"users": profiles, "total": total, "page": page, "per_page": perPage})
+	}
+}
+
+// adminUserResponse is the profile of a user together with their cart and
+// orders.
+type adminUserResponse struct {
+	models.UserProfile
+	Cart   []models.ProductUser `json:"user_cart"`
+	Orders []models.Order       `json:"order_status"`
+}
+
+// GetUser returns one user with their addresses, cart and orders.
+func (app *Application) GetUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, ok := app.findUserParam(ctx, c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, adminUserResponse{UserProfile: user.Profile(), Cart: user.UserCart, Orders: user.OrderStatus})
+	}
+}
+
+// DisableUser stops a user from logging in and logs them out everywhere.
+func (app *Application) DisableUser() gin.HandlerFunc {
+	return app.setUserDisabled(true)
+}
+
+// EnableUser lets a disabled user log in again.
+func (app *Application) EnableUser() gin.HandlerFunc {
+	return app.setUserDisabled(false)
+}
+
+func (app *Application) setUserDisabled(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, ok := app.findManagedUser(ctx, c)
+		if !ok {
+			return
+		}
+		if user.UserID == c.GetString("uid") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot disable or enable your own account"})
+			return
+		}
+		if err := app.audit.Record(ctx, newAuditRecord(c, user.UserID, "")); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to write the audit record"})
+			return
+		}
+		if err := app.users.SetDisabled(ctx, user.UserID, disabled); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the account"})
+			return
+		}
+		if disabled {
+			c.JSON(http.StatusOK, "Account disabled")
+			return
+		}
+		c.JSON(http.StatusOK, "Account enabled")
+	}
+}
+
+// ForceLogout revokes every token of a user, who has to log in again.
+func (app *Application) ForceLogout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, ok := app.findManagedUser(ctx, c)
+		if !ok {
+			return
+		}
+		if err := app.audit.Record(ctx, newAuditRecord(c, user.UserID, "")); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to write the audit record"})
+			return
+		}
+		if err := app.users.RevokeTokens(ctx, user.UserID); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to log the user out"})
+			return
+		}
+		c.JSON(http.StatusOK, "User logged out")
+	}
+}
+
+// SetUserRole gives a user another role. Only admins may call it, and not
+// for themselves, so the last admin cannot demote themselves by mistake.
+func (app *Application) SetUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Role string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !isRole(body.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role " + strconv.Quote(body.Role)})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, ok := app.findUserParam(ctx, c)
+		if !ok {
+			return
+		}
+		if user.UserID == c.GetString("uid") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot change your own role"})
+			return
+		}
+		if err := app.audit.Record(ctx, newAuditRecord(c, user.UserID, user.Role+" -> "+body.Role)); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to write the audit record"})
+			return
+		}
+		if err := app.users.SetRole(ctx, user.UserID, body.Role); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to change the role"})
+			return
+		}
+		// The role is read from the user on every request, so it applies to
+		// existing tokens straight away.
+		user.Role = body.Role
+		c.JSON(http.StatusOK, user.Profile())
+	}
+}
+
+func isRole(role string) bool {
+	for _, known := range models.Roles {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
+
+// findUserParam loads the user named by the :userID path parameter, or
+// answers 404.
+func (app *Application) findUserParam(ctx context.Context, c *gin.Context) (models.User, bool) {
+	user, err := app.users.FindByID(ctx, c.Param("userID"))
+	if errors.Is(err, database.ErrUserNotFound) || errors.Is(err, database.ErrUserIdIsNotValid) {
+		c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserNotFound.Error()})
+		return user, false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the user"})
+		return user, false
+	}
+	return user, true
+}
+
+// findManagedUser is findUserParam for actions support may take on customers
+// only; staff accounts can only be managed by admins.
+func (app *Application) findManagedUser(ctx context.Context, c *gin.Context) (models.User, bool) {
+	user, ok := app.findUserParam(ctx, c)
+	if ok && user.Role != models.RoleCustomer && user.Role != "" && c.GetString("role") != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only admins can manage staff accounts"})
+		return user, false
+	}
+	return user, ok
+}
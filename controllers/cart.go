@@ -6,21 +6,90 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/mukulmantosh/ecommerce-gin/database"
 	"github.com/mukulmantosh/ecommerce-gin/models"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"log"
 	"net/http"
 	"time"
 )
 
-type Application struct {
-	prodCollection *mongo.Collection
-	userCollection *mongo.Collection
+// actingUserID is the user a cart or order handler works on: the caller
+// named by the access token, or the customer picked by OnBehalfOf on the
+// admin routes.
+func actingUserID(c *gin.Context) string {
+	if userID := c.GetString("acting_uid"); userID != "" {
+		return userID
+	}
+	return c.GetString("uid")
+}
+
+// variantQueryID reads the ?variant= id of the product variant to buy. It is
+// primitive.NilObjectID for products without variants.
+func variantQueryID(c *gin.Context) (primitive.ObjectID, error) {
+	raw := c.Query("variant")
+	if raw == "" {
+		return primitive.NilObjectID, nil
+	}
+	return primitive.ObjectIDFromHex(raw)
+}
+
+// checkoutQuery reads how the order is paid for from ?payment=: "cod", the
+// default, or "digital", which must be paid within the payment timeout.
+func (app *Application) checkoutQuery(c *gin.Context) (database.Checkout, error) {
+	var checkout database.Checkout
+	switch c.DefaultQuery("payment", "cod") {
+	case "cod":
+		checkout.Payment.COD = true
+	case "digital":
+		checkout.Payment.Digital = true
+		checkout.PaymentDue = time.Now().Add(app.paymentTimeout)
+	default:
+		return checkout, errors.New("payment must be cod or digital")
+	}
+	return checkout, nil
 }
 
-func NewApplication(prodCollection, userCollection *mongo.Collection) *Application {
-	return &Application{prodCollection: prodCollection, userCollection: userCollection}
+// shipTo sets the PIN code checkout ships to from the user's address picked
+// by ?address=: "home" or "work", the home address by default. Checkout is
+// refused when the user has no such address or it has no PIN code, rather
+// than planning the shipment without a destination.
+func shipTo(c *gin.Context, user models.User, checkout *database.Checkout) error {
+	index := homeAddressIndex
+	switch c.Query("address") {
+	case "":
+		if len(user.AddressDetails) == 0 {
+			return errors.New("add an address to ship the order to")
+		}
+	case "home":
+	case "work":
+		index = workAddressIndex
+	default:
+		return errors.New("address must be home or work")
+	}
+	if index >= len(user.AddressDetails) {
+		return errors.New("there is no " + c.Query("address") + " address to ship to")
+	}
+	checkout.PinCode = user.AddressDetails[index].PinCode
+	if checkout.PinCode == "" {
+		return errors.New("the address to ship to has no PIN code")
+	}
+	return nil
+}
+
+// orderError answers a failed checkout.
+func orderError(c *gin.Context, err error) {
+	switch {
+	case isVariantError(err):
+		c.IndentedJSON(http.StatusBadRequest, err.Error())
+	case errors.Is(err, database.ErrInsufficientStock), errors.Is(err, database.ErrProductUnavailable):
+		c.IndentedJSON(http.StatusConflict, err.Error())
+	default:
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+	}
+}
+
+// isVariantError reports whether err is about the variant the client chose.
+func isVariantError(err error) bool {
+	return errors.Is(err, database.ErrVariantRequired) || errors.Is(err, database.ErrCantFindVariant)
 }
 
 func (app *Application) AddToCart() gin.HandlerFunc {
@@ -29,31 +98,38 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 		if productQueryID == "" {
 			log.Println("Product ID is empty")
 			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			return
+		}
 
-			userQueryID := c.Query("userID")
-			if userQueryID == "" {
-				log.Println("user id is empty")
-				c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
-				return
-			}
+		userQueryID := actingUserID(c)
 
-			productID, err := primitive.ObjectIDFromHex(productQueryID)
+		productID, err := primitive.ObjectIDFromHex(productQueryID)
 
-			if err != nil {
-				log.Println(err)
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
 
-			var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
+		variantID, err := variantQueryID(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, "variant id is not valid")
+			return
+		}
 
-			database.AddProductToCart(ctx, app.prodCollection, app.userCollection, productID, userQueryID)
-			if err != nil {
-				c.IndentedJSON(http.StatusInternalServerError, err)
-			}
-			c.IndentedJSON(http.StatusOK, "successfully added")
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Cart)
+		defer cancel()
+
+		err = app.orders.AddProductToCart(ctx, productID, variantID, userQueryID)
+		if isVariantError(err) {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
 		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.IndentedJSON(http.StatusOK, "successfully added")
 	}
 }
 
@@ -63,101 +139,86 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 		if productQueryID == "" {
 			log.Println("Product ID is empty")
 			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			return
+		}
 
-			userQueryID := c.Query("userID")
-			if userQueryID == "" {
-				log.Println("user id is empty")
-				c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
-				return
-			}
+		userQueryID := actingUserID(c)
 
-			productID, err := primitive.ObjectIDFromHex(productQueryID)
+		productID, err := primitive.ObjectIDFromHex(productQueryID)
 
-			if err != nil {
-				log.Println(err)
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
 
-			var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
+		variantID, err := variantQueryID(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, "variant id is not valid")
+			return
+		}
 
-			err = database.RemoveCartItem(ctx, app.prodCollection, app.userCollection, productID, userQueryID)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Cart)
+		defer cancel()
 
-			if err != nil {
-				c.IndentedJSON(http.StatusInternalServerError, err)
-				return
-			}
-			c.IndentedJSON(http.StatusOK, "Successfully removed item from cart")
+		err = app.orders.RemoveCartItem(ctx, productID, variantID, userQueryID)
 
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, err.Error())
+			return
 		}
+		c.IndentedJSON(http.StatusOK, "Successfully removed item from cart")
 	}
 }
 
-func GetItemFromCart() gin.HandlerFunc {
+func (app *Application) GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userId := c.Query("id")
+		userId := actingUserID(c)
 
-		if userId == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
-			c.Abort()
-			return
-		}
-
-		userInfo, _ := primitive.ObjectIDFromHex(userId)
-
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
 		defer cancel()
 
-		var fillCart models.User
-		err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: userInfo}}).Decode(&fillCart)
+		cart, total, err := app.orders.GetCart(ctx, userId)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(404, "not found")
 			return
 		}
 
-		filterMatch := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: userInfo}}}}
-		Unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$user_cart"}}}}
-		Grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$user_cart.price"}}}}}}
-
-		pointCursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filterMatch, Unwind, Grouping})
-		if err != nil {
-			log.Println(err)
-		}
-		var listing []bson.M
-		if err = pointCursor.All(ctx, &listing); err != nil {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
-		}
-
-		for _, json := range listing {
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, fillCart.UserCart)
-		}
-		ctx.Done()
+		c.IndentedJSON(200, gin.H{"total": total, "user_cart": cart})
 	}
 }
 
 func (app *Application) BuyFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userQueryID := c.Query("id")
-
-		if userQueryID == "" {
-			log.Panic("user id is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty"))
+		userQueryID := actingUserID(c)
+		checkout, err := app.checkoutQuery(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
 		defer cancel()
 
-		err := database.BuyItemFromCart(ctx, app.userCollection, userQueryID)
+		user, ok := app.requireVerifiedEmail(ctx, c, userQueryID)
+		if !ok {
+			return
+		}
+		if len(user.UserCart) == 0 {
+			c.IndentedJSON(http.StatusBadRequest, "the cart is empty")
+			return
+		}
+		if err = shipTo(c, user, &checkout); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		err = app.orders.BuyItemFromCart(ctx, userQueryID, checkout)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			orderError(c, err)
+			return
 		}
 		c.IndentedJSON(http.StatusOK, "successfully placed the order")
-
 	}
 }
 
@@ -167,33 +228,47 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 		if productQueryID == "" {
 			log.Println("Product ID is empty")
 			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			return
+		}
 
-			userQueryID := c.Query("userID")
-			if userQueryID == "" {
-				log.Println("user id is empty")
-				c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
-				return
-			}
+		userQueryID := actingUserID(c)
 
-			productID, err := primitive.ObjectIDFromHex(productQueryID)
+		productID, err := primitive.ObjectIDFromHex(productQueryID)
 
-			if err != nil {
-				log.Println(err)
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
 
-			var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
+		variantID, err := variantQueryID(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, "variant id is not valid")
+			return
+		}
+		checkout, err := app.checkoutQuery(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
 
-			err = database.InstantBuyer(ctx, app.prodCollection, app.userCollection, productID, userQueryID)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Cart)
+		defer cancel()
 
-			if err != nil {
-				c.IndentedJSON(http.StatusInternalServerError, err)
-				return
-			}
-			c.IndentedJSON(http.StatusOK, "successfully placed the order")
+		user, ok := app.requireVerifiedEmail(ctx, c, userQueryID)
+		if !ok {
+			return
+		}
+		if err = shipTo(c, user, &checkout); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		err = app.orders.InstantBuyer(ctx, productID, variantID, userQueryID, checkout)
 
+		if err != nil {
+			orderError(c, err)
+			return
 		}
+		c.IndentedJSON(http.StatusOK, "successfully placed the order")
 	}
 }
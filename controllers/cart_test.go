@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// cartRouter serves the cart, checkout and address routes.
+func cartRouter(app *Application, stores database.Stores) *gin.Engine {
+	return accountRouter(app, stores, func(router *gin.Engine) {
+		router.GET("/addtocart", app.AddToCart())
+		router.GET("/listcart", app.GetItemFromCart())
+		router.GET("/cartcheckout", app.BuyFromCart())
+		router.GET("/instantbuy", app.InstantBuy())
+		router.POST("/addaddress", app.AddAddress())
+		router.GET("/users/me/orders", app.ListOrders())
+	})
+}
+
+// verifiedCustomer signs up a customer who has confirmed their email and
+// returns their access token.
+func verifiedCustomer(t *testing.T, router http.Handler, stores database.Stores, email, phone string) string {
+	t.Helper()
+	signup(t, router, email, phone)
+	ctx := context.Background()
+	user, err := stores.Users.FindByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stores.Users.MarkEmailVerified(ctx, user.UserID, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	return login(t, router, email)
+}
+
+// stockedProduct adds a product with onHand units in a warehouse at pinCode.
+func stockedProduct(t *testing.T, stores database.Stores, name string, pinCode string, onHand int) models.Product {
+	t.Helper()
+	ctx := context.Background()
+	warehouse := models.Warehouse{WarehouseID: primitive.NewObjectID(), Name: pinCode, PinCode: pinCode, Active: true}
+	if err := stores.Warehouses.Create(ctx, &warehouse); err != nil {
+		t.Fatal(err)
+	}
+	product := models.Product{ProductID: primitive.NewObjectID(), ProductName: name, Price: 5, Rating: 4}
+	if err := stores.Products.Create(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	adjustment := models.StockAdjustment{ID: primitive.NewObjectID(), WarehouseID: warehouse.WarehouseID,
+		ProductID: product.ProductID, Delta: onHand, Reason: "received"}
+	if _, err := stores.Inventory.Adjust(ctx, &adjustment); err != nil {
+		t.Fatal(err)
+	}
+	return product
+}
+
+func withID(path string, id primitive.ObjectID) string {
+	return path + "?" + url.Values{"id": {id.Hex()}}.Encode()
+}
+
+func TestCartCheckoutReservesStock(t *testing.T) {
+	app, stores, _ := newTestApp(t)
+	router := cartRouter(app, stores)
+	mug := stockedProduct(t, stores, "Mug", "560001", 2)
+	signup(t, router, "bob@example.com", "200")
+	unverified := login(t, router, "bob@example.com")
+	token := verifiedCustomer(t, router, stores, "ann@example.com", "100")
+	home := gin.H{"house": "1", "street": "MG Road", "city": "Bengaluru", "pin_code": "560001"}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   interface{}
+		want   int
+	}{
+		{"unverified email", http.MethodGet, "/cartcheckout", unverified, nil, http.StatusForbidden},
+		{"add address", http.MethodPost, "/addaddress", token, home, http.StatusOK},
+		{"empty cart", http.MethodGet, "/cartcheckout", token, nil, http.StatusBadRequest},
+		{"add to cart", http.MethodGet, withID("/addtocart", mug.ProductID), token, nil, http.StatusOK},
+		{"unknown payment", http.MethodGet, "/cartcheckout?payment=cheque", token, nil, http.StatusBadRequest},
+		{"checkout", http.MethodGet, "/cartcheckout", token, nil, http.StatusOK},
+		{"buy the last one", http.MethodGet, withID("/instantbuy", mug.ProductID), token, nil, http.StatusOK},
+		{"sold out", http.MethodGet, withID("/instantbuy", mug.ProductID), token, nil, http.StatusConflict},
+	}
+	for _, step := range steps {
+		if w := serve(t, router, step.method, step.path, step.token, step.body); w.Code != step.want {
+			t.Fatalf("%s: %d %s, want %d", step.name, w.Code, w.Body, step.want)
+		}
+	}
+
+	var cart struct {
+		Total    uint64               `json:"total"`
+		UserCart []models.ProductUser `json:"user_cart"`
+	}
+	decode(t, serve(t, router, http.MethodGet, "/listcart", token, nil), &cart)
+	if len(cart.UserCart) != 0 || cart.Total != 0 {
+		t.Errorf("cart after checkout = %+v, want it empty", cart)
+	}
+
+	var orders []models.Order
+	decode(t, serve(t, router, http.MethodGet, "/users/me/orders", token, nil), &orders)
+	if len(orders) != 2 {
+		t.Fatalf("got %d orders, want 2", len(orders))
+	}
+	for _, order := range orders {
+		if len(order.OrderCart) != 1 || len(order.Allocations) != 1 || order.Allocations[0].Quantity != 1 {
+			t.Errorf("order %s = %+v, want one unit from the warehouse", order.OrderID.Hex(), order)
+		}
+	}
+
+	levels, err := stores.Inventory.Levels(context.Background(), mug.ProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 1 || levels[0].Reserved != 2 {
+		t.Errorf("stock levels = %+v, want both units reserved", levels)
+	}
+}
+
+func TestCheckoutShipsToAddress(t *testing.T) {
+	app, stores, _ := newTestApp(t)
+	router := cartRouter(app, stores)
+	mug := stockedProduct(t, stores, "Mug", "110001", 1)
+	near := models.Warehouse{WarehouseID: primitive.NewObjectID(), Name: "Bengaluru", PinCode: "560002", Active: true}
+	if err := stores.Warehouses.Create(context.Background(), &near); err != nil {
+		t.Fatal(err)
+	}
+	adjustment := models.StockAdjustment{ID: primitive.NewObjectID(), WarehouseID: near.WarehouseID,
+		ProductID: mug.ProductID, Delta: 1, Reason: "received"}
+	if _, err := stores.Inventory.Adjust(context.Background(), &adjustment); err != nil {
+		t.Fatal(err)
+	}
+	token := verifiedCustomer(t, router, stores, "ann@example.com", "100")
+	home := gin.H{"house": "1", "street": "MG Road", "city": "Bengaluru", "pin_code": "560001"}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"add to cart", http.MethodGet, withID("/addtocart", mug.ProductID), nil, http.StatusOK},
+		{"no address", http.MethodGet, "/cartcheckout", nil, http.StatusBadRequest},
+		{"add address", http.MethodPost, "/addaddress", home, http.StatusOK},
+		{"no work address", http.MethodGet, "/cartcheckout?address=work", nil, http.StatusBadRequest},
+		{"unknown address", http.MethodGet, "/cartcheckout?address=office", nil, http.StatusBadRequest},
+		{"checkout", http.MethodGet, "/cartcheckout?address=home", nil, http.StatusOK},
+	}
+	for _, step := range steps {
+		if w := serve(t, router, step.method, step.path, token, step.body); w.Code != step.want {
+			t.Fatalf("%s: %d %s, want %d", step.name, w.Code, w.Body, step.want)
+		}
+	}
+
+	var orders []models.Order
+	decode(t, serve(t, router, http.MethodGet, "/users/me/orders", token, nil), &orders)
+	if len(orders) != 1 || len(orders[0].Allocations) != 1 || orders[0].Allocations[0].WarehouseID != near.WarehouseID {
+		t.Errorf("orders = %+v, want one shipped from the warehouse nearest the home address", orders)
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// productCursor is where a page of the catalog ended: the last product's id
+// and the fields it can be sorted on. It is handed out base64-encoded as
+// ?cursor= and only valid for the sort it was made with.
+type productCursor struct {
+	Sort   string             `json:"s"`
+	ID     primitive.ObjectID `json:"id"`
+	Price  uint64             `json:"p"`
+	Rating uint8              `json:"r"`
+	Name   string             `json:"n"`
+}
+
+func encodeProductCursor(sort string, product models.Product) string {
+	raw, _ := json.Marshal(productCursor{Sort: sort, ID: product.ProductID, Price: product.Price,
+		Rating: product.Rating, Name: product.ProductName})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeProductCursor(sort, encoded string) (*models.Product, error) {
+	errInvalid := errors.New("cursor is invalid")
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalid
+	}
+	var cursor productCursor
+	if err = json.Unmarshal(raw, &cursor); err != nil || cursor.ID.IsZero() {
+		return nil, errInvalid
+	}
+	if cursor.Sort != sort {
+		return nil, errors.New("cursor was made for another sort")
+	}
+	return &models.Product{ProductID: cursor.ID, Price: cursor.Price, Rating: cursor.Rating,
+		ProductName: cursor.Name}, nil
+}
+
+// parseProductFilter reads the catalog query parameters: sort, min_price,
+// max_price, min_rating and either page or cursor, with per_page.
+func parseProductFilter(c *gin.Context) (database.ProductFilter, int, error) {
+	var filter database.ProductFilter
+	page, perPage, err := parsePage(c)
+	if err != nil {
+		return filter, 0, err
+	}
+	filter.Limit = perPage
+
+	filter.Sort = c.DefaultQuery("sort", database.SortNewest)
+	known := false
+	for _, sort := range database.ProductSorts {
+		known = known || filter.Sort == sort
+	}
+	if !known {
+		return filter, 0, errors.New("sort must be one of " + strings.Join(database.ProductSorts, ", "))
+	}
+
+	for _, bound := range []struct {
+		name  string
+		value *uint64
+	}{{"min_price", &filter.MinPrice}, {"max_price", &filter.MaxPrice}} {
+		raw := c.Query(bound.name)
+		if raw == "" {
+			continue
+		}
+		if *bound.value, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			return filter, 0, errors.New(bound.name + " must be a whole number")
+		}
+	}
+	if filter.MaxPrice > 0 && filter.MinPrice > filter.MaxPrice {
+		return filter, 0, errors.New("min_price must not be above max_price")
+	}
+	if raw := c.Query("min_rating"); raw != "" {
+		rating, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil || rating > 5 {
+			return filter, 0, errors.New("min_rating must be between 0 and 5")
+		}
+		filter.MinRating = uint8(rating)
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		if c.Query("page") != "" {
+			return filter, 0, errors.New("page and cursor cannot be used together")
+		}
+		if filter.After, err = decodeProductCursor(filter.Sort, raw); err != nil {
+			return filter, 0, err
+		}
+		return filter, 0, nil
+	}
+	if c.Query("page") == "" {
+		return filter, 0, nil
+	}
+	filter.Offset = (page - 1) * perPage
+	return filter, page, nil
+}
+
+// listProducts answers with one page of the catalog, narrowed to the
+// category slug and its subcategories unless slug is empty. Pages are
+// numbered when ?page= is given and follow cursors otherwise; either way
+// next links to the following page, or is null on the last one.
+func (app *Application) listProducts(c *gin.Context, slug string) {
+	filter, page, err := parseProductFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+	defer cancel()
+
+	if slug != "" {
+		filter.CategoryIDs, err = app.categorySubtree(ctx, slug)
+		if errors.Is(err, database.ErrCantFindCategory) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to list the products"})
+			return
+		}
+	}
+	// One more than a page tells whether another page follows.
+	perPage := filter.Limit
+	filter.Limit++
+	products, total, err := app.products.List(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to list the products"})
+		return
+	}
+	more := len(products) > perPage
+	if more {
+		products = products[:perPage]
+	}
+
+	response := gin.H{"products": products, "total": total, "per_page": perPage, "next": nil}
+	if page > 0 {
+		response["page"] = page
+		if more {
+			response["next"] = nextLink(c, "page", strconv.Itoa(page+1))
+		}
+	} else if more {
+		cursor := encodeProductCursor(filter.Sort, products[len(products)-1])
+		response["next_cursor"] = cursor
+		response["next"] = nextLink(c, "cursor", cursor)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// nextLink is the request's path and query with the query parameter key set
+// to value.
+func nextLink(c *gin.Context, key, value string) string {
+	query := c.Request.URL.Query()
+	query.Set(key, value)
+	return c.Request.URL.Path + "?" + query.Encode()
+}
+
+// RunSearchIndex rebuilds the search index from the database every interval
+// until ctx is done. Until the first rebuild, search finds only the
+// products written since startup.
+func (app *Application) RunSearchIndex(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		app.reindex(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *Application) reindex(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, app.timeouts.Request)
+	defer cancel()
+
+	if err := app.searchIndex.Reindex(ctx); err != nil {
+		log.Printf("rebuilding the search index: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDecodeProductCursor(t *testing.T) {
+	product := models.Product{ProductID: primitive.NewObjectID(), ProductName: "Mug", Price: 5, Rating: 4}
+	valid := encodeProductCursor(database.SortPriceAsc, product)
+
+	tests := []struct {
+		name    string
+		sort    string
+		cursor  string
+		want    *models.Product
+		wantErr bool
+	}{
+		{"round trip", database.SortPriceAsc, valid, &product, false},
+		{"other sort", database.SortRating, valid, nil, true},
+		{"not base64", database.SortPriceAsc, "!!!", nil, true},
+		{"not json", database.SortPriceAsc, base64.RawURLEncoding.EncodeToString([]byte("mug")), nil, true},
+		{"no id", database.SortPriceAsc, base64.RawURLEncoding.EncodeToString([]byte(`{"s":"price_asc","p":5}`)), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeProductCursor(tt.sort, tt.cursor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeProductCursor() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeProductCursor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// productPage is a page of the catalog as listProducts answers it.
+type productPage struct {
+	Products   []models.Product `json:"products"`
+	Total      int64            `json:"total"`
+	Page       int              `json:"page"`
+	PerPage    int              `json:"per_page"`
+	Next       *string          `json:"next"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+func (p productPage) names() []string {
+	names := make([]string, 0, len(p.Products))
+	for _, product := range p.Products {
+		names = append(names, product.ProductName)
+	}
+	return names
+}
+
+// catalogServer serves products p1 to p5, priced 50 down to 10 and rated 1
+// to 5, with p1 to p3 in the category "kitchen".
+func catalogServer(t *testing.T) http.Handler {
+	app, stores, _ := newTestApp(t)
+	ctx := context.Background()
+	kitchen := models.Category{CategoryID: primitive.NewObjectID(), Name: "Kitchen", Slug: "kitchen"}
+	if err := stores.Categories.Create(ctx, &kitchen); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		product := models.Product{ProductID: primitive.NewObjectID(), ProductName: "p" + strconv.Itoa(i),
+			Price: uint64(60 - 10*i), Rating: uint8(i)}
+		if i <= 3 {
+			product.CategoryIDs = []primitive.ObjectID{kitchen.CategoryID}
+		}
+		if err := app.products.Create(ctx, &product); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := gin.New()
+	router.GET("/users/productview", app.SearchProduct())
+	router.GET("/categories/:slug/products", app.CategoryProducts())
+	return router
+}
+
+func TestListProducts(t *testing.T) {
+	router := catalogServer(t)
+
+	tests := []struct {
+		name      string
+		path      string
+		want      []string
+		wantTotal int64
+		wantNext  bool
+	}{
+		{"newest first", "/users/productview", []string{"p5", "p4", "p3", "p2", "p1"}, 5, false},
+		{"first page", "/users/productview?page=1&per_page=2", []string{"p5", "p4"}, 5, true},
+		{"last page", "/users/productview?page=3&per_page=2", []string{"p1"}, 5, false},
+		{"past the end", "/users/productview?page=4&per_page=2", []string{}, 5, false},
+		{"cheapest first", "/users/productview?sort=price_asc&per_page=2", []string{"p5", "p4"}, 5, true},
+		{"dearest first", "/users/productview?sort=price_desc&per_page=2", []string{"p1", "p2"}, 5, true},
+		{"best rated first", "/users/productview?sort=rating", []string{"p5", "p4", "p3", "p2", "p1"}, 5, false},
+		{"by name", "/users/productview?sort=name", []string{"p1", "p2", "p3", "p4", "p5"}, 5, false},
+		{"price range", "/users/productview?min_price=20&max_price=40&sort=name", []string{"p2", "p3", "p4"}, 3, false},
+		{"minimum rating", "/users/productview?min_rating=4&sort=name", []string{"p4", "p5"}, 2, false},
+		{"category", "/categories/kitchen/products?sort=name", []string{"p1", "p2", "p3"}, 3, false},
+		{"category and page", "/users/productview?category=kitchen&page=2&per_page=2&sort=name", []string{"p3"}, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, router, http.MethodGet, tt.path, "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%d %s", w.Code, w.Body)
+			}
+			var page productPage
+			decode(t, w, &page)
+			if got := page.names(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("products = %q, want %q", got, tt.want)
+			}
+			if page.Total != tt.wantTotal || (page.Next != nil) != tt.wantNext {
+				t.Errorf("total = %d, next = %v, want %d and a next link %v", page.Total, page.Next, tt.wantTotal, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestListProductsCursorPaging(t *testing.T) {
+	router := catalogServer(t)
+
+	for _, sort := range database.ProductSorts {
+		t.Run(sort, func(t *testing.T) {
+			var all productPage
+			decode(t, serve(t, router, http.MethodGet, "/users/productview?sort="+sort, "", nil), &all)
+
+			// Following next from the first page visits every product once,
+			// in the same order as one big page.
+			var paged []string
+			next := "/users/productview?per_page=2&sort=" + sort
+			for pages := 0; next != ""; pages++ {
+				if pages > len(all.Products) {
+					t.Fatalf("paging did not end after %d pages", pages)
+				}
+				var page productPage
+				decode(t, serve(t, router, http.MethodGet, next, "", nil), &page)
+				if page.Total != all.Total {
+					t.Errorf("total = %d on a later page, want %d", page.Total, all.Total)
+				}
+				paged = append(paged, page.names()...)
+				next = ""
+				if page.Next != nil {
+					next = *page.Next
+					if page.NextCursor == "" {
+						t.Fatalf("next link %q without next_cursor", next)
+					}
+				}
+			}
+			if want := all.names(); !reflect.DeepEqual(paged, want) {
+				t.Errorf("paged = %q, want %q", paged, want)
+			}
+		})
+	}
+}
+
+func TestListProductsRejectsBadQueries(t *testing.T) {
+	router := catalogServer(t)
+	var first productPage
+	decode(t, serve(t, router, http.MethodGet, "/users/productview?per_page=1&sort=price_asc", "", nil), &first)
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"unknown sort", "/users/productview?sort=random", http.StatusBadRequest},
+		{"cursor for another sort", "/users/productview?sort=rating&cursor=" + first.NextCursor, http.StatusBadRequest},
+		{"garbled cursor", "/users/productview?cursor=abc", http.StatusBadRequest},
+		{"page and cursor", "/users/productview?sort=price_asc&page=2&cursor=" + first.NextCursor, http.StatusBadRequest},
+		{"page zero", "/users/productview?page=0", http.StatusBadRequest},
+		{"per_page too large", "/users/productview?per_page=1000", http.StatusBadRequest},
+		{"negative price", "/users/productview?min_price=-1", http.StatusBadRequest},
+		{"inverted price range", "/users/productview?min_price=30&max_price=20", http.StatusBadRequest},
+		{"rating above five", "/users/productview?min_rating=6", http.StatusBadRequest},
+		{"unknown category", "/categories/garden/products", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(t, router, http.MethodGet, tt.path, "", nil); w.Code != tt.want {
+				t.Errorf("%d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	slugSpaces  = regexp.MustCompile(`[^a-z0-9]+`)
+
+	errUnknownCategory = errors.New("category_ids names a category that does not exist")
+)
+
+// slugify turns a category name into a slug, e.g. "Home & Garden" into
+// "home-garden".
+func slugify(name string) string {
+	return strings.Trim(slugSpaces.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// categoryNode is a category with its subcategories, as listed by
+// ListCategories.
+type categoryNode struct {
+	models.Category
+	Children []*categoryNode `json:"children"`
+}
+
+// categoryTree arranges categories, which are already in sort order, into
+// a tree and returns its roots.
+func categoryTree(categories []models.Category) []*categoryNode {
+	nodes := make(map[primitive.ObjectID]*categoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.CategoryID] = &categoryNode{Category: category, Children: make([]*categoryNode, 0)}
+	}
+	roots := make([]*categoryNode, 0)
+	for _, category := range categories {
+		node := nodes[category.CategoryID]
+		if category.ParentID != nil {
+			if parent, ok := nodes[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// subtree returns the id of root and of every category below it.
+func subtree(categories []models.Category, root primitive.ObjectID) []primitive.ObjectID {
+	children := make(map[primitive.ObjectID][]primitive.ObjectID)
+	for _, category := range categories {
+		if category.ParentID != nil {
+			children[*category.ParentID] = append(children[*category.ParentID], category.CategoryID)
+		}
+	}
+	ids := []primitive.ObjectID{root}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
+
+// ListCategories returns the category tree.
+func (app *Application) ListCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		categories, err := app.categories.FindAll(ctx)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to list the categories"})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, categoryTree(categories))
+	}
+}
+
+// CategoryProducts returns the products of the category named by :slug and
+// of all its subcategories.
+func (app *Application) CategoryProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		app.listProducts(c, c.Param("slug"))
+	}
+}
+
+// categorySubtree returns the ids of the category named slug and of all its
+// subcategories.
+func (app *Application) categorySubtree(ctx context.Context, slug string) ([]primitive.ObjectID, error) {
+	category, err := app.categories.FindBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := app.categories.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return subtree(categories, category.CategoryID), nil
+}
+
+// categoryBody is a category as sent to CreateCategory and UpdateCategory.
+// The slug is derived from the name when left out.
+type categoryBody struct {
+	Name      string              `json:"name"`
+	Slug      string              `json:"slug"`
+	ParentID  *primitive.ObjectID `json:"parent_id"`
+	SortOrder int                 `json:"sort_order"`
+}
+
+// CreateCategory adds a category, at the top level or under parent_id.
+func (app *Application) CreateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		category, categories, ok := app.bindCategory(ctx, c, primitive.NewObjectID())
+		if !ok {
+			return
+		}
+		if category.ParentID != nil && !hasCategory(categories, *category.ParentID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the parent category does not exist"})
+			return
+		}
+		if err := app.categories.Create(ctx, &category); err != nil {
+			app.categoryError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, category)
+	}
+}
+
+// UpdateCategory replaces a category's name, slug, parent and sort order.
+// A category cannot be moved below itself.
+func (app *Application) UpdateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := primitive.ObjectIDFromHex(c.Param("categoryID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindCategory.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		category, categories, ok := app.bindCategory(ctx, c, categoryID)
+		if !ok {
+			return
+		}
+		if !hasCategory(categories, categoryID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindCategory.Error()})
+			return
+		}
+		if category.ParentID != nil {
+			if !hasCategory(categories, *category.ParentID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the parent category does not exist"})
+				return
+			}
+			for _, id := range subtree(categories, categoryID) {
+				if id == *category.ParentID {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "a category cannot be moved below itself"})
+					return
+				}
+			}
+		}
+		if err = app.categories.Update(ctx, category); err != nil {
+			app.categoryError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, category)
+	}
+}
+
+// DeleteCategory removes a category without subcategories. Its products
+// stay in the catalog.
+func (app *Application) DeleteCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := primitive.ObjectIDFromHex(c.Param("categoryID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindCategory.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		if err = app.categories.Delete(ctx, categoryID); err != nil {
+			app.categoryError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, "Category deleted")
+	}
+}
+
+// bindCategory reads and validates the request body as the category with id
+// categoryID. It also returns all categories, for checking the parent.
+func (app *Application) bindCategory(ctx context.Context, c *gin.Context, categoryID primitive.ObjectID) (models.Category, []models.Category, bool) {
+	var body categoryBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return models.Category{}, nil, false
+	}
+	category := models.Category{CategoryID: categoryID, Name: strings.TrimSpace(body.Name),
+		Slug: strings.TrimSpace(body.Slug), ParentID: body.ParentID, SortOrder: body.SortOrder}
+	if category.Slug == "" {
+		category.Slug = slugify(category.Name)
+	}
+	if err := Validate.Struct(category); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return category, nil, false
+	}
+	if !slugPattern.MatchString(category.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the slug may only hold lowercase letters and digits separated by dashes"})
+		return category, nil, false
+	}
+
+	categories, err := app.categories.FindAll(ctx)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the categories"})
+		return category, nil, false
+	}
+	return category, categories, true
+}
+
+func (app *Application) categoryError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, database.ErrCantFindCategory):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, database.ErrSlugTaken), errors.Is(err, database.ErrCategoryHasChildren):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the category"})
+	}
+}
+
+func hasCategory(categories []models.Category, categoryID primitive.ObjectID) bool {
+	for _, category := range categories {
+		if category.CategoryID == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProductCategories drops duplicates from a product's category ids and
+// makes sure each category exists.
+func (app *Application) checkProductCategories(ctx context.Context, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	checked := make([]primitive.ObjectID, 0, len(ids))
+	if len(ids) == 0 {
+		return checked, nil
+	}
+	categories, err := app.categories.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		if !hasCategory(categories, id) {
+			return nil, errUnknownCategory
+		}
+		seen[id] = true
+		checked = append(checked, id)
+	}
+	return checked, nil
+}
@@ -1,50 +1,84 @@
 package controllers
 
-import "C"
 import (
 	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/mukulmantosh/ecommerce-gin/config"
 	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/mailer"
 	"github.com/mukulmantosh/ecommerce-gin/models"
+	"github.com/mukulmantosh/ecommerce-gin/oidc"
+	"github.com/mukulmantosh/ecommerce-gin/passwords"
+	"github.com/mukulmantosh/ecommerce-gin/search"
 	"github.com/mukulmantosh/ecommerce-gin/tokens"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
-var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
-var ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
 var Validate = validator.New()
 
-func HashPassword(password string) string {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	if err != nil {
-		log.Panic(err)
-	}
-	return string(bytes)
+// maxSearchLength bounds the search query, whose words are each matched
+// against the whole index.
+const maxSearchLength = 200
+
+var errAccountDisabled = errors.New("this account has been disabled")
+
+type Application struct {
+	users            database.UserStore
+	products         database.ProductStore
+	categories       database.CategoryStore
+	orders           database.OrderStore
+	inventory        database.InventoryStore
+	warehouses       database.WarehouseStore
+	audit            database.AuditStore
+	mail             mailer.Sender
+	timeouts         config.Timeouts
+	adminEmail       string
+	publicURL        string
+	passwordResetTTL time.Duration
+	privacy          config.Privacy
+	paymentTimeout   time.Duration
+	totpIssuer       string
+	oidc             map[string]*oidc.Provider
+	attempts         database.LoginAttemptStore
+	lockout          config.Lockout
+	lockoutNotifiers []LockoutNotifier
+	passwords        *passwords.Hasher
+	policy           *passwords.Policy
+	searchIndex      *search.IndexedStore
 }
 
-func VerifyPassword(userPassword string, givenPassword string) (bool, string) {
-	err := bcrypt.CompareHashAndPassword([]byte(givenPassword), []byte(userPassword))
-	valid := true
-	msg := ""
-
+func NewApplication(stores database.Stores, mail mailer.Sender, cfg config.Config) (*Application, error) {
+	policy, err := passwords.NewPolicy(cfg.Passwords)
 	if err != nil {
-		msg = "Login or Password is incorrect"
-		valid = false
+		return nil, err
 	}
-	return valid, msg
-
+	catalog := search.NewIndexedStore(stores.Products, search.NewIndex())
+	app := &Application{users: stores.Users, products: catalog, searchIndex: catalog,
+		categories: stores.Categories, orders: stores.Orders, inventory: stores.Inventory,
+		warehouses: stores.Warehouses,
+		audit:      stores.Audit, mail: mail, timeouts: cfg.Timeouts, adminEmail: cfg.AdminEmail,
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"), passwordResetTTL: cfg.Tokens.PasswordResetTTL,
+		totpIssuer: cfg.Tokens.TOTPIssuer, oidc: oidc.NewProviders(cfg.OIDC, cfg.PublicURL),
+		attempts: stores.LoginAttempts, lockout: cfg.Lockout,
+		passwords: passwords.NewHasher(cfg.Passwords), policy: policy, privacy: cfg.Privacy,
+		paymentTimeout: cfg.Orders.PaymentTimeout}
+	app.AddLockoutNotifier(mailLockoutNotifier{mail: mail})
+	if cfg.Lockout.WebhookURL != "" {
+		app.AddLockoutNotifier(WebhookNotifier{URL: cfg.Lockout.WebhookURL, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	return app, nil
 }
 
-func Signup() gin.HandlerFunc {
+func (app *Application) Signup() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
 		defer cancel()
 
 		var user models.User
@@ -52,25 +86,34 @@ func Signup() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		user.Email = normalizeEmail(user.Email)
 		validationErr := Validate.Struct(user)
 		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr})
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if err := app.policy.Check(user.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+		count, err := app.users.CountByEmail(ctx, user.Email)
 		if err != nil {
-			log.Panic(err)
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create user"})
 			return
 		}
 
 		if count > 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "user already exists!"})
+			return
 		}
 
-		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
+		count, err = app.users.CountByPhone(ctx, user.Phone)
 
 		if err != nil {
-			log.Panic(err)
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create user"})
 			return
 		}
 
@@ -79,157 +122,280 @@ func Signup() gin.HandlerFunc {
 			return
 		}
 
-		password := HashPassword(user.Password)
+		password, err := app.passwords.Hash(user.Password)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create user"})
+			return
+		}
 		user.Password = password
 		user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.UserID = user.ID.Hex()
+		user.TokenVersion = 0
+		user.EmailVerified = false
+		user.TwoFactorEnabled = false
+		user.Role = models.RoleCustomer
 
-		token, refreshToken, _ := tokens.TokenGenerator(user.Email, user.FirstName, user.LastName, user.UserID)
+		token, _, _ := tokens.TokenGenerator(user)
 
 		user.Token = token
-		user.RefreshToken = refreshToken
 		user.UserCart = make([]models.ProductUser, 0)
 		user.AddressDetails = make([]models.Address, 0)
 		user.OrderStatus = make([]models.Order, 0)
-		_, insertErr := UserCollection.InsertOne(ctx, user)
+		user.Identities = make([]models.Identity, 0)
+		insertErr := app.users.Create(ctx, &user)
 		if insertErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create user"})
 			return
 		}
+		if err = app.sendEmailVerification(ctx, user); err != nil {
+			log.Println(err)
+		}
 
 		c.JSON(http.StatusCreated, "Successfully signed up")
 
 	}
 }
 
-func Login() gin.HandlerFunc {
+// roleFor is the role earned by verifying email: admin for the configured
+// admin email, customer otherwise.
+func (app *Application) roleFor(email string) string {
+	if app.adminEmail != "" && normalizeEmail(email) == normalizeEmail(app.adminEmail) {
+		return models.RoleAdmin
+	}
+	return models.RoleCustomer
+}
+
+func (app *Application) Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
 		defer cancel()
 
 		var user models.User
-		var foundUser models.User
 		if err := c.BindJSON(&user); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err})
 			return
 		}
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		email := normalizeEmail(user.Email)
+		if app.loginBlocked(ctx, c, email) {
+			return
+		}
+		foundUser, err := app.users.FindByEmail(ctx, email)
 
 		if err != nil {
+			app.loginFailed(ctx, c, email, nil)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or password incorrect"})
 			return
 		}
 
-		PasswordIsValid, msg := VerifyPassword(user.Password, foundUser.Password)
+		PasswordIsValid, needsRehash := app.passwords.Verify(user.Password, foundUser.Password)
 
 		if !PasswordIsValid {
+			msg := "Login or Password is incorrect"
+			app.loginFailed(ctx, c, email, &foundUser)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			log.Println(msg)
 			return
 		}
-		token, refreshToken, _ := tokens.TokenGenerator(foundUser.Email, foundUser.FirstName,
-			foundUser.LastName, foundUser.UserID)
+		if needsRehash {
+			app.rehashPassword(ctx, foundUser.UserID, user.Password)
+		}
+		if !foundUser.TwoFactorEnabled {
+			app.loginSucceeded(ctx, email)
+		}
+		app.completeLogin(ctx, c, foundUser)
+	}
+}
 
-		tokens.UpdateAllTokens(token, refreshToken, foundUser.UserID)
+// rehashPassword moves a stored hash to the configured algorithm and cost
+// while the plain password is at hand. A failure only means it is retried
+// at the next login.
+func (app *Application) rehashPassword(ctx context.Context, userID, password string) {
+	hash, err := app.passwords.Hash(password)
+	if err == nil {
+		err = app.users.UpdatePasswordHash(ctx, userID, hash)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
 
-		c.JSON(http.StatusFound, foundUser)
+// completeLogin answers a successful first login step. Disabled users are
+// refused, users with 2FA get a challenge for LoginTwoFactor and everyone
+// else gets their tokens.
+func (app *Application) completeLogin(ctx context.Context, c *gin.Context, user models.User) {
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": errAccountDisabled.Error()})
+		return
+	}
+	if user.TwoFactorEnabled {
+		challenge, err := tokens.TwoFactorChallengeToken(user)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed, please try again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge_token": challenge})
 		return
+	}
 
+	token, refreshToken, err := app.issueTokens(ctx, user)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed, please try again"})
+		return
 	}
+	c.JSON(http.StatusFound, loginResponse{UserProfile: user.Profile(), Token: token, RefreshToken: refreshToken})
 }
 
-func ProductViewerAdmin() gin.HandlerFunc {
+// loginResponse is the profile of a freshly logged in user along with their
+// tokens.
+type loginResponse struct {
+	models.UserProfile
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (app *Application) RefreshToken() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
 		defer cancel()
-		var products models.Product
-		if err := c.BindJSON(&products); err != nil {
+
+		var body struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		products.ProductID = primitive.NewObjectID()
-		_, err := ProductCollection.InsertOne(ctx, products)
+
+		token, refreshToken, err := tokens.RefreshTokens(ctx, app.users, body.RefreshToken)
+		if errors.Is(err, tokens.ErrInvalidRefreshToken) || errors.Is(err, tokens.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "not inserted"})
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to refresh the token"})
+			return
 		}
-		c.JSON(http.StatusOK, "successfully added")
+
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
 	}
 }
 
-func SearchProduct() gin.HandlerFunc {
+func (app *Application) Logout() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var productList []models.Product
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
 		defer cancel()
 
-		cursor, err := ProductCollection.Find(ctx, bson.D{{}})
+		err := app.users.RevokeTokens(ctx, c.GetString("uid"))
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, "something went wrong,"+
-				"please try after sometime.")
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to log out"})
 			return
 		}
+		c.JSON(http.StatusOK, "Successfully logged out")
+	}
+}
 
-		err = cursor.All(ctx, &productList)
-		if err != nil {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+// JWKS publishes the public token signing keys so other services can verify
+// our tokens.
+func (app *Application) JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, tokens.JWKS())
+	}
+}
+
+func (app *Application) ProductViewerAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+		var products models.Product
+		if err := c.BindJSON(&products); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		defer cursor.Close(ctx)
-
-		if err := cursor.Err(); err != nil {
-			log.Println(err)
-			c.IndentedJSON(400, "Invalid")
+		if err := Validate.Struct(products); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		c.IndentedJSON(http.StatusOK, productList)
-
+		var err error
+		if products.CategoryIDs, err = app.checkProductCategories(ctx, products.CategoryIDs); err != nil {
+			app.productCategoryError(c, err)
+			return
+		}
+		if products.Variants, err = prepareVariants(nil, products.Variants); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		products.ProductID = primitive.NewObjectID()
+		products.Version = 1
+		products.Archived = false
+		err = app.products.Create(ctx, &products)
+		if errors.Is(err, database.ErrSKUTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "not inserted"})
+			return
+		}
+		c.Header("Location", "/admin/products/"+products.ProductID.Hex())
+		c.Header("ETag", productETag(products))
+		c.JSON(http.StatusOK, "successfully added")
 	}
 }
 
-func SearchProductByQuery() gin.HandlerFunc {
+// SearchProduct lists the catalog a page at a time. ?category= narrows it
+// to a category and its subcategories; see listProducts for the paging,
+// sorting and filtering parameters.
+func (app *Application) SearchProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var searchProducts []models.Product
-		queryParam := c.Query("name")
+		app.listProducts(c, c.Query("category"))
+	}
+}
 
-		if queryParam == "" {
-			log.Println("query is empty")
-			c.Header("Content-type", "application/json")
+// SearchProductByQuery finds products by the words of ?name=, most relevant
+// first, a page at a time. Words match regardless of case and accents, by
+// their stem, by their start and with a typo or two.
+func (app *Application) SearchProductByQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := strings.TrimSpace(c.Query("name"))
+		if query == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "query is empty"})
-			c.Abort()
 			return
 		}
-
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		searchQueryDB, err := ProductCollection.Find(ctx, bson.M{"product_name": bson.M{"$regex": queryParam}})
-
-		if err != nil {
-			c.IndentedJSON(404, "something went wrong while fetching the data.")
+		if len(query) > maxSearchLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "query must be at most " +
+				strconv.Itoa(maxSearchLength) + " characters"})
 			return
 		}
-
-		err = searchQueryDB.All(ctx, &searchProducts)
+		page, perPage, err := parsePage(c)
 		if err != nil {
-			log.Println(err)
-			c.IndentedJSON(400, "Invalid")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		defer searchQueryDB.Close(ctx)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
 
-		if err := searchQueryDB.Err(); err != nil {
+		found, total, err := app.searchIndex.Search(ctx, query, (page-1)*perPage, perPage)
+		if err != nil {
 			log.Println(err)
-			c.IndentedJSON(400, "Invalid Request")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the products found"})
 			return
 		}
 
-		c.IndentedJSON(200, searchProducts)
-
+		response := gin.H{"products": found, "total": total, "page": page, "per_page": perPage,
+			"next": nil}
+		if page*perPage < total {
+			response["next"] = nextLink(c, "page", strconv.Itoa(page+1))
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/mailer"
+	"github.com/mukulmantosh/ecommerce-gin/middleware"
+	"github.com/mukulmantosh/ecommerce-gin/tokens"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default().Tokens
+	cfg.SecretKey = "test secret"
+	if err := tokens.Configure(cfg); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// capturedMail keeps the mail it is asked to send.
+type capturedMail struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *capturedMail) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// newTestApp builds the application on an empty memory database. Passwords
+// are hashed with the cheapest parameters and failed logins add no backoff,
+// to keep the tests fast.
+func newTestApp(t *testing.T) (*Application, database.Stores, *capturedMail) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Passwords.Argon2Time, cfg.Passwords.Argon2MemoryKiB, cfg.Passwords.Argon2Threads = 1, 64, 1
+	cfg.Lockout.BaseDelay, cfg.Lockout.MaxDelay = 0, 0
+
+	db := database.NewMemoryDB()
+	stores := database.Stores{
+		Users:         database.NewMemoryUserStore(db),
+		Products:      database.NewMemoryProductStore(db),
+		Categories:    database.NewMemoryCategoryStore(db),
+		Orders:        database.NewMemoryOrderStore(db),
+		Inventory:     database.NewMemoryInventoryStore(db),
+		Warehouses:    database.NewMemoryWarehouseStore(db),
+		Audit:         database.NewMemoryAuditStore(db),
+		LoginAttempts: database.NewMemoryLoginAttemptStore(db),
+	}
+	mail := &capturedMail{}
+	app, err := NewApplication(stores, mail, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, stores, mail
+}
+
+// accountRouter serves signup and login, and the authenticated routes
+// registered by protected.
+func accountRouter(app *Application, stores database.Stores, protected func(router *gin.Engine)) *gin.Engine {
+	router := gin.New()
+	router.POST("/users/signup", app.Signup())
+	router.POST("/users/login", app.Login())
+	router.Use(middleware.Authentication(stores.Users, time.Second))
+	if protected != nil {
+		protected(router)
+	}
+	return router
+}
+
+// serve sends router a request with body encoded as JSON, and the access
+// token when one is given.
+func serve(t *testing.T, router http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("token", token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+}
+
+func signupBody(email, phone, password string) gin.H {
+	return gin.H{"first_name": "Ann", "last_name": "Lee", "email": email, "phone": phone, "password": password}
+}
+
+// signup creates a customer with the password "correct horse".
+func signup(t *testing.T, router http.Handler, email, phone string) {
+	t.Helper()
+	if w := serve(t, router, http.MethodPost, "/users/signup", "", signupBody(email, phone, "correct horse")); w.Code != http.StatusCreated {
+		t.Fatalf("signup: %d %s", w.Code, w.Body)
+	}
+}
+
+// login returns an access token for a customer made by signup.
+func login(t *testing.T, router http.Handler, email string) string {
+	t.Helper()
+	w := serve(t, router, http.MethodPost, "/users/login", "", gin.H{"email": email, "password": "correct horse"})
+	var response struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &response)
+	if response.Token == "" {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	return response.Token
+}
+
+func TestSignup(t *testing.T) {
+	app, stores, _ := newTestApp(t)
+	router := accountRouter(app, stores, nil)
+	signup(t, router, "ann@example.com", "100")
+
+	tests := []struct {
+		name string
+		body gin.H
+		want int
+	}{
+		{"new user", signupBody("bob@example.com", "200", "correct horse"), http.StatusCreated},
+		{"email taken", signupBody("ann@example.com", "300", "correct horse"), http.StatusBadRequest},
+		{"phone taken", signupBody("cy@example.com", "100", "correct horse"), http.StatusBadRequest},
+		{"invalid email", signupBody("not an email", "300", "correct horse"), http.StatusBadRequest},
+		{"missing name", gin.H{"email": "cy@example.com", "phone": "300", "password": "correct horse"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(t, router, http.MethodPost, "/users/signup", "", tt.body); w.Code != tt.want {
+				t.Errorf("signup: %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	app, stores, _ := newTestApp(t)
+	router := accountRouter(app, stores, func(router *gin.Engine) {
+		router.GET("/listcart", app.GetItemFromCart())
+	})
+	signup(t, router, "ann@example.com", "100")
+
+	tests := []struct {
+		name      string
+		email     string
+		password  string
+		wantToken bool
+	}{
+		{"correct password", "ann@example.com", "correct horse", true},
+		{"wrong password", "ann@example.com", "battery staple", false},
+		{"unknown email", "bob@example.com", "correct horse", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, router, http.MethodPost, "/users/login", "", gin.H{"email": tt.email, "password": tt.password})
+			var response struct {
+				Token        string `json:"token"`
+				RefreshToken string `json:"refresh_token"`
+			}
+			decode(t, w, &response)
+			if got := response.Token != "" && response.RefreshToken != ""; got != tt.wantToken {
+				t.Fatalf("login: %d %s, want tokens %v", w.Code, w.Body, tt.wantToken)
+			}
+			if !tt.wantToken {
+				return
+			}
+			if w = serve(t, router, http.MethodGet, "/listcart", response.Token, nil); w.Code != http.StatusOK {
+				t.Errorf("the access token was refused: %d %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	for _, token := range []string{"", "not a token"} {
+		if w := serve(t, router, http.MethodGet, "/listcart", token, nil); w.Code < 400 {
+			t.Errorf("token %q: %d %s, want an error", token, w.Code, w.Body)
+		}
+	}
+}
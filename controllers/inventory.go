@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stockItem is the stock of a product without variants, or of one variant,
+// as listed by GetStock: the totals over all warehouses, then the stock in
+// each warehouse holding any.
+type stockItem struct {
+	ProductID  primitive.ObjectID  `json:"product_id"`
+	VariantID  *primitive.ObjectID `json:"variant_id,omitempty"`
+	SKU        string              `json:"sku,omitempty"`
+	OnHand     int                 `json:"on_hand"`
+	Reserved   int                 `json:"reserved"`
+	Available  int                 `json:"available"`
+	Warehouses []models.StockLevel `json:"warehouses"`
+}
+
+// GetStock returns the stock of each of a product's variants, or of the
+// product itself when it has none. Items never stocked show zero.
+func (app *Application) GetStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		product, ok := app.findProductParam(ctx, c)
+		if !ok {
+			return
+		}
+		levels, err := app.inventory.Levels(ctx, product.ProductID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the stock"})
+			return
+		}
+		item := func(variantID *primitive.ObjectID, sku string) stockItem {
+			item := stockItem{ProductID: product.ProductID, VariantID: variantID, SKU: sku,
+				Warehouses: make([]models.StockLevel, 0)}
+			for _, level := range levels {
+				if variantID == nil && level.VariantID == nil ||
+					variantID != nil && level.VariantID != nil && *variantID == *level.VariantID {
+					item.OnHand += level.OnHand
+					item.Reserved += level.Reserved
+					item.Available += level.Available
+					item.Warehouses = append(item.Warehouses, level)
+				}
+			}
+			return item
+		}
+
+		items := make([]stockItem, 0, len(product.Variants))
+		for i := range product.Variants {
+			variant := &product.Variants[i]
+			items = append(items, item(&variant.VariantID, variant.SKU))
+		}
+		if len(product.Variants) == 0 {
+			items = append(items, item(nil, ""))
+		}
+		c.JSON(http.StatusOK, items)
+	}
+}
+
+// stockAdjustmentBody is a stock adjustment as sent to AdjustStock.
+type stockAdjustmentBody struct {
+	WarehouseID primitive.ObjectID  `json:"warehouse_id"`
+	VariantID   *primitive.ObjectID `json:"variant_id"`
+	Delta       int                 `json:"delta"`
+	Reason      string              `json:"reason"`
+	Note        string              `json:"note" validate:"max=500"`
+}
+
+// AdjustStock changes the stock on hand in warehouse_id of a product, or of
+// the variant given by variant_id, by delta for one of models.StockReasons.
+func (app *Application) AdjustStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body stockAdjustmentBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Delta == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "delta must not be zero"})
+			return
+		}
+		if !isStockReason(body.Reason) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be one of " + strings.Join(models.StockReasons, ", ")})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		product, ok := app.findProductParam(ctx, c)
+		if !ok {
+			return
+		}
+		if err := checkStockVariant(product, body.VariantID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		found, err := app.hasWarehouse(ctx, body.WarehouseID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the warehouses"})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "warehouse_id names a warehouse that does not exist"})
+			return
+		}
+
+		adjustment := models.StockAdjustment{
+			ID:          primitive.NewObjectID(),
+			WarehouseID: body.WarehouseID,
+			ProductID:   product.ProductID,
+			VariantID:   body.VariantID,
+			Delta:       body.Delta,
+			Reason:      body.Reason,
+			Note:        strings.TrimSpace(body.Note),
+			ActorID:     c.GetString("uid"),
+			At:          time.Now(),
+		}
+		level, err := app.inventory.Adjust(ctx, &adjustment)
+		if errors.Is(err, database.ErrInsufficientStock) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the stock on hand cannot drop below the units reserved"})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to adjust the stock"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"adjustment": adjustment, "stock": level})
+	}
+}
+
+// ListStockAdjustments returns a product's stock adjustments, newest first.
+func (app *Application) ListStockAdjustments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		product, ok := app.findProductParam(ctx, c)
+		if !ok {
+			return
+		}
+		adjustments, err := app.inventory.Adjustments(ctx, product.ProductID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the stock adjustments"})
+			return
+		}
+		c.JSON(http.StatusOK, adjustments)
+	}
+}
+
+func isStockReason(reason string) bool {
+	for _, known := range models.StockReasons {
+		if reason == known {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStockVariant makes sure variantID names a variant of product, or is
+// nil for a product without variants.
+func checkStockVariant(product models.Product, variantID *primitive.ObjectID) error {
+	if variantID == nil {
+		if len(product.Variants) > 0 {
+			return database.ErrVariantRequired
+		}
+		return nil
+	}
+	for _, variant := range product.Variants {
+		if variant.VariantID == *variantID {
+			return nil
+		}
+	}
+	return database.ErrCantFindVariant
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/mailer"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LockoutNotifier is told whenever an account gets locked after too many
+// failed logins, e.g. to warn its owner or alert the security team.
+type LockoutNotifier interface {
+	AccountLocked(ctx context.Context, user models.User, until time.Time) error
+}
+
+// AddLockoutNotifier registers another hook for locked accounts. The owner
+// is always mailed.
+func (app *Application) AddLockoutNotifier(notifier LockoutNotifier) {
+	app.lockoutNotifiers = append(app.lockoutNotifiers, notifier)
+}
+
+// accountKey is the attempts key of an account. Callers pass the email
+// through normalizeEmail so every spelling of it shares one count.
+func accountKey(email string) string { return "account:" + email }
+
+func addressKey(ip string) string { return "ip:" + ip }
+
+// loginBlocked answers 423 or 429 with a Retry-After header and returns true
+// when the account or the client address is locked, or has failed too
+// recently under the exponential backoff.
+func (app *Application) loginBlocked(ctx context.Context, c *gin.Context, email string) bool {
+	now := time.Now()
+	for _, key := range []string{accountKey(email), addressKey(c.ClientIP())} {
+		attempts, err := app.attempts.Get(ctx, key)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed, please try again"})
+			return true
+		}
+		if attempts.LockedUntil.After(now) {
+			status, msg := http.StatusTooManyRequests, "too many failed logins from this address, try again later"
+			if key == accountKey(email) {
+				status, msg = http.StatusLocked, "too many failed logins, the account is temporarily locked"
+			}
+			retryAfter(c, attempts.LockedUntil.Sub(now))
+			c.JSON(status, gin.H{"error": msg})
+			return true
+		}
+		failures := attempts.Failures
+		if key != accountKey(email) {
+			// Many users can share an address, so it gets as many free
+			// failures as one account before the backoff starts.
+			failures -= app.lockout.MaxFailures
+		}
+		if wait := attempts.LastFailureAt.Add(app.backoff(failures)).Sub(now); wait > 0 {
+			retryAfter(c, wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed logins, slow down"})
+			return true
+		}
+	}
+	return false
+}
+
+// backoff is the wait after failures recent failed logins: BaseDelay after
+// the first, doubling after each further one up to MaxDelay.
+func (app *Application) backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := float64(app.lockout.BaseDelay) * math.Pow(2, float64(failures-1))
+	if delay > float64(app.lockout.MaxDelay) {
+		return app.lockout.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func retryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// loginFailed counts a failed login for the email, whether or not it has an
+// account so lockouts do not reveal which emails are registered, and for the
+// client address. It locks whichever reached its limit. user is nil when no
+// account has the email.
+func (app *Application) loginFailed(ctx context.Context, c *gin.Context, email string, user *models.User) {
+	now := time.Now()
+	account, err := app.attempts.RecordFailure(ctx, accountKey(email), now, app.lockout.Window)
+	if err != nil {
+		log.Println(err)
+	} else if account.Failures >= app.lockout.MaxFailures {
+		until := now.Add(app.lockout.Duration)
+		if err = app.attempts.Lock(ctx, account.Key, until); err != nil {
+			log.Println(err)
+		} else if user != nil {
+			log.Printf("account %s locked until %s after %d failed logins", user.UserID, until.Format(time.RFC3339), account.Failures)
+			app.notifyLockout(*user, until)
+		}
+	}
+
+	address, err := app.attempts.RecordFailure(ctx, addressKey(c.ClientIP()), now, app.lockout.Window)
+	if err != nil {
+		log.Println(err)
+	} else if address.Failures >= app.lockout.IPMaxFailures {
+		if err = app.attempts.Lock(ctx, address.Key, now.Add(app.lockout.Duration)); err != nil {
+			log.Println(err)
+		} else {
+			log.Printf("client %s blocked after %d failed logins", c.ClientIP(), address.Failures)
+		}
+	}
+}
+
+// loginSucceeded clears the account's failures. The address keeps its count
+// so one valid account cannot launder a stuffing run.
+func (app *Application) loginSucceeded(ctx context.Context, email string) {
+	if err := app.attempts.Reset(ctx, accountKey(email)); err != nil {
+		log.Println(err)
+	}
+}
+
+// notifyLockout runs the lockout hooks in the background, so a slow hook
+// does not hold up the login response.
+func (app *Application) notifyLockout(user models.User, until time.Time) {
+	notifiers := app.lockoutNotifiers
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+		for _, notifier := range notifiers {
+			if err := notifier.AccountLocked(ctx, user, until); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+}
+
+// UnlockAccount clears the failed logins and any lock of the user named by
+// ?userID=. The unlock is written to the audit log.
+func (app *Application) UnlockAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID := c.Query("userID")
+		if userQueryID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user id is empty"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, userQueryID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		key := accountKey(normalizeEmail(user.Email))
+		record := newAuditRecord(c, user.UserID, fmt.Sprintf("cleared %s, unlocked by %s", key, c.GetString("uid")))
+		if err = app.audit.Record(ctx, record); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to write the audit record"})
+			return
+		}
+		if err = app.attempts.Reset(ctx, key); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to unlock the account"})
+			return
+		}
+		c.JSON(http.StatusOK, "Account unlocked")
+	}
+}
+
+// mailLockoutNotifier warns the account owner.
+type mailLockoutNotifier struct {
+	mail mailer.Sender
+}
+
+func (n mailLockoutNotifier) AccountLocked(ctx context.Context, user models.User, until time.Time) error {
+	return n.mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your account has been locked",
+		Body: fmt.Sprintf("Hi %s,\n\nThere were too many failed attempts to log in to your account, so it "+
+			"is locked until %s.\nIf this was not you, consider resetting your password.\n",
+			user.FirstName, until.Format(time.RFC1123)),
+	})
+}
+
+// WebhookNotifier posts a JSON event for every locked account to URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n WebhookNotifier) AccountLocked(ctx context.Context, user models.User, until time.Time) error {
+	body, err := json.Marshal(gin.H{
+		"event":        "account_locked",
+		"user_id":      user.UserID,
+		"email":        user.Email,
+		"locked_until": until,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("lockout webhook: %s", resp.Status)
+	}
+	return nil
+}
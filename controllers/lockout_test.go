@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordedAudit keeps the audit records written.
+type recordedAudit struct {
+	records []models.AuditRecord
+}
+
+func (a *recordedAudit) Record(ctx context.Context, record models.AuditRecord) error {
+	a.records = append(a.records, record)
+	return nil
+}
+
+// uidFromHeader stands in for the Authentication middleware, taking the
+// logged in user from the uid header set by asUser.
+func uidFromHeader(c *gin.Context) {
+	c.Set("uid", c.GetHeader("uid"))
+}
+
+func asUser(router http.Handler, uid string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("uid", uid)
+		router.ServeHTTP(w, r)
+	})
+}
+
+// TestLockoutKeyIgnoresEmailCase covers accounts stored with a mixed-case
+// email, which share one lockout count with the lowercase login form.
+func TestLockoutKeyIgnoresEmailCase(t *testing.T) {
+	app, stores, _ := newTestApp(t)
+	audit := &recordedAudit{}
+	app.audit = audit
+	ctx := context.Background()
+
+	password, err := app.passwords.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := primitive.NewObjectID()
+	user := models.User{ID: id, UserID: id.Hex(), Email: "Ann@Example.com", Password: password,
+		EmailVerified: true, Role: models.RoleCustomer}
+	if err = stores.Users.Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	key := accountKey("ann@example.com")
+
+	router := gin.New()
+	router.Use(uidFromHeader)
+	router.DELETE("/users/me", app.DeleteAccount())
+	router.POST("/admin/unlock", app.UnlockAccount())
+
+	if w := serve(t, asUser(router, user.UserID), http.MethodDelete, "/users/me", "", gin.H{"password": "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("delete with a wrong password: %d %s", w.Code, w.Body)
+	}
+	attempts, err := stores.LoginAttempts.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 1 {
+		t.Fatalf("failures under %s = %d, want 1", key, attempts.Failures)
+	}
+
+	if err = stores.LoginAttempts.Lock(ctx, key, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(t, asUser(router, "admin1"), http.MethodPost, "/admin/unlock?userID="+user.UserID, "", nil); w.Code != http.StatusOK {
+		t.Fatalf("unlock: %d %s", w.Code, w.Body)
+	}
+	if attempts, err = stores.LoginAttempts.Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 0 || !attempts.LockedUntil.IsZero() {
+		t.Errorf("after unlocking %s: %+v, want no failures and no lock", key, attempts)
+	}
+	if len(audit.records) != 1 {
+		t.Fatalf("got %d audit records, want 1", len(audit.records))
+	}
+	if detail := audit.records[0].Detail; !strings.Contains(detail, key) || !strings.Contains(detail, "admin1") {
+		t.Errorf("audit detail = %q, want the key and the admin", detail)
+	}
+}
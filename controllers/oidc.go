@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"github.com/mukulmantosh/ecommerce-gin/oidc"
+	"github.com/mukulmantosh/ecommerce-gin/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const oidcFlowCookie = "oidc_flow"
+
+var (
+	errOIDCEmailMissing = errors.New("the provider did not share a valid email address")
+	errOIDCEmailTaken   = errors.New("an account with this email already exists, log in with your password")
+)
+
+// OIDCLogin sends the user to the login page of the provider named in the
+// path, using the authorization code flow with PKCE.
+func (app *Application) OIDCLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := app.oidc[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": oidc.ErrUnknownProvider.Error()})
+			return
+		}
+
+		var flow tokens.OIDCFlow
+		var err error
+		for _, value := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+			if *value, err = oidc.NewVerifier(); err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to start the login"})
+				return
+			}
+		}
+		flow.Provider = provider.Name
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		authURL, err := provider.AuthCodeURL(ctx, flow.State, flow.Nonce, flow.Verifier)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "the login provider is unavailable"})
+			return
+		}
+		signedFlow, err := tokens.SignOIDCFlow(flow)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to start the login"})
+			return
+		}
+
+		app.setOIDCFlowCookie(c, provider.Name, signedFlow, tokens.OIDCFlowMaxAge())
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback finishes a provider login. The provider account is matched
+// by its subject; on first login it is linked to the account with the same
+// email if the provider verified that email, and otherwise a new account is
+// created. The user then gets our own tokens, or a 2FA challenge.
+func (app *Application) OIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := app.oidc[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": oidc.ErrUnknownProvider.Error()})
+			return
+		}
+		signedFlow, _ := c.Cookie(oidcFlowCookie)
+		app.setOIDCFlowCookie(c, provider.Name, "", -1)
+
+		if reason := c.Query("error"); reason != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the provider refused the login: " + reason})
+			return
+		}
+		flow, err := tokens.ParseOIDCFlow(signedFlow, provider.Name)
+		if err != nil || subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(flow.State)) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": tokens.ErrInvalidOIDCFlow.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		claims, err := provider.Exchange(ctx, c.Query("code"), flow.Verifier, flow.Nonce)
+		if errors.Is(err, oidc.ErrInvalidIDToken) {
+			log.Println(err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": oidc.ErrInvalidIDToken.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "the login provider is unavailable"})
+			return
+		}
+
+		user, err := app.oidcUser(ctx, provider.Name, claims)
+		if errors.Is(err, errOIDCEmailMissing) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, errOIDCEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed, please try again"})
+			return
+		}
+		app.completeLogin(ctx, c, user)
+	}
+}
+
+// oidcUser finds, links or creates the user for a provider account.
+func (app *Application) oidcUser(ctx context.Context, provider string, claims *oidc.Claims) (models.User, error) {
+	user, err := app.users.FindByIdentity(ctx, provider, claims.Subject)
+	if err == nil || !errors.Is(err, database.ErrUserNotFound) {
+		return user, err
+	}
+
+	email := normalizeEmail(claims.Email)
+	if Validate.Var(email, "required,email") != nil {
+		return user, errOIDCEmailMissing
+	}
+	identity := models.Identity{Provider: provider, Subject: claims.Subject, LinkedAt: time.Now()}
+
+	user, err = app.users.FindByEmail(ctx, email)
+	if err == nil {
+		// Only a provider that verified the address may claim the account.
+		if !claims.EmailVerified {
+			return user, errOIDCEmailTaken
+		}
+		if err = app.users.LinkIdentity(ctx, user.UserID, identity); err != nil {
+			return user, err
+		}
+		if !user.EmailVerified {
+			if err = app.users.MarkEmailVerified(ctx, user.UserID, email); err != nil {
+				return user, err
+			}
+			if err = app.promoteVerifiedAdmin(ctx, user.UserID, email); err != nil {
+				return user, err
+			}
+			user.EmailVerified = true
+			if app.roleFor(email) == models.RoleAdmin {
+				user.Role = models.RoleAdmin
+			}
+		}
+		user.Identities = append(user.Identities, identity)
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		return user, err
+	}
+
+	user = models.User{
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+		Email:         email,
+		EmailVerified: claims.EmailVerified,
+		Role:          models.RoleCustomer,
+	}
+	if claims.EmailVerified {
+		user.Role = app.roleFor(email)
+	}
+	user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.ID = primitive.NewObjectID()
+	user.UserID = user.ID.Hex()
+	user.UserCart = make([]models.ProductUser, 0)
+	user.AddressDetails = make([]models.Address, 0)
+	user.OrderStatus = make([]models.Order, 0)
+	user.Identities = make([]models.Identity, 0)
+	if err = app.users.Create(ctx, &user); err != nil {
+		return user, err
+	}
+	if err = app.users.LinkIdentity(ctx, user.UserID, identity); err != nil {
+		return user, err
+	}
+	user.Identities = append(user.Identities, identity)
+
+	if !user.EmailVerified {
+		if err = app.sendEmailVerification(ctx, user); err != nil {
+			log.Println(err)
+		}
+	}
+	return user, nil
+}
+
+// setOIDCFlowCookie scopes the flow cookie to the provider's login paths.
+// Lax is required: the callback is a top-level redirect from the provider.
+func (app *Application) setOIDCFlowCookie(c *gin.Context, provider, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcFlowCookie, value, maxAge, "/users/oidc/"+provider, "",
+		strings.HasPrefix(app.publicURL, "https://"), true)
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ListOrders returns the caller's orders.
+func (app *Application) ListOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the orders"})
+			return
+		}
+		orders := user.OrderStatus
+		if orders == nil {
+			orders = make([]models.Order, 0)
+		}
+		c.JSON(http.StatusOK, orders)
+	}
+}
+
+// CancelOrder cancels one of the caller's orders that has not shipped yet,
+// releasing its stock.
+func (app *Application) CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		order, userID, ok := app.findOrderParam(ctx, c)
+		if !ok {
+			return
+		}
+		if userID != c.GetString("uid") {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindOrder.Error()})
+			return
+		}
+		app.moveOrder(ctx, c, order, models.OrderCancelled, models.OrderPendingPayment, models.OrderPlaced)
+	}
+}
+
+// CancelOrderAdmin cancels any order that has not shipped yet.
+func (app *Application) CancelOrderAdmin() gin.HandlerFunc {
+	return app.moveOrderAdmin(models.OrderCancelled, models.OrderPendingPayment, models.OrderPlaced)
+}
+
+// MarkOrderPaid places an order whose digital payment has come in.
+func (app *Application) MarkOrderPaid() gin.HandlerFunc {
+	return app.moveOrderAdmin(models.OrderPlaced, models.OrderPendingPayment)
+}
+
+// ShipOrder ships a placed order, taking its stock off the shelf.
+func (app *Application) ShipOrder() gin.HandlerFunc {
+	return app.moveOrderAdmin(models.OrderShipped, models.OrderPlaced)
+}
+
+// moveOrderAdmin moves the order named by :orderID to status to from one of
+// the statuses from, and records it in the audit log.
+func (app *Application) moveOrderAdmin(to string, from ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		order, userID, ok := app.findOrderParam(ctx, c)
+		if !ok {
+			return
+		}
+		if err := app.audit.Record(ctx, newAuditRecord(c, userID, "order "+order.OrderID.Hex()+" "+to)); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to write the audit record"})
+			return
+		}
+		app.moveOrder(ctx, c, order, to, from...)
+	}
+}
+
+// moveOrder moves order to status to, provided it is in one of the statuses
+// from, and answers with the updated order.
+func (app *Application) moveOrder(ctx context.Context, c *gin.Context, order models.Order, to string, from ...string) {
+	for _, status := range from {
+		if order.Status != status {
+			continue
+		}
+		err := app.orders.UpdateOrderStatus(ctx, order.OrderID, status, to)
+		if errors.Is(err, database.ErrOrderStatusChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the order"})
+			return
+		}
+		order.Status = to
+		c.JSON(http.StatusOK, order)
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "the order cannot be " + to + " while it is " + orderStatus(order)})
+}
+
+func orderStatus(order models.Order) string {
+	if order.Status == "" {
+		return "untracked"
+	}
+	return order.Status
+}
+
+// findOrderParam loads the order named by the :orderID path parameter and
+// the id of its user, or answers 404.
+func (app *Application) findOrderParam(ctx context.Context, c *gin.Context) (models.Order, string, bool) {
+	orderID, err := primitive.ObjectIDFromHex(c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindOrder.Error()})
+		return models.Order{}, "", false
+	}
+	order, userID, err := app.orders.FindOrder(ctx, orderID)
+	if errors.Is(err, database.ErrCantFindOrder) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return order, "", false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the order"})
+		return order, "", false
+	}
+	return order, userID, true
+}
+
+// RunPaymentTimeouts cancels the digital orders left unpaid past their
+// payment due time every interval until ctx is done, releasing their stock.
+func (app *Application) RunPaymentTimeouts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		app.cancelUnpaid(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *Application) cancelUnpaid(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, app.timeouts.Request)
+	defer cancel()
+
+	ids, err := app.orders.UnpaidOrders(ctx, time.Now())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, id := range ids {
+		err = app.orders.UpdateOrderStatus(ctx, id, models.OrderPendingPayment, models.OrderCancelled)
+		// The order was paid or cancelled meanwhile.
+		if errors.Is(err, database.ErrOrderStatusChanged) {
+			continue
+		}
+		if err != nil {
+			log.Printf("cancelling unpaid order %s: %v", id.Hex(), err)
+		}
+	}
+}
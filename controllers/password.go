@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/mailer"
+	"github.com/mukulmantosh/ecommerce-gin/tokens"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ForgotPassword mails a single-use reset link. It answers the same way
+// whether or not the email is registered, so it cannot be used to probe for
+// accounts.
+func (app *Application) ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		if err := app.sendPasswordReset(ctx, normalizeEmail(body.Email)); err != nil {
+			log.Println(err)
+		}
+		c.JSON(http.StatusAccepted, "If the account exists, a password reset link has been sent")
+	}
+}
+
+func (app *Application) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := app.users.FindByEmail(ctx, email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, hash, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(app.passwordResetTTL)
+	if err = app.users.SetPasswordReset(ctx, user.UserID, hash, expiresAt); err != nil {
+		return err
+	}
+
+	link := app.publicURL + "/users/password/reset?token=" + url.QueryEscape(token)
+	return app.mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse this link to choose a new password:\n%s\n\n"+
+			"It expires in %s and works once. If you did not ask for it, ignore this mail.\n",
+			user.FirstName, link, app.passwordResetTTL),
+	})
+}
+
+// ResetPassword sets a new password from a mailed reset token. It logs the
+// user out everywhere by revoking every token issued before the reset.
+func (app *Application) ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Token    string `json:"token" binding:"required"`
+			Password string `json:"password"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := app.policy.Check(body.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hash, err := app.passwords.Hash(body.Password)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to reset the password"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		err = app.users.ResetPassword(ctx, tokens.HashOpaqueToken(body.Token), hash, time.Now())
+		if errors.Is(err, database.ErrResetTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to reset the password"})
+			return
+		}
+		c.JSON(http.StatusOK, "Password has been reset, please log in again")
+	}
+}
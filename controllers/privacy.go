@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/mailer"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"time"
+)
+
+// userExport is the machine-readable copy of everything we hold about a
+// user. The profile includes their addresses and linked logins.
+type userExport struct {
+	ExportedAt time.Time            `json:"exported_at"`
+	Profile    models.UserProfile   `json:"profile"`
+	Cart       []models.ProductUser `json:"cart"`
+	Orders     []models.Order       `json:"orders"`
+}
+
+// ExportUserData returns the logged in user's data as a JSON download.
+func (app *Application) ExportUserData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to export the data"})
+			return
+		}
+		export := userExport{ExportedAt: time.Now().UTC(), Profile: user.Profile(),
+			Cart: user.UserCart, Orders: user.OrderStatus}
+		if export.Cart == nil {
+			export.Cart = make([]models.ProductUser, 0)
+		}
+		if export.Orders == nil {
+			export.Orders = make([]models.Order, 0)
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="user-data-%s.json"`, user.UserID))
+		c.Header("Cache-Control", "no-store")
+		c.IndentedJSON(http.StatusOK, export)
+	}
+}
+
+// DeleteAccount schedules the erasure of the logged in user's personal data
+// after the grace period, once they confirm their password and a second
+// factor if they use one. The user can still log in and cancel until then.
+func (app *Application) DeleteAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Password string `json:"password" binding:"required"`
+			Code     string `json:"code"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, ok := app.reauthenticate(ctx, c, body.Password)
+		if !ok {
+			return
+		}
+		if user.TwoFactorEnabled {
+			if err := app.checkSecondFactor(ctx, user, body.Code); err != nil {
+				app.loginFailed(ctx, c, normalizeEmail(user.Email), &user)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if !user.ErasureScheduledAt.IsZero() {
+			c.JSON(http.StatusAccepted, gin.H{"erasure_scheduled_at": user.ErasureScheduledAt})
+			return
+		}
+
+		at := time.Now().Add(app.privacy.ErasureGracePeriod).UTC()
+		if err := app.audit.Record(ctx, newAuditRecord(c, user.UserID, "erasure scheduled for "+at.Format(time.RFC3339))); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to write the audit record"})
+			return
+		}
+		if err := app.users.ScheduleErasure(ctx, user.UserID, at); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to delete the account"})
+			return
+		}
+		err := app.mail.Send(ctx, mailer.Message{
+			To:      user.Email,
+			Subject: "Your account will be deleted",
+			Body: fmt.Sprintf("Hi %s,\n\nAs requested, your account and personal data will be erased on %s.\n"+
+				"Until then you can log in and cancel the deletion.\n",
+				user.FirstName, at.Format(time.RFC1123)),
+		})
+		if err != nil {
+			log.Println(err)
+		}
+		c.JSON(http.StatusAccepted, gin.H{"erasure_scheduled_at": at})
+	}
+}
+
+// CancelAccountDeletion keeps the logged in user's account after all.
+func (app *Application) CancelAccountDeletion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to cancel the deletion"})
+			return
+		}
+		app.cancelErasure(ctx, c, user)
+	}
+}
+
+// CancelUserErasure is the admin override that stops a pending erasure, for
+// example while the data is under a legal hold.
+func (app *Application) CancelUserErasure() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, ok := app.findUserParam(ctx, c)
+		if !ok {
+			return
+		}
+		app.cancelErasure(ctx, c, user)
+	}
+}
+
+func (app *Application) cancelErasure(ctx context.Context, c *gin.Context, user models.User) {
+	if user.ErasureScheduledAt.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no deletion is pending for this account"})
+		return
+	}
+	if err := app.audit.Record(ctx, newAuditRecord(c, user.UserID, "")); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to write the audit record"})
+		return
+	}
+	if err := app.users.CancelErasure(ctx, user.UserID); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to cancel the deletion"})
+		return
+	}
+	c.JSON(http.StatusOK, "Account deletion cancelled")
+}
+
+// EraseUser is the admin override that erases a user's personal data right
+// away, skipping the grace period.
+func (app *Application) EraseUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, ok := app.findUserParam(ctx, c)
+		if !ok {
+			return
+		}
+		if user.UserID == c.GetString("uid") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot erase your own account"})
+			return
+		}
+		if !user.ErasedAt.IsZero() {
+			c.JSON(http.StatusOK, "Account erased")
+			return
+		}
+		if err := app.eraseUser(ctx, user, newAuditRecord(c, user.UserID, "")); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to erase the account"})
+			return
+		}
+		c.JSON(http.StatusOK, "Account erased")
+	}
+}
+
+// RunErasures carries out the due erasures every interval until ctx is done.
+func (app *Application) RunErasures(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		app.eraseDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *Application) eraseDue(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, app.timeouts.Request)
+	defer cancel()
+
+	ids, err := app.users.DueErasures(ctx, time.Now())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, id := range ids {
+		user, err := app.users.FindByID(ctx, id)
+		if errors.Is(err, database.ErrUserNotFound) {
+			continue
+		}
+		if err == nil {
+			// The user asked for it, so they are recorded as the actor.
+			err = app.eraseUser(ctx, user, models.AuditRecord{
+				ID:           primitive.NewObjectID(),
+				ActorID:      user.UserID,
+				TargetUserID: user.UserID,
+				Action:       "erase",
+				Detail:       "scheduled erasure",
+				At:           time.Now(),
+			})
+		}
+		if err != nil {
+			log.Printf("erasing user %s: %v", id, err)
+		}
+	}
+}
+
+// eraseUser writes record and anonymizes user. Their login failures are
+// forgotten too, since those are keyed by email.
+func (app *Application) eraseUser(ctx context.Context, user models.User, record models.AuditRecord) error {
+	if err := app.audit.Record(ctx, record); err != nil {
+		return err
+	}
+	if err := app.users.Erase(ctx, user.UserID, time.Now()); err != nil {
+		return err
+	}
+	if err := app.attempts.Reset(ctx, accountKey(normalizeEmail(user.Email))); err != nil {
+		log.Println(err)
+	}
+	log.Printf("erased the personal data of user %s", user.UserID)
+	return nil
+}
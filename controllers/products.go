@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// productBody is a product as sent to PUT and PATCH. Fields left out are
+// nil, so PATCH can tell them from zero values.
+type productBody struct {
+	ProductName *string `json:"product_name"`
+	Description *string `json:"description"`
+	Price       *uint64 `json:"price"`
+	Rating      *uint8  `json:"rating"`
+	Image       *string `json:"image"`
+	// CategoryIDs and Variants replace the product's.
+	CategoryIDs *[]primitive.ObjectID `json:"category_ids"`
+	Variants    *[]models.Variant     `json:"variants"`
+	// Version may stand in for an If-Match header.
+	Version *int `json:"version"`
+}
+
+var errDuplicateSKU = errors.New("two variants have the same SKU")
+
+// prepareVariants gives each variant an id, keeping the id of a variant of
+// current sent back by the client so carts holding it are kept, and rejects
+// SKUs used twice.
+func prepareVariants(current, variants []models.Variant) ([]models.Variant, error) {
+	known := make(map[primitive.ObjectID]bool, len(current))
+	for _, variant := range current {
+		known[variant.VariantID] = true
+	}
+	skus := make(map[string]bool, len(variants))
+	prepared := make([]models.Variant, 0, len(variants))
+	for _, variant := range variants {
+		if skus[variant.SKU] {
+			return nil, errDuplicateSKU
+		}
+		skus[variant.SKU] = true
+		if !known[variant.VariantID] {
+			variant.VariantID = primitive.NewObjectID()
+		}
+		known[variant.VariantID] = false
+		if variant.Attributes == nil {
+			variant.Attributes = make(map[string]string)
+		}
+		prepared = append(prepared, variant)
+	}
+	return prepared, nil
+}
+
+func productETag(product models.Product) string {
+	return `"` + strconv.Itoa(product.Version) + `"`
+}
+
+// expectedVersion is the product version the client last saw, from the
+// If-Match header or else the version in body. ok is false when the client
+// sent neither.
+func expectedVersion(c *gin.Context, body *productBody) (version int, ok bool, err error) {
+	if match := strings.TrimSpace(c.GetHeader("If-Match")); match != "" {
+		version, err = strconv.Atoi(strings.Trim(strings.TrimPrefix(match, "W/"), `"`))
+		if err != nil {
+			return 0, false, errors.New("If-Match must be an ETag returned for this product")
+		}
+		return version, true, nil
+	}
+	if body != nil && body.Version != nil {
+		return *body.Version, true, nil
+	}
+	return 0, false, nil
+}
+
+// GetProductAdmin returns a product, archived or not, with its version as
+// the ETag.
+func (app *Application) GetProductAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		product, ok := app.findProductParam(ctx, c)
+		if !ok {
+			return
+		}
+		c.Header("ETag", productETag(product))
+		c.JSON(http.StatusOK, product)
+	}
+}
+
+// ReplaceProduct overwrites a product's name, price, rating, image,
+// categories and variants.
+func (app *Application) ReplaceProduct() gin.HandlerFunc {
+	return app.updateProduct(true)
+}
+
+// PatchProduct changes only the fields given.
+func (app *Application) PatchProduct() gin.HandlerFunc {
+	return app.updateProduct(false)
+}
+
+// updateProduct requires the version the client last saw, through If-Match
+// or the body, and answers 412 when the product has changed since.
+func (app *Application) updateProduct(replace bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body productBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if replace && (body.ProductName == nil || body.Price == nil || body.Rating == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "product_name, price and rating are required"})
+			return
+		}
+		version, ok, err := expectedVersion(c, &body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusPreconditionRequired, gin.H{"error": "send the product version in If-Match or the body"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		product, ok := app.findProductParam(ctx, c)
+		if !ok {
+			return
+		}
+		current := product.Variants
+		if replace {
+			product.Description = ""
+			product.Image = ""
+			product.CategoryIDs = nil
+			product.Variants = nil
+		}
+		if body.ProductName != nil {
+			product.ProductName = strings.TrimSpace(*body.ProductName)
+		}
+		if body.Description != nil {
+			product.Description = strings.TrimSpace(*body.Description)
+		}
+		if body.Price != nil {
+			product.Price = *body.Price
+		}
+		if body.Rating != nil {
+			product.Rating = *body.Rating
+		}
+		if body.Image != nil {
+			product.Image = *body.Image
+		}
+		if body.CategoryIDs != nil {
+			product.CategoryIDs = *body.CategoryIDs
+		}
+		if body.Variants != nil {
+			product.Variants = *body.Variants
+		}
+		if product.Variants, err = prepareVariants(current, product.Variants); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err = Validate.Struct(product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if product.CategoryIDs, err = app.checkProductCategories(ctx, product.CategoryIDs); err != nil {
+			app.productCategoryError(c, err)
+			return
+		}
+		app.saveProduct(ctx, c, product, version)
+	}
+}
+
+// ArchiveProduct soft-deletes a product: it leaves the catalog and can no
+// longer be bought, but stays in existing carts and orders.
+func (app *Application) ArchiveProduct() gin.HandlerFunc {
+	return app.setProductArchived(true)
+}
+
+// RestoreProduct brings an archived product back to the catalog.
+func (app *Application) RestoreProduct() gin.HandlerFunc {
+	return app.setProductArchived(false)
+}
+
+// setProductArchived checks If-Match when it is sent; archiving twice is
+// harmless, so it is optional here.
+func (app *Application) setProductArchived(archived bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, checked, err := expectedVersion(c, nil)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		product, ok := app.findProductParam(ctx, c)
+		if !ok {
+			return
+		}
+		if !checked {
+			version = product.Version
+		}
+		product.Archived = archived
+		app.saveProduct(ctx, c, product, version)
+	}
+}
+
+// saveProduct stores product over version and answers with the result.
+func (app *Application) saveProduct(ctx context.Context, c *gin.Context, product models.Product, version int) {
+	err := app.products.Update(ctx, product, version)
+	if errors.Is(err, database.ErrSKUTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, database.ErrProductConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, database.ErrCantFindProduct) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the product"})
+		return
+	}
+	product.Version = version + 1
+	c.Header("ETag", productETag(product))
+	c.JSON(http.StatusOK, product)
+}
+
+func (app *Application) productCategoryError(c *gin.Context, err error) {
+	if errors.Is(err, errUnknownCategory) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Println(err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the categories"})
+}
+
+// findProductParam loads the product named by the :productID path
+// parameter, or answers 404.
+func (app *Application) findProductParam(ctx context.Context, c *gin.Context) (models.Product, bool) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("productID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindProduct.Error()})
+		return models.Product{}, false
+	}
+	product, err := app.products.FindByID(ctx, productID)
+	if errors.Is(err, database.ErrCantFindProduct) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return product, false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the product"})
+		return product, false
+	}
+	return product, true
+}
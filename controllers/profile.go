@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/mailer"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GetProfile returns the logged in user's profile.
+func (app *Application) GetProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the profile"})
+			return
+		}
+		c.JSON(http.StatusOK, user.Profile())
+	}
+}
+
+// UpdateProfile changes the names, phone or email of the logged in user.
+// Every given field is validated like at signup. Changing the email takes
+// the current password, and a second factor if the user has one; the new
+// email is unverified until the user follows the link mailed to it, and the
+// old one is told about the change.
+func (app *Application) UpdateProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			FirstName *string `json:"first_name"`
+			LastName  *string `json:"last_name"`
+			Phone     *string `json:"phone"`
+			Email     *string `json:"email"`
+			// Password and Code confirm an email change.
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the profile"})
+			return
+		}
+		previousEmail := user.Email
+		emailChanged := body.Email != nil && normalizeEmail(*body.Email) != user.Email
+		if emailChanged {
+			if body.Password == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the current password is required to change the email"})
+				return
+			}
+			var ok bool
+			if user, ok = app.reauthenticate(ctx, c, body.Password); !ok {
+				return
+			}
+			if user.TwoFactorEnabled {
+				if err = app.checkSecondFactor(ctx, user, body.Code); err != nil {
+					app.loginFailed(ctx, c, normalizeEmail(user.Email), &user)
+					c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+
+		var fields []string
+		if body.FirstName != nil {
+			user.FirstName = strings.TrimSpace(*body.FirstName)
+			fields = append(fields, "FirstName")
+		}
+		if body.LastName != nil {
+			user.LastName = strings.TrimSpace(*body.LastName)
+			fields = append(fields, "LastName")
+		}
+		phoneChanged := body.Phone != nil && strings.TrimSpace(*body.Phone) != user.Phone
+		if phoneChanged {
+			user.Phone = strings.TrimSpace(*body.Phone)
+			fields = append(fields, "Phone")
+		}
+		if emailChanged {
+			user.Email = normalizeEmail(*body.Email)
+			user.EmailVerified = false
+			fields = append(fields, "Email")
+		}
+		if len(fields) > 0 {
+			if err = Validate.StructPartial(user, fields...); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		if emailChanged {
+			count, err := app.users.CountByEmail(ctx, user.Email)
+			if err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the profile"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "this email is already in use"})
+				return
+			}
+		}
+		if phoneChanged {
+			count, err := app.users.CountByPhone(ctx, user.Phone)
+			if err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the profile"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "this phone number is already in use"})
+				return
+			}
+		}
+
+		if err = app.users.UpdateProfile(ctx, user); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the profile"})
+			return
+		}
+		if emailChanged {
+			if err = app.sendEmailVerification(ctx, user); err != nil {
+				log.Println(err)
+			}
+			err = app.mail.Send(ctx, mailer.Message{
+				To:      previousEmail,
+				Subject: "Your email address was changed",
+				Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s.\n"+
+					"If you did not do this, contact support right away.\n",
+					user.FirstName, user.Email),
+			})
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		c.JSON(http.StatusOK, user.Profile())
+	}
+}
+
+// ChangePassword sets a new password for the logged in user, who must give
+// the current one. Wrong guesses count towards the login lockout. All other
+// sessions are logged out; the caller gets fresh tokens.
+func (app *Application) ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			CurrentPassword string `json:"current_password" binding:"required"`
+			NewPassword     string `json:"new_password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, ok := app.reauthenticate(ctx, c, body.CurrentPassword)
+		if !ok {
+			return
+		}
+		if err := app.policy.Check(body.NewPassword); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hash, err := app.passwords.Hash(body.NewPassword)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to change the password"})
+			return
+		}
+		if err = app.users.ChangePassword(ctx, user.UserID, hash); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to change the password"})
+			return
+		}
+
+		user.TokenVersion++
+		token, refreshToken, err := app.issueTokens(ctx, user)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the password is changed, please log in again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
+
+// reauthenticate loads the logged in user and checks password against theirs,
+// under the same throttling as Login. When the check fails it answers the
+// request and returns false.
+func (app *Application) reauthenticate(ctx context.Context, c *gin.Context, password string) (models.User, bool) {
+	user, err := app.users.FindByID(ctx, c.GetString("uid"))
+	if errors.Is(err, database.ErrUserNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return user, false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to load the user"})
+		return user, false
+	}
+	if app.loginBlocked(ctx, c, normalizeEmail(user.Email)) {
+		return user, false
+	}
+	if ok, _ := app.passwords.Verify(password, user.Password); !ok {
+		app.loginFailed(ctx, c, normalizeEmail(user.Email), &user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "the current password is incorrect"})
+		return user, false
+	}
+	return user, true
+}
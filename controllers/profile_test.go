@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateProfileEmail(t *testing.T) {
+	app, stores, mail := newTestApp(t)
+	router := gin.New()
+	router.POST("/users/signup", app.Signup())
+	router.Group("", uidFromHeader).PATCH("/users/me", app.UpdateProfile())
+	signup(t, router, "ann@example.com", "100")
+	user, err := stores.Users.FindByEmail(context.Background(), "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ann := asUser(router, user.UserID)
+
+	tests := []struct {
+		name string
+		body gin.H
+		want int
+	}{
+		{"name needs no password", gin.H{"first_name": "Anna"}, http.StatusOK},
+		{"email without password", gin.H{"email": "anna@example.com"}, http.StatusBadRequest},
+		{"email with a wrong password", gin.H{"email": "anna@example.com", "password": "battery staple"}, http.StatusUnauthorized},
+		{"email with the password", gin.H{"email": "anna@example.com", "password": "correct horse"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(t, ann, http.MethodPatch, "/users/me", "", tt.body); w.Code != tt.want {
+				t.Errorf("update: %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+
+	sent := map[string]string{}
+	for _, msg := range mail.messages {
+		sent[msg.To] = msg.Subject
+	}
+	if sent["ann@example.com"] != "Your email address was changed" {
+		t.Errorf("the old address got %q, want the change notice", sent["ann@example.com"])
+	}
+	if _, ok := sent["anna@example.com"]; !ok {
+		t.Error("the new address was not sent a verification link")
+	}
+}
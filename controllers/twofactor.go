@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"github.com/mukulmantosh/ecommerce-gin/tokens"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EnrollTwoFactor starts TOTP enrollment. The secret is returned once, both
+// raw and as an otpauth:// URI to render as a QR code, and only becomes
+// active after ConfirmTwoFactor sees a valid code for it.
+func (app *Application) EnrollTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to start two-factor enrollment"})
+			return
+		}
+		secret, err := tokens.NewTOTPSecret()
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to start two-factor enrollment"})
+			return
+		}
+		err = app.users.SetTOTPSecret(ctx, user.UserID, secret)
+		if errors.Is(err, database.ErrTwoFactorEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to start two-factor enrollment"})
+			return
+		}
+
+		uri := tokens.TOTPProvisioningURI(app.totpIssuer, user.Email, secret)
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": uri, "qr_payload": uri})
+	}
+}
+
+// ConfirmTwoFactor activates a pending enrollment with a code from the
+// authenticator. It answers with the recovery codes, shown only this once,
+// and a fresh token pair: every earlier token is revoked.
+func (app *Application) ConfirmTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to enable two-factor authentication"})
+			return
+		}
+		if user.TwoFactorEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrTwoFactorEnabled.Error()})
+			return
+		}
+		if user.TOTPSecret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start two-factor enrollment first"})
+			return
+		}
+		step, err := tokens.ValidateTOTP(user.TOTPSecret, strings.TrimSpace(body.Code), time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		codes, hashes, err := tokens.NewRecoveryCodes()
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to enable two-factor authentication"})
+			return
+		}
+		err = app.users.EnableTwoFactor(ctx, user.UserID, step, hashes)
+		if errors.Is(err, database.ErrTwoFactorEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to enable two-factor authentication"})
+			return
+		}
+
+		user.TokenVersion++
+		token, refreshToken, err := app.issueTokens(ctx, user)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor authentication is enabled, please log in again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes, "token": token, "refresh_token": refreshToken})
+	}
+}
+
+// DisableTwoFactor turns 2FA off after one last TOTP or recovery code.
+func (app *Application) DisableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to disable two-factor authentication"})
+			return
+		}
+		if !user.TwoFactorEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+		if err = app.checkSecondFactor(ctx, user, body.Code); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err = app.users.DisableTwoFactor(ctx, user.UserID); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to disable two-factor authentication"})
+			return
+		}
+		c.JSON(http.StatusOK, "Two-factor authentication disabled")
+	}
+}
+
+// LoginTwoFactor is the second login step: it trades the challenge token
+// from Login and a TOTP or recovery code for the user's tokens.
+func (app *Application) LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			ChallengeToken string `json:"challenge_token" binding:"required"`
+			Code           string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		claims, err := tokens.ParseTwoFactorChallenge(body.ChallengeToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		foundUser, err := app.users.FindByID(ctx, claims.UID)
+		if err != nil || foundUser.TokenVersion != claims.Version || !foundUser.TwoFactorEnabled {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": tokens.ErrInvalidChallenge.Error()})
+			return
+		}
+		if foundUser.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": errAccountDisabled.Error()})
+			return
+		}
+		if app.loginBlocked(ctx, c, normalizeEmail(foundUser.Email)) {
+			return
+		}
+		if err = app.checkSecondFactor(ctx, foundUser, body.Code); err != nil {
+			app.loginFailed(ctx, c, normalizeEmail(foundUser.Email), &foundUser)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		app.loginSucceeded(ctx, normalizeEmail(foundUser.Email))
+
+		token, refreshToken, err := app.issueTokens(ctx, foundUser)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed, please try again"})
+			return
+		}
+		c.JSON(http.StatusFound, loginResponse{UserProfile: foundUser.Profile(), Token: token, RefreshToken: refreshToken})
+	}
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code,
+// and consumes it.
+func (app *Application) checkSecondFactor(ctx context.Context, user models.User, code string) error {
+	code = strings.TrimSpace(code)
+	if !tokens.IsTOTPCode(code) {
+		err := app.users.UseRecoveryCode(ctx, user.UserID, tokens.HashRecoveryCode(code))
+		if err != nil && !errors.Is(err, database.ErrRecoveryCodeUsed) {
+			log.Println(err)
+		}
+		return err
+	}
+	step, err := tokens.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if err != nil {
+		return err
+	}
+	err = app.users.UseTOTPStep(ctx, user.UserID, step)
+	if err != nil && !errors.Is(err, database.ErrTOTPCodeUsed) {
+		log.Println(err)
+	}
+	return err
+}
+
+// issueTokens signs a new token pair for user and stores it.
+func (app *Application) issueTokens(ctx context.Context, user models.User) (string, string, error) {
+	return tokens.IssueTokens(ctx, app.users, user)
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/mailer"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"github.com/mukulmantosh/ecommerce-gin/tokens"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// normalizeEmail is applied before every lookup or insert by email, so the
+// uniqueness check and logins ignore case and stray whitespace.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (app *Application) sendEmailVerification(ctx context.Context, user models.User) error {
+	token, err := tokens.EmailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+	link := app.publicURL + "/users/verify-email?token=" + url.QueryEscape(token)
+	return app.mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address with this link:\n%s\n\n"+
+			"You need a confirmed address before you can place an order.\n",
+			user.FirstName, link),
+	})
+}
+
+// VerifyEmail confirms the address a mailed verification link was sent to.
+func (app *Application) VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, email, err := tokens.ParseEmailVerificationToken(c.Query("token"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		err = app.users.MarkEmailVerified(ctx, userID, email)
+		if errors.Is(err, database.ErrUserNotFound) || errors.Is(err, database.ErrUserIdIsNotValid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": tokens.ErrInvalidVerificationToken.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to verify the email"})
+			return
+		}
+		if err = app.promoteVerifiedAdmin(ctx, userID, email); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to verify the email"})
+			return
+		}
+		c.JSON(http.StatusOK, "Email address verified")
+	}
+}
+
+// ResendVerification mails a fresh verification link to the logged in user.
+func (app *Application) ResendVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		user, err := app.users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to send the verification email"})
+			return
+		}
+		if user.EmailVerified {
+			c.JSON(http.StatusOK, "Email address is already verified")
+			return
+		}
+		if err = app.sendEmailVerification(ctx, user); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to send the verification email"})
+			return
+		}
+		c.JSON(http.StatusAccepted, "Verification email sent")
+	}
+}
+
+// requireVerifiedEmail loads the user placing an order. It answers 403 and
+// returns false when they have not confirmed their email address yet.
+func (app *Application) requireVerifiedEmail(ctx context.Context, c *gin.Context, userID string) (models.User, bool) {
+	user, err := app.users.FindByID(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		c.IndentedJSON(http.StatusInternalServerError, "unable to load the user")
+		return user, false
+	}
+	if !user.EmailVerified {
+		c.IndentedJSON(http.StatusForbidden, "please verify your email address before placing an order")
+		return user, false
+	}
+	return user, true
+}
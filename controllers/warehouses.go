@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// warehouseBody is a warehouse as sent to CreateWarehouse and
+// UpdateWarehouse. Warehouses are active unless active is false.
+type warehouseBody struct {
+	Name    string `json:"name"`
+	PinCode string `json:"pin_code"`
+	Active  *bool  `json:"active"`
+}
+
+// ListWarehouses returns every warehouse, by name.
+func (app *Application) ListWarehouses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		warehouses, err := app.warehouses.FindAll(ctx)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to list the warehouses"})
+			return
+		}
+		c.JSON(http.StatusOK, warehouses)
+	}
+}
+
+// CreateWarehouse adds a warehouse to ship orders from.
+func (app *Application) CreateWarehouse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		warehouse, ok := bindWarehouse(c, primitive.NewObjectID())
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		if err := app.warehouses.Create(ctx, &warehouse); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to add the warehouse"})
+			return
+		}
+		c.JSON(http.StatusCreated, warehouse)
+	}
+}
+
+// UpdateWarehouse replaces a warehouse's name, PIN code and status. An
+// inactive warehouse keeps its stock and open orders but is left out of new
+// ones.
+func (app *Application) UpdateWarehouse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		warehouseID, err := primitive.ObjectIDFromHex(c.Param("warehouseID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindWarehouse.Error()})
+			return
+		}
+		warehouse, ok := bindWarehouse(c, warehouseID)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.timeouts.Request)
+		defer cancel()
+
+		err = app.warehouses.Update(ctx, warehouse)
+		if errors.Is(err, database.ErrCantFindWarehouse) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to update the warehouse"})
+			return
+		}
+		c.JSON(http.StatusOK, warehouse)
+	}
+}
+
+// bindWarehouse reads and validates the request body as the warehouse with
+// id warehouseID.
+func bindWarehouse(c *gin.Context, warehouseID primitive.ObjectID) (models.Warehouse, bool) {
+	var body warehouseBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return models.Warehouse{}, false
+	}
+	warehouse := models.Warehouse{WarehouseID: warehouseID, Name: strings.TrimSpace(body.Name),
+		PinCode: strings.TrimSpace(body.PinCode), Active: body.Active == nil || *body.Active}
+	if err := Validate.Struct(warehouse); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return warehouse, false
+	}
+	return warehouse, true
+}
+
+// hasWarehouse reports whether warehouseID names a warehouse.
+func (app *Application) hasWarehouse(ctx context.Context, warehouseID primitive.ObjectID) (bool, error) {
+	warehouses, err := app.warehouses.FindAll(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, warehouse := range warehouses {
+		if warehouse.WarehouseID == warehouseID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+// MongoLoginAttemptStore keeps one document per key. A TTL index on
+// last_failure_at can be added to expire stale documents; correctness does
+// not depend on it.
+type MongoLoginAttemptStore struct {
+	attemptCollection *mongo.Collection
+}
+
+func NewMongoLoginAttemptStore(attemptCollection *mongo.Collection) *MongoLoginAttemptStore {
+	return &MongoLoginAttemptStore{attemptCollection: attemptCollection}
+}
+
+func (s *MongoLoginAttemptStore) Get(ctx context.Context, key string) (models.LoginAttempts, error) {
+	attempts := models.LoginAttempts{Key: key}
+	err := s.attemptCollection.FindOne(ctx, bson.M{"_id": key}).Decode(&attempts)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return attempts, nil
+	}
+	return attempts, err
+}
+
+func (s *MongoLoginAttemptStore) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (models.LoginAttempts, error) {
+	// A pipeline update decides between restarting and incrementing the
+	// count atomically, on the stored last failure.
+	recent := bson.M{"$gt": bson.A{"$last_failure_at", at.Add(-window)}}
+	incremented := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "failures", Value: bson.M{"$cond": bson.A{recent, incremented, 1}}},
+		{Key: "last_failure_at", Value: at}}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attempts models.LoginAttempts
+	err := s.attemptCollection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempts)
+	if err != nil {
+		log.Println(err)
+		return attempts, ErrCantCountAttempts
+	}
+	return attempts, nil
+}
+
+func (s *MongoLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	update := bson.M{"$set": bson.M{"locked_until": until}}
+	_, err := s.attemptCollection.UpdateOne(ctx, bson.M{"_id": key}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Println(err)
+		return ErrCantCountAttempts
+	}
+	return nil
+}
+
+func (s *MongoLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.attemptCollection.DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		log.Println(err)
+		return ErrCantCountAttempts
+	}
+	return nil
+}
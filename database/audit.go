@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+)
+
+type MongoAuditStore struct {
+	auditCollection *mongo.Collection
+}
+
+func NewMongoAuditStore(auditCollection *mongo.Collection) *MongoAuditStore {
+	return &MongoAuditStore{auditCollection: auditCollection}
+}
+
+func (s *MongoAuditStore) Record(ctx context.Context, record models.AuditRecord) error {
+	_, err := s.auditCollection.InsertOne(ctx, record)
+	if err != nil {
+		log.Println(err)
+		return ErrCantRecordAudit
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"time"
 )
@@ -20,17 +21,29 @@ var (
 	ErrCantBuyCartItem    = errors.New("cannot update the purchase")
 )
 
-func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productId primitive.ObjectID, userID string) error {
-	searchFromDB, err := prodCollection.Find(ctx, bson.M{"_id": productId})
+type MongoOrderStore struct {
+	prodCollection *mongo.Collection
+	userCollection *mongo.Collection
+	inventory      *MongoInventoryStore
+	warehouses     *MongoWarehouseStore
+}
+
+// NewMongoOrderStore ships orders from the warehouses in whCollection and
+// keeps the stock they reserve in invCollection, the collections of the
+// warehouse and inventory stores.
+func NewMongoOrderStore(prodCollection, userCollection, invCollection, whCollection *mongo.Collection) *MongoOrderStore {
+	return &MongoOrderStore{prodCollection: prodCollection, userCollection: userCollection,
+		inventory: &MongoInventoryStore{invCollection: invCollection}, warehouses: NewMongoWarehouseStore(whCollection)}
+}
+
+func (s *MongoOrderStore) AddProductToCart(ctx context.Context, productId, variantID primitive.ObjectID, userID string) error {
+	product, err := s.findAvailable(ctx, productId)
 	if err != nil {
-		log.Println(err)
-		return ErrCantFindProduct
+		return err
 	}
-	var productCart []models.ProductUser
-	err = searchFromDB.All(ctx, &productCart)
+	item, err := cartItem(product, variantID)
 	if err != nil {
-		log.Println(err)
-		return ErrCantDecodeProducts
+		return err
 	}
 
 	userId, err := primitive.ObjectIDFromHex(userID)
@@ -41,129 +54,286 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 
 	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
 	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "user_cart",
-		Value: bson.D{{Key: "$each", Value: productCart}}}}}}
+		Value: item}}}}
 
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	_, err = s.userCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return ErrCantUpdateUser
 	}
 	return nil
 }
 
-func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+func (s *MongoOrderStore) RemoveCartItem(ctx context.Context, productID, variantID primitive.ObjectID, userID string) error {
 	userId, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
 		return ErrUserIdIsNotValid
 	}
 	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
-	update := bson.M{"$pull": bson.M{"user_cart": bson.M{"_id": productID}}}
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+	line := bson.M{"_id": productID}
+	if !variantID.IsZero() {
+		line["variant_id"] = variantID
+	}
+	update := bson.M{"$pull": bson.M{"user_cart": line}}
+	_, err = s.userCollection.UpdateMany(ctx, filter, update)
 	if err != nil {
 		return ErrCantRemoteItemCart
 	}
 	return nil
 }
 
-func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, userID string) error {
+func (s *MongoOrderStore) GetCart(ctx context.Context, userID string) ([]models.ProductUser, uint64, error) {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return user.UserCart, cartTotal(user.UserCart), nil
+}
+
+func (s *MongoOrderStore) BuyItemFromCart(ctx context.Context, userID string, checkout Checkout) error {
 	userId, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
 		return ErrUserIdIsNotValid
 	}
 
-	var getCartItems models.User
-	var OrderCart models.Order
-
-	OrderCart.OrderID = primitive.NewObjectID()
-	OrderCart.OrderedAt = time.Now()
-	OrderCart.OrderCart = make([]models.ProductUser, 0)
-	OrderCart.PaymentMethod.COD = true
-
-	Unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$user_cart"}}}}
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$user_cart.price"}}}}}}
-	currentResults, err := userCollection.Aggregate(ctx, mongo.Pipeline{Unwind, grouping})
-
-	ctx.Done()
+	getCartItems, err := s.findUser(ctx, userID)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	var getUserCart []bson.M
-	if err = currentResults.All(ctx, &getUserCart); err != nil {
-		panic(err)
-	}
-
-	var totalPrice int32
-	for _, userItem := range getUserCart {
-		price := userItem["total"]
-		totalPrice = price.(int32)
+	if err = s.checkAvailable(ctx, getCartItems.UserCart); err != nil {
+		return err
 	}
 
-	OrderCart.Price = uint64(totalPrice)
+	OrderCart := newOrder(getCartItems.UserCart, checkout)
+	return s.placeOrder(ctx, userId, OrderCart, checkout.PinCode, true)
+}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: OrderCart}}}}
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+func (s *MongoOrderStore) InstantBuyer(ctx context.Context, productID, variantID primitive.ObjectID, userID string, checkout Checkout) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
+		return ErrUserIdIsNotValid
 	}
 
-	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: userId}}).Decode(&getCartItems)
+	product, err := s.findAvailable(ctx, productID)
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	product_details, err := cartItem(product, variantID)
+	if err != nil {
+		return err
 	}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: userId}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": bson.M{"$each": getCartItems.UserCart}}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+	order_detail := newOrder([]models.ProductUser{product_details}, checkout)
+	return s.placeOrder(ctx, userId, order_detail, checkout.PinCode, false)
+}
+
+// placeOrder allocates order to warehouses and reserves its stock there,
+// then adds the order to the user, emptying their cart if clearCart is set.
+// The stock is released again if that fails.
+func (s *MongoOrderStore) placeOrder(ctx context.Context, userID primitive.ObjectID, order models.Order, pinCode string, clearCart bool) error {
+	warehouses, err := s.warehouses.FindAll(ctx)
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	stock, err := s.inventory.levels(ctx, lineProducts(stockLines(order.OrderCart)))
+	if err != nil {
+		return err
+	}
+	if err = allocate(&order, warehouses, stock, pinCode); err != nil {
+		return err
+	}
+	if err = s.inventory.reserve(ctx, order.Allocations); err != nil {
+		return err
 	}
 
-	UserCartEmpty := make([]models.ProductUser, 0)
-	filter3 := bson.D{primitive.E{Key: "_id", Value: userId}}
-	update3 := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "user_cart", Value: UserCartEmpty}}}}
-	_, err = userCollection.UpdateOne(ctx, filter3, update3)
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "order_status", Value: order}}}}
+	if clearCart {
+		update = append(update, bson.E{Key: "$set", Value: bson.D{primitive.E{Key: "user_cart", Value: make([]models.ProductUser, 0)}}})
+	}
+	_, err = s.userCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
 	if err != nil {
+		log.Println(err)
+		if releaseErr := s.inventory.moveStock(ctx, order.Allocations, 0, -1); releaseErr != nil {
+			log.Println(releaseErr)
+		}
 		return ErrCantBuyCartItem
 	}
 	return nil
 }
 
-func InstantBuyer(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
-	userId, err := primitive.ObjectIDFromHex(userID)
+func (s *MongoOrderStore) FindOrder(ctx context.Context, orderID primitive.ObjectID) (models.Order, string, error) {
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"order_status": bson.M{"$elemMatch": bson.M{"_id": orderID}}})
+	err := s.userCollection.FindOne(ctx, bson.M{"order_status._id": orderID}, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && len(user.OrderStatus) == 0) {
+		return models.Order{}, "", ErrCantFindOrder
+	}
 	if err != nil {
 		log.Println(err)
-		return ErrUserIdIsNotValid
+		return models.Order{}, "", err
 	}
+	return user.OrderStatus[0], user.ID.Hex(), nil
+}
 
-	var product_details models.ProductUser
-	var order_detail models.Order
-
-	order_detail.OrderID = primitive.NewObjectID()
-	order_detail.OrderedAt = time.Now()
-	order_detail.OrderCart = make([]models.ProductUser, 0)
-	order_detail.PaymentMethod.COD = true
-	err = prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&product_details)
+func (s *MongoOrderStore) UpdateOrderStatus(ctx context.Context, orderID primitive.ObjectID, from, to string) error {
+	order, _, err := s.FindOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"order_status": bson.M{"$elemMatch": bson.M{"_id": orderID, "status": from}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"order_status.$.status": to}})
 	if err != nil {
 		log.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrOrderStatusChanged
+	}
+	onHand, reserved := stockEffect(to)
+	if onHand == 0 && reserved == 0 {
+		return nil
 	}
+	return s.inventory.moveStock(ctx, order.Allocations, onHand, reserved)
+}
 
-	order_detail.Price = product_details.Price
+func (s *MongoOrderStore) UnpaidOrders(ctx context.Context, now time.Time) ([]primitive.ObjectID, error) {
+	unpaid := bson.M{"status": models.OrderPendingPayment, "payment_due": bson.M{"$lte": now}}
+	opts := options.Find().SetProjection(bson.M{"order_status": 1})
+	cursor, err := s.userCollection.Find(ctx, bson.M{"order_status": bson.M{"$elemMatch": unpaid}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: order_detail}}}}
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	var users []models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return unpaidOrders(users, now), nil
+}
+
+// findAvailable finds a product that can be bought: it exists and is not
+// archived.
+func (s *MongoOrderStore) findAvailable(ctx context.Context, productID primitive.ObjectID) (models.Product, error) {
+	var product models.Product
+	err := s.prodCollection.FindOne(ctx, bson.M{"_id": productID, "archived": bson.M{"$ne": true}}).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return product, ErrCantFindProduct
+	}
 	if err != nil {
 		log.Println(err)
+		return product, ErrCantDecodeProducts
 	}
+	return product, nil
+}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: userId}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": product_details}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+// checkAvailable fails with ErrProductUnavailable when cart holds products
+// that were archived or deleted since they were added.
+func (s *MongoOrderStore) checkAvailable(ctx context.Context, cart []models.ProductUser) error {
+	ids := make([]primitive.ObjectID, 0, len(cart))
+	for _, item := range cart {
+		ids = append(ids, item.ProductID)
+	}
+	cursor, err := s.prodCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "archived": bson.M{"$ne": true}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
 	if err != nil {
 		log.Println(err)
+		return ErrCantFindProduct
 	}
-	return nil
+	defer cursor.Close(ctx)
+	available := make(map[primitive.ObjectID]bool, len(ids))
+	for cursor.Next(ctx) {
+		var product struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err = cursor.Decode(&product); err != nil {
+			log.Println(err)
+			return ErrCantDecodeProducts
+		}
+		available[product.ID] = true
+	}
+	if err = cursor.Err(); err != nil {
+		log.Println(err)
+		return ErrCantFindProduct
+	}
+	return checkAvailable(cart, available)
+}
+
+func (s *MongoOrderStore) findUser(ctx context.Context, userID string) (models.User, error) {
+	return NewMongoUserStore(s.userCollection).FindByID(ctx, userID)
+}
+
+// cartItem is the cart line for variantID of product, priced and pictured by
+// the variant where it overrides the product.
+func cartItem(product models.Product, variantID primitive.ObjectID) (models.ProductUser, error) {
+	item := models.ProductUser{
+		ProductID:   product.ProductID,
+		ProductName: product.ProductName,
+		Price:       product.Price,
+		Rating:      product.Rating,
+		Image:       product.Image,
+	}
+	if variantID.IsZero() {
+		if len(product.Variants) > 0 {
+			return item, ErrVariantRequired
+		}
+		return item, nil
+	}
+	for _, variant := range product.Variants {
+		if variant.VariantID != variantID {
+			continue
+		}
+		if variant.Price != nil {
+			item.Price = *variant.Price
+		}
+		if variant.Image != "" {
+			item.Image = variant.Image
+		}
+		item.VariantID = &variant.VariantID
+		item.SKU = variant.SKU
+		return item, nil
+	}
+	return item, ErrCantFindVariant
+}
+
+// newOrder is an order for cart, awaiting payment until checkout.PaymentDue
+// when it is paid digitally.
+func newOrder(cart []models.ProductUser, checkout Checkout) models.Order {
+	var order models.Order
+	order.OrderID = primitive.NewObjectID()
+	order.OrderedAt = time.Now()
+	order.OrderCart = cart
+	order.Price = cartTotal(cart)
+	order.PaymentMethod = checkout.Payment
+	order.Status = models.OrderPlaced
+	if !checkout.PaymentDue.IsZero() {
+		due := checkout.PaymentDue
+		order.Status = models.OrderPendingPayment
+		order.PaymentDue = &due
+	}
+	return order
+}
+
+// unpaidOrders returns the ids of the orders of users whose payment was due
+// by now.
+func unpaidOrders(users []models.User, now time.Time) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0)
+	for _, user := range users {
+		for _, order := range user.OrderStatus {
+			if order.Status == models.OrderPendingPayment && order.PaymentDue != nil && !order.PaymentDue.After(now) {
+				ids = append(ids, order.OrderID)
+			}
+		}
+	}
+	return ids
+}
+
+func cartTotal(cart []models.ProductUser) uint64 {
+	var total uint64
+	for _, item := range cart {
+		total += item.Price
+	}
+	return total
 }
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+type MongoCategoryStore struct {
+	catCollection  *mongo.Collection
+	prodCollection *mongo.Collection
+}
+
+func NewMongoCategoryStore(catCollection, prodCollection *mongo.Collection) *MongoCategoryStore {
+	return &MongoCategoryStore{catCollection: catCollection, prodCollection: prodCollection}
+}
+
+func (s *MongoCategoryStore) Create(ctx context.Context, category *models.Category) error {
+	if err := s.checkSlug(ctx, *category); err != nil {
+		return err
+	}
+	if _, err := s.catCollection.InsertOne(ctx, category); err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	return nil
+}
+
+func (s *MongoCategoryStore) FindAll(ctx context.Context) ([]models.Category, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "sort_order", Value: 1}, {Key: "name", Value: 1}})
+	cursor, err := s.catCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	categories := make([]models.Category, 0)
+	if err = cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (s *MongoCategoryStore) FindBySlug(ctx context.Context, slug string) (models.Category, error) {
+	var category models.Category
+	err := s.catCollection.FindOne(ctx, bson.M{"slug": slug}).Decode(&category)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return category, ErrCantFindCategory
+	}
+	return category, err
+}
+
+func (s *MongoCategoryStore) Update(ctx context.Context, category models.Category) error {
+	if err := s.checkSlug(ctx, category); err != nil {
+		return err
+	}
+	set := bson.D{{Key: "name", Value: category.Name}, {Key: "slug", Value: category.Slug},
+		{Key: "sort_order", Value: category.SortOrder}}
+	update := bson.D{{Key: "$set", Value: set}}
+	if category.ParentID != nil {
+		update[0].Value = append(set, bson.E{Key: "parent_id", Value: category.ParentID})
+	} else {
+		update = append(update, bson.E{Key: "$unset", Value: bson.M{"parent_id": ""}})
+	}
+	result, err := s.catCollection.UpdateOne(ctx, bson.M{"_id": category.CategoryID}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindCategory
+	}
+	return nil
+}
+
+func (s *MongoCategoryStore) Delete(ctx context.Context, categoryID primitive.ObjectID) error {
+	children, err := s.catCollection.CountDocuments(ctx, bson.M{"parent_id": categoryID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	if children > 0 {
+		return ErrCategoryHasChildren
+	}
+	result, err := s.catCollection.DeleteOne(ctx, bson.M{"_id": categoryID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindCategory
+	}
+	_, err = s.prodCollection.UpdateMany(ctx, bson.M{"category_ids": categoryID},
+		bson.M{"$pull": bson.M{"category_ids": categoryID}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	return nil
+}
+
+// checkSlug fails with ErrSlugTaken when another category has the slug of
+// category.
+func (s *MongoCategoryStore) checkSlug(ctx context.Context, category models.Category) error {
+	count, err := s.catCollection.CountDocuments(ctx, bson.M{"slug": category.Slug, "_id": bson.M{"$ne": category.CategoryID}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	if count > 0 {
+		return ErrSlugTaken
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBackends opens the stores of every backend that needs no server.
+func testBackends(t *testing.T) map[string]Stores {
+	t.Helper()
+	memory := NewMemoryDB()
+	sqlite, err := SQLSet(config.Database{Driver: "sqlite", URL: filepath.Join(t.TempDir(), "shop.db"),
+		ConnectTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]Stores{
+		"memory": {
+			Users:      NewMemoryUserStore(memory),
+			Products:   NewMemoryProductStore(memory),
+			Orders:     NewMemoryOrderStore(memory),
+			Inventory:  NewMemoryInventoryStore(memory),
+			Warehouses: NewMemoryWarehouseStore(memory),
+		},
+		"sqlite": {
+			Users:      NewSQLUserStore(sqlite),
+			Products:   NewSQLProductStore(sqlite),
+			Orders:     NewSQLOrderStore(sqlite),
+			Inventory:  NewSQLInventoryStore(sqlite),
+			Warehouses: NewSQLWarehouseStore(sqlite),
+		},
+	}
+}
+
+func TestBuyItemFromCartRejectsArchivedProducts(t *testing.T) {
+	for name, stores := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			warehouse := models.Warehouse{WarehouseID: primitive.NewObjectID(), Name: "Bengaluru", PinCode: "560001", Active: true}
+			if err := stores.Warehouses.Create(ctx, &warehouse); err != nil {
+				t.Fatal(err)
+			}
+			id := primitive.NewObjectID()
+			user := models.User{ID: id, UserID: id.Hex(), Email: "ann@example.com", Phone: "100", Role: models.RoleCustomer}
+			if err := stores.Users.Create(ctx, &user); err != nil {
+				t.Fatal(err)
+			}
+
+			var products []models.Product
+			for _, productName := range []string{"Mug", "Lamp"} {
+				product := models.Product{ProductID: primitive.NewObjectID(), ProductName: productName, Price: 5}
+				if err := stores.Products.Create(ctx, &product); err != nil {
+					t.Fatal(err)
+				}
+				adjustment := models.StockAdjustment{ID: primitive.NewObjectID(), WarehouseID: warehouse.WarehouseID,
+					ProductID: product.ProductID, Delta: 3, Reason: "received"}
+				if _, err := stores.Inventory.Adjust(ctx, &adjustment); err != nil {
+					t.Fatal(err)
+				}
+				if err := stores.Orders.AddProductToCart(ctx, product.ProductID, primitive.NilObjectID, user.UserID); err != nil {
+					t.Fatal(err)
+				}
+				products = append(products, product)
+			}
+
+			lamp, err := stores.Products.FindByID(ctx, products[1].ProductID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lamp.Archived = true
+			if err = stores.Products.Update(ctx, lamp, lamp.Version); err != nil {
+				t.Fatal(err)
+			}
+
+			checkout := Checkout{Payment: models.Payment{COD: true}, PinCode: "560001"}
+			err = stores.Orders.BuyItemFromCart(ctx, user.UserID, checkout)
+			if !errors.Is(err, ErrProductUnavailable) {
+				t.Fatalf("BuyItemFromCart() error = %v, want %v", err, ErrProductUnavailable)
+			}
+			if msg := err.Error(); !strings.Contains(msg, "Lamp") || strings.Contains(msg, "Mug") {
+				t.Errorf("error %q should name the Lamp line only", msg)
+			}
+			cart, _, err := stores.Orders.GetCart(ctx, user.UserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cart) != 2 {
+				t.Errorf("the cart has %d lines after the refused checkout, want 2", len(cart))
+			}
+		})
+	}
+}
@@ -3,17 +3,16 @@ package database
 import (
 	"context"
 	"fmt"
+	"github.com/mukulmantosh/ecommerce-gin/config"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
-	"time"
 )
 
-func DBSet() *mongo.Client {
-	connectionString := "mongodb://localhost:27017"
-	clientOption := options.Client().ApplyURI(connectionString)
+func DBSet(cfg config.Database) *mongo.Client {
+	clientOption := options.Client().ApplyURI(cfg.URL)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 	defer cancel()
 
 	// connect to MongoDB
@@ -22,7 +21,7 @@ func DBSet() *mongo.Client {
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = client.Ping(context.TODO(), nil)
+	err = client.Ping(ctx, nil)
 	if err != nil {
 		log.Println("failed to connect to mongodb")
 		return nil
@@ -31,15 +30,37 @@ func DBSet() *mongo.Client {
 	return client
 }
 
-var Client *mongo.Client = DBSet()
-
-func UserData(client *mongo.Client, collectionName string) *mongo.Collection {
-	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+func UserData(client *mongo.Client, dbName, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database(dbName).Collection(collectionName)
 	return collection
 
 }
 
-func ProductData(client *mongo.Client, collectionName string) *mongo.Collection {
-	var productCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+func ProductData(client *mongo.Client, dbName, collectionName string) *mongo.Collection {
+	var productCollection *mongo.Collection = client.Database(dbName).Collection(collectionName)
 	return productCollection
 }
+
+func AuditData(client *mongo.Client, dbName, collectionName string) *mongo.Collection {
+	return client.Database(dbName).Collection(collectionName)
+}
+
+func LoginAttemptData(client *mongo.Client, dbName, collectionName string) *mongo.Collection {
+	return client.Database(dbName).Collection(collectionName)
+}
+
+func CategoryData(client *mongo.Client, dbName, collectionName string) *mongo.Collection {
+	return client.Database(dbName).Collection(collectionName)
+}
+
+func InventoryData(client *mongo.Client, dbName, collectionName string) *mongo.Collection {
+	return client.Database(dbName).Collection(collectionName)
+}
+
+func AdjustmentData(client *mongo.Client, dbName, collectionName string) *mongo.Collection {
+	return client.Database(dbName).Collection(collectionName)
+}
+
+func WarehouseData(client *mongo.Client, dbName, collectionName string) *mongo.Collection {
+	return client.Database(dbName).Collection(collectionName)
+}
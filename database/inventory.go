@@ -0,0 +1,218 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mukulmantosh/ecommerce-gin/fulfillment"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"strings"
+)
+
+// stockKey identifies the stock of an item in a warehouse. It is the _id of
+// the item's inventory document.
+func stockKey(warehouseID, productID primitive.ObjectID, variantID *primitive.ObjectID) string {
+	key := warehouseID.Hex() + ":" + productID.Hex()
+	if variantID == nil {
+		return key
+	}
+	return key + ":" + variantID.Hex()
+}
+
+// stockLines counts the units of each product or variant in an order, one
+// unit per order line, in the order they first appear.
+func stockLines(items []models.ProductUser) []fulfillment.Line {
+	var lines []fulfillment.Line
+	index := make(map[string]int)
+	for _, item := range items {
+		key := stockKey(primitive.NilObjectID, item.ProductID, item.VariantID)
+		if i, ok := index[key]; ok {
+			lines[i].Quantity++
+			continue
+		}
+		index[key] = len(lines)
+		lines = append(lines, fulfillment.Line{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: 1})
+	}
+	return lines
+}
+
+// lineProducts returns the ids of the products in lines.
+func lineProducts(lines []fulfillment.Line) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(lines))
+	ids := make([]primitive.ObjectID, 0, len(lines))
+	for _, line := range lines {
+		if !seen[line.ProductID] {
+			seen[line.ProductID] = true
+			ids = append(ids, line.ProductID)
+		}
+	}
+	return ids
+}
+
+// checkAvailable fails with ErrProductUnavailable naming the cart lines whose
+// product is not in available, the IDs of the products still on sale.
+func checkAvailable(cart []models.ProductUser, available map[primitive.ObjectID]bool) error {
+	var lines []string
+	for _, item := range cart {
+		if available[item.ProductID] {
+			continue
+		}
+		line := item.ProductName
+		if item.SKU != "" {
+			line += " (" + item.SKU + ")"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrProductUnavailable, strings.Join(lines, ", "))
+}
+
+// allocate plans which warehouses ship order and records it on the order.
+func allocate(order *models.Order, warehouses []models.Warehouse, stock []models.StockLevel, pinCode string) error {
+	allocations, err := fulfillment.Plan(stockLines(order.OrderCart), warehouses, stock, pinCode)
+	if errors.Is(err, fulfillment.ErrInsufficientStock) {
+		return ErrInsufficientStock
+	}
+	if err != nil {
+		return err
+	}
+	order.Allocations = allocations
+	return nil
+}
+
+// stockEffect is what moving an order to status does to its stock, per unit
+// ordered: the change to the units on hand and to the units reserved.
+func stockEffect(status string) (onHand, reserved int) {
+	switch status {
+	case models.OrderCancelled:
+		return 0, -1
+	case models.OrderShipped:
+		return -1, -1
+	}
+	return 0, 0
+}
+
+type MongoInventoryStore struct {
+	invCollection *mongo.Collection
+	adjCollection *mongo.Collection
+}
+
+func NewMongoInventoryStore(invCollection, adjCollection *mongo.Collection) *MongoInventoryStore {
+	return &MongoInventoryStore{invCollection: invCollection, adjCollection: adjCollection}
+}
+
+func (s *MongoInventoryStore) Levels(ctx context.Context, productID primitive.ObjectID) ([]models.StockLevel, error) {
+	return s.levels(ctx, []primitive.ObjectID{productID})
+}
+
+func (s *MongoInventoryStore) levels(ctx context.Context, productIDs []primitive.ObjectID) ([]models.StockLevel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "warehouse_id", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.invCollection.Find(ctx, bson.M{"product_id": bson.M{"$in": productIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	levels := make([]models.StockLevel, 0)
+	if err = cursor.All(ctx, &levels); err != nil {
+		return nil, err
+	}
+	for i := range levels {
+		levels[i].Available = levels[i].OnHand - levels[i].Reserved
+	}
+	return levels, nil
+}
+
+// Adjust creates the stock of an item in a warehouse on its first delivery
+// there.
+func (s *MongoInventoryStore) Adjust(ctx context.Context, adjustment *models.StockAdjustment) (models.StockLevel, error) {
+	var level models.StockLevel
+	filter := bson.M{"_id": stockKey(adjustment.WarehouseID, adjustment.ProductID, adjustment.VariantID)}
+	if adjustment.Delta < 0 {
+		filter["$expr"] = bson.M{"$gte": bson.A{bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}}, -adjustment.Delta}}
+	}
+	update := bson.M{
+		"$inc": bson.M{"on_hand": adjustment.Delta},
+		"$setOnInsert": bson.M{"warehouse_id": adjustment.WarehouseID, "product_id": adjustment.ProductID,
+			"variant_id": adjustment.VariantID, "reserved": 0},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(adjustment.Delta >= 0).SetReturnDocument(options.After)
+	err := s.invCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&level)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return level, ErrInsufficientStock
+	}
+	if err != nil {
+		log.Println(err)
+		return level, ErrCantUpdateStock
+	}
+	level.Available = level.OnHand - level.Reserved
+
+	adjustment.OnHand = level.OnHand
+	if _, err = s.adjCollection.InsertOne(ctx, adjustment); err != nil {
+		log.Println(err)
+		return level, ErrCantUpdateStock
+	}
+	return level, nil
+}
+
+func (s *MongoInventoryStore) Adjustments(ctx context.Context, productID primitive.ObjectID) ([]models.StockAdjustment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := s.adjCollection.Find(ctx, bson.M{"product_id": productID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	adjustments := make([]models.StockAdjustment, 0)
+	if err = cursor.All(ctx, &adjustments); err != nil {
+		return nil, err
+	}
+	return adjustments, nil
+}
+
+// moveStock changes the units on hand and reserved of every allocation by
+// onHand and reserved times its quantity.
+func (s *MongoInventoryStore) moveStock(ctx context.Context, allocations []models.Allocation, onHand, reserved int) error {
+	for _, allocation := range allocations {
+		key := stockKey(allocation.WarehouseID, allocation.ProductID, allocation.VariantID)
+		_, err := s.invCollection.UpdateOne(ctx, bson.M{"_id": key},
+			bson.M{"$inc": bson.M{"on_hand": onHand * allocation.Quantity, "reserved": reserved * allocation.Quantity}})
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateStock
+		}
+	}
+	return nil
+}
+
+// reserve holds the stock of every allocation, or of none when one is
+// short. Without a transaction, the allocations already reserved are
+// released again.
+func (s *MongoInventoryStore) reserve(ctx context.Context, allocations []models.Allocation) error {
+	for i, allocation := range allocations {
+		filter := bson.M{"_id": stockKey(allocation.WarehouseID, allocation.ProductID, allocation.VariantID),
+			"$expr": bson.M{"$gte": bson.A{bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}}, allocation.Quantity}}}
+		result, err := s.invCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"reserved": allocation.Quantity}})
+		if err == nil && result.MatchedCount == 0 {
+			err = ErrInsufficientStock
+		}
+		if err != nil {
+			if releaseErr := s.moveStock(ctx, allocations[:i], 0, -1); releaseErr != nil {
+				log.Println(releaseErr)
+			}
+			if !errors.Is(err, ErrInsufficientStock) {
+				log.Println(err)
+				return ErrCantUpdateStock
+			}
+			return err
+		}
+	}
+	return nil
+}
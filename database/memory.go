@@ -0,0 +1,1238 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDB keeps users and products in process memory. It is shared by the
+// memory stores the same way a *mongo.Client is shared by the Mongo ones, and
+// is meant for local development and tests.
+type MemoryDB struct {
+	mu       sync.RWMutex
+	users    map[primitive.ObjectID]*models.User
+	products map[primitive.ObjectID]models.Product
+	// categories are keyed by slug.
+	categories map[string]models.Category
+	audit      []models.AuditRecord
+	attempts   map[string]models.LoginAttempts
+	// inventory is keyed by stockKey.
+	inventory   map[string]models.StockLevel
+	adjustments []models.StockAdjustment
+	warehouses  map[primitive.ObjectID]models.Warehouse
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:      make(map[primitive.ObjectID]*models.User),
+		products:   make(map[primitive.ObjectID]models.Product),
+		categories: make(map[string]models.Category),
+		attempts:   make(map[string]models.LoginAttempts),
+		inventory:  make(map[string]models.StockLevel),
+		warehouses: make(map[primitive.ObjectID]models.Warehouse),
+	}
+}
+
+// user returns the stored user for a hex id. Callers must hold db.mu.
+func (db *MemoryDB) user(userID string) (*models.User, error) {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserIdIsNotValid
+	}
+	user, ok := db.users[userId]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// copyUser detaches the slices of a stored user so callers cannot mutate
+// the store through the returned value.
+func copyUser(user *models.User) models.User {
+	out := *user
+	out.UserCart = append(make([]models.ProductUser, 0, len(user.UserCart)), user.UserCart...)
+	out.AddressDetails = append(make([]models.Address, 0, len(user.AddressDetails)), user.AddressDetails...)
+	out.RecoveryCodeHashes = append([]string(nil), user.RecoveryCodeHashes...)
+	out.Identities = append(make([]models.Identity, 0, len(user.Identities)), user.Identities...)
+	out.RefreshFamilies = append([]models.RefreshFamily(nil), user.RefreshFamilies...)
+	out.OrderStatus = make([]models.Order, 0, len(user.OrderStatus))
+	for _, order := range user.OrderStatus {
+		order.OrderCart = append(make([]models.ProductUser, 0, len(order.OrderCart)), order.OrderCart...)
+		out.OrderStatus = append(out.OrderStatus, order)
+	}
+	return out
+}
+
+type MemoryUserStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryUserStore(db *MemoryDB) *MemoryUserStore {
+	return &MemoryUserStore{db: db}
+}
+
+func (s *MemoryUserStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	return s.count(func(user *models.User) bool { return strings.EqualFold(user.Email, email) }), nil
+}
+
+func (s *MemoryUserStore) CountByPhone(ctx context.Context, phone string) (int64, error) {
+	return s.count(func(user *models.User) bool { return user.Phone == phone }), nil
+}
+
+func (s *MemoryUserStore) count(match func(user *models.User) bool) int64 {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var count int64
+	for _, user := range s.db.users {
+		if match(user) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, user *models.User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[user.ID]; ok {
+		return ErrCantCreateUser
+	}
+	stored := copyUser(user)
+	s.db.users[user.ID] = &stored
+	return nil
+}
+
+func (s *MemoryUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var found *models.User
+	for _, user := range s.db.users {
+		if strings.EqualFold(user.Email, email) && (found == nil || user.ID.Hex() < found.ID.Hex()) {
+			found = user
+		}
+	}
+	if found == nil {
+		return models.User{}, ErrUserNotFound
+	}
+	return copyUser(found), nil
+}
+
+func (s *MemoryUserStore) FindByID(ctx context.Context, userID string) (models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	return copyUser(user), nil
+}
+
+func (s *MemoryUserStore) List(ctx context.Context, filter UserFilter) ([]models.User, int64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	search := strings.ToLower(filter.Search)
+	matches := make([]*models.User, 0)
+	for _, user := range s.db.users {
+		if filter.Role != "" && user.Role != filter.Role ||
+			filter.Disabled != nil && user.Disabled != *filter.Disabled ||
+			filter.EmailVerified != nil && user.EmailVerified != *filter.EmailVerified {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(user.FirstName), search) &&
+			!strings.Contains(strings.ToLower(user.LastName), search) &&
+			!strings.Contains(strings.ToLower(user.Email), search) &&
+			!strings.Contains(strings.ToLower(user.Phone), search) {
+			continue
+		}
+		matches = append(matches, user)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID.Hex() > matches[j].ID.Hex() })
+
+	users := make([]models.User, 0)
+	for i := filter.Offset; i < len(matches) && (filter.Limit <= 0 || len(users) < filter.Limit); i++ {
+		users = append(users, copyUser(matches[i]))
+	}
+	return users, int64(len(matches)), nil
+}
+
+func (s *MemoryUserStore) FindByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if user := s.db.identityOwner(provider, subject); user != nil {
+		return copyUser(user), nil
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+func (s *MemoryUserStore) LinkIdentity(ctx context.Context, userID string, identity models.Identity) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	if s.db.identityOwner(identity.Provider, identity.Subject) != nil {
+		return ErrIdentityLinked
+	}
+	user.Identities = append(user.Identities, identity)
+	return nil
+}
+
+// identityOwner returns the user linked to a provider account. Callers must
+// hold db.mu.
+func (db *MemoryDB) identityOwner(provider, subject string) *models.User {
+	for _, user := range db.users {
+		for _, identity := range user.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return user
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateTokens(ctx context.Context, userID, signedToken string, family models.RefreshFamily) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.Token = signedToken
+	user.RefreshToken = ""
+	user.RefreshFamilies = append(user.RefreshFamilies, family)
+	if extra := len(user.RefreshFamilies) - MaxRefreshFamilies; extra > 0 {
+		user.RefreshFamilies = append([]models.RefreshFamily(nil), user.RefreshFamilies[extra:]...)
+	}
+	user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return nil
+}
+
+func (s *MemoryUserStore) RotateTokens(ctx context.Context, userID string, current models.RefreshFamily, newTokenID, signedToken string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	for i := range user.RefreshFamilies {
+		family := &user.RefreshFamilies[i]
+		if family.Family != current.Family {
+			continue
+		}
+		if family.TokenID != current.TokenID {
+			return ErrRefreshTokenStale
+		}
+		family.TokenID = newTokenID
+		user.Token = signedToken
+		user.RefreshToken = ""
+		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		return nil
+	}
+	return ErrRefreshFamilyEnded
+}
+
+func (s *MemoryUserStore) RevokeFamily(ctx context.Context, userID, family string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	kept := make([]models.RefreshFamily, 0, len(user.RefreshFamilies))
+	for _, f := range user.RefreshFamilies {
+		if f.Family != family {
+			kept = append(kept, f)
+		}
+	}
+	user.RefreshFamilies = kept
+	return nil
+}
+
+func (s *MemoryUserStore) RevokeTokens(ctx context.Context, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.Token = ""
+	user.RefreshToken = ""
+	user.RefreshFamilies = nil
+	user.TokenVersion++
+	user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return nil
+}
+
+func (s *MemoryUserStore) SetRole(ctx context.Context, userID, role string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	return nil
+}
+
+func (s *MemoryUserStore) SetDisabled(ctx context.Context, userID string, disabled bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.Disabled = disabled
+	if disabled {
+		user.Token = ""
+		user.RefreshToken = ""
+		user.TokenVersion++
+	}
+	user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return nil
+}
+
+func (s *MemoryUserStore) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	if user.Email != email {
+		return ErrUserNotFound
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+func (s *MemoryUserStore) SetTOTPSecret(ctx context.Context, userID, secret string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	if user.TwoFactorEnabled {
+		return ErrTwoFactorEnabled
+	}
+	user.TOTPSecret = secret
+	return nil
+}
+
+func (s *MemoryUserStore) EnableTwoFactor(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	if user.TwoFactorEnabled {
+		return ErrTwoFactorEnabled
+	}
+	user.TwoFactorEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodeHashes = append([]string(nil), recoveryCodeHashes...)
+	user.Token = ""
+	user.RefreshToken = ""
+	user.TokenVersion++
+	return nil
+}
+
+func (s *MemoryUserStore) DisableTwoFactor(ctx context.Context, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.TwoFactorEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	user.RecoveryCodeHashes = nil
+	return nil
+}
+
+func (s *MemoryUserStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	if step <= user.TOTPLastStep {
+		return ErrTOTPCodeUsed
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+func (s *MemoryUserStore) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	for i, hash := range user.RecoveryCodeHashes {
+		if hash == codeHash {
+			user.RecoveryCodeHashes = append(user.RecoveryCodeHashes[:i:i], user.RecoveryCodeHashes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrRecoveryCodeUsed
+}
+
+func (s *MemoryUserStore) UpdateProfile(ctx context.Context, user models.User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, err := s.db.user(user.UserID)
+	if err != nil {
+		return err
+	}
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.Phone = user.Phone
+	stored.Email = user.Email
+	stored.EmailVerified = user.EmailVerified
+	stored.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return nil
+}
+
+func (s *MemoryUserStore) ChangePassword(ctx context.Context, userID, hashedPassword string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	user.PasswordResetHash = ""
+	user.Token = ""
+	user.RefreshToken = ""
+	user.TokenVersion++
+	user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return nil
+}
+
+func (s *MemoryUserStore) ScheduleErasure(ctx context.Context, userID string, at time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.ErasureScheduledAt = at
+	return nil
+}
+
+func (s *MemoryUserStore) CancelErasure(ctx context.Context, userID string) error {
+	return s.ScheduleErasure(ctx, userID, time.Time{})
+}
+
+func (s *MemoryUserStore) DueErasures(ctx context.Context, now time.Time) ([]string, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	ids := make([]string, 0)
+	for _, user := range s.db.users {
+		if !user.ErasureScheduledAt.IsZero() && !user.ErasureScheduledAt.After(now) && user.ErasedAt.IsZero() {
+			ids = append(ids, user.UserID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *MemoryUserStore) Erase(ctx context.Context, userID string, at time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.FirstName = ""
+	user.LastName = ""
+	user.Email = ErasedEmail(userID)
+	user.Role = models.RoleCustomer
+	user.Phone = ""
+	user.Password = ""
+	user.Token = ""
+	user.RefreshToken = ""
+	user.TokenVersion++
+	user.EmailVerified = false
+	user.PasswordResetHash = ""
+	user.TwoFactorEnabled = false
+	user.TOTPSecret = ""
+	user.RecoveryCodeHashes = nil
+	user.Identities = make([]models.Identity, 0)
+	user.AddressDetails = make([]models.Address, 0)
+	user.UserCart = make([]models.ProductUser, 0)
+	user.Disabled = true
+	user.ErasureScheduledAt = time.Time{}
+	user.ErasedAt = at
+	user.UpdatedAt, _ = time.Parse(time.RFC3339, at.Format(time.RFC3339))
+	return nil
+}
+
+func (s *MemoryUserStore) UpdatePasswordHash(ctx context.Context, userID, hashedPassword string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+func (s *MemoryUserStore) SetPasswordReset(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.PasswordResetHash = tokenHash
+	user.PasswordResetExpiresAt = expiresAt
+	return nil
+}
+
+func (s *MemoryUserStore) ResetPassword(ctx context.Context, tokenHash, hashedPassword string, now time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, user := range s.db.users {
+		if tokenHash == "" || user.PasswordResetHash != tokenHash || !user.PasswordResetExpiresAt.After(now) {
+			continue
+		}
+		user.Password = hashedPassword
+		user.PasswordResetHash = ""
+		user.Token = ""
+		user.RefreshToken = ""
+		user.TokenVersion++
+		user.UpdatedAt, _ = time.Parse(time.RFC3339, now.Format(time.RFC3339))
+		return nil
+	}
+	return ErrResetTokenInvalid
+}
+
+func (s *MemoryUserStore) AddAddress(ctx context.Context, userID string, address models.Address) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	if len(user.AddressDetails) >= MaxAddresses {
+		return ErrAddressLimit
+	}
+	user.AddressDetails = append(user.AddressDetails, address)
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateAddress(ctx context.Context, userID string, index int, address models.Address) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(user.AddressDetails) {
+		return ErrCantUpdateAddress
+	}
+	stored := &user.AddressDetails[index]
+	stored.House = address.House
+	stored.Street = address.Street
+	stored.City = address.City
+	stored.PinCode = address.PinCode
+	return nil
+}
+
+func (s *MemoryUserStore) DeleteAddresses(ctx context.Context, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.AddressDetails = make([]models.Address, 0)
+	return nil
+}
+
+type MemoryProductStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryProductStore(db *MemoryDB) *MemoryProductStore {
+	return &MemoryProductStore{db: db}
+}
+
+func (s *MemoryProductStore) Create(ctx context.Context, product *models.Product) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.products[product.ProductID]; ok {
+		return ErrCantCreateProduct
+	}
+	if s.db.skuTaken(*product) {
+		return ErrSKUTaken
+	}
+	s.db.products[product.ProductID] = copyProduct(*product)
+	return nil
+}
+
+func (s *MemoryProductStore) FindAll(ctx context.Context) ([]models.Product, error) {
+	return s.filter(func(models.Product) bool { return true }), nil
+}
+
+func (s *MemoryProductStore) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Product, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	products := make([]models.Product, 0, len(ids))
+	for _, id := range ids {
+		if product, ok := s.db.products[id]; ok && !product.Archived {
+			products = append(products, copyProduct(product))
+		}
+	}
+	return products, nil
+}
+
+func (s *MemoryProductStore) Update(ctx context.Context, product models.Product, version int) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.products[product.ProductID]
+	if !ok {
+		return ErrCantFindProduct
+	}
+	if stored.Version != version {
+		return ErrProductConflict
+	}
+	if s.db.skuTaken(product) {
+		return ErrSKUTaken
+	}
+	product.Version = version + 1
+	s.db.products[product.ProductID] = copyProduct(product)
+	return nil
+}
+
+func (s *MemoryProductStore) FindByID(ctx context.Context, productID primitive.ObjectID) (models.Product, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	product, ok := s.db.products[productID]
+	if !ok {
+		return product, ErrCantFindProduct
+	}
+	return copyProduct(product), nil
+}
+
+func (s *MemoryProductStore) List(ctx context.Context, filter ProductFilter) ([]models.Product, int64, error) {
+	wanted := make(map[primitive.ObjectID]bool, len(filter.CategoryIDs))
+	for _, id := range filter.CategoryIDs {
+		wanted[id] = true
+	}
+	matches := s.filter(func(product models.Product) bool {
+		if filter.MinPrice > 0 && product.Price < filter.MinPrice ||
+			filter.MaxPrice > 0 && product.Price > filter.MaxPrice ||
+			product.Rating < filter.MinRating {
+			return false
+		}
+		if filter.CategoryIDs == nil {
+			return true
+		}
+		for _, id := range product.CategoryIDs {
+			if wanted[id] {
+				return true
+			}
+		}
+		return false
+	})
+
+	field, desc := productSort(filter.Sort)
+	// before reports whether a sorts ahead of b.
+	before := func(a, b models.Product) bool {
+		var order int
+		switch field {
+		case "price":
+			order = cmp.Compare(a.Price, b.Price)
+		case "rating":
+			order = cmp.Compare(a.Rating, b.Rating)
+		case "product_name":
+			order = cmp.Compare(a.ProductName, b.ProductName)
+		}
+		if order == 0 {
+			order = cmp.Compare(a.ProductID.Hex(), b.ProductID.Hex())
+		}
+		if desc {
+			return order > 0
+		}
+		return order < 0
+	}
+	sort.Slice(matches, func(i, j int) bool { return before(matches[i], matches[j]) })
+
+	start := filter.Offset
+	if filter.After != nil {
+		start = sort.Search(len(matches), func(i int) bool { return before(*filter.After, matches[i]) })
+	}
+	products := make([]models.Product, 0)
+	for i := start; i < len(matches) && (filter.Limit <= 0 || len(products) < filter.Limit); i++ {
+		products = append(products, matches[i])
+	}
+	return products, int64(len(matches)), nil
+}
+
+// skuTaken reports whether another product has a variant with one of the
+// SKUs of product. Callers must hold db.mu.
+func (db *MemoryDB) skuTaken(product models.Product) bool {
+	skus := make(map[string]bool, len(product.Variants))
+	for _, variant := range product.Variants {
+		skus[variant.SKU] = true
+	}
+	for id, other := range db.products {
+		if id == product.ProductID {
+			continue
+		}
+		for _, variant := range other.Variants {
+			if skus[variant.SKU] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// copyProduct copies the slices and maps of product, so the stored product
+// does not share them with the caller.
+func copyProduct(product models.Product) models.Product {
+	product.CategoryIDs = append([]primitive.ObjectID(nil), product.CategoryIDs...)
+	variants := make([]models.Variant, len(product.Variants))
+	for i, variant := range product.Variants {
+		if variant.Price != nil {
+			price := *variant.Price
+			variant.Price = &price
+		}
+		attributes := make(map[string]string, len(variant.Attributes))
+		for name, value := range variant.Attributes {
+			attributes[name] = value
+		}
+		variant.Attributes = attributes
+		variants[i] = variant
+	}
+	product.Variants = variants
+	return product
+}
+
+// filter returns the matching products that are not archived in insertion
+// order, which for ObjectIDs is the order of their ids.
+func (s *MemoryProductStore) filter(match func(models.Product) bool) []models.Product {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	products := make([]models.Product, 0)
+	for _, product := range s.db.products {
+		if !product.Archived && match(product) {
+			products = append(products, copyProduct(product))
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ProductID.Hex() < products[j].ProductID.Hex()
+	})
+	return products
+}
+
+type MemoryCategoryStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryCategoryStore(db *MemoryDB) *MemoryCategoryStore {
+	return &MemoryCategoryStore{db: db}
+}
+
+func (s *MemoryCategoryStore) Create(ctx context.Context, category *models.Category) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.categories[category.Slug]; ok {
+		return ErrSlugTaken
+	}
+	s.db.categories[category.Slug] = *category
+	return nil
+}
+
+func (s *MemoryCategoryStore) FindAll(ctx context.Context) ([]models.Category, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	categories := make([]models.Category, 0, len(s.db.categories))
+	for _, category := range s.db.categories {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	return categories, nil
+}
+
+func (s *MemoryCategoryStore) FindBySlug(ctx context.Context, slug string) (models.Category, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	category, ok := s.db.categories[slug]
+	if !ok {
+		return category, ErrCantFindCategory
+	}
+	return category, nil
+}
+
+func (s *MemoryCategoryStore) Update(ctx context.Context, category models.Category) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	current, ok := s.db.category(category.CategoryID)
+	if !ok {
+		return ErrCantFindCategory
+	}
+	if other, ok := s.db.categories[category.Slug]; ok && other.CategoryID != category.CategoryID {
+		return ErrSlugTaken
+	}
+	delete(s.db.categories, current.Slug)
+	s.db.categories[category.Slug] = category
+	return nil
+}
+
+func (s *MemoryCategoryStore) Delete(ctx context.Context, categoryID primitive.ObjectID) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	category, ok := s.db.category(categoryID)
+	if !ok {
+		return ErrCantFindCategory
+	}
+	for _, other := range s.db.categories {
+		if other.ParentID != nil && *other.ParentID == categoryID {
+			return ErrCategoryHasChildren
+		}
+	}
+	delete(s.db.categories, category.Slug)
+	for id, product := range s.db.products {
+		kept := make([]primitive.ObjectID, 0, len(product.CategoryIDs))
+		for _, productCategory := range product.CategoryIDs {
+			if productCategory != categoryID {
+				kept = append(kept, productCategory)
+			}
+		}
+		product.CategoryIDs = kept
+		s.db.products[id] = product
+	}
+	return nil
+}
+
+// category finds a category by id. Callers must hold db.mu.
+func (db *MemoryDB) category(categoryID primitive.ObjectID) (models.Category, bool) {
+	for _, category := range db.categories {
+		if category.CategoryID == categoryID {
+			return category, true
+		}
+	}
+	return models.Category{}, false
+}
+
+type MemoryOrderStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryOrderStore(db *MemoryDB) *MemoryOrderStore {
+	return &MemoryOrderStore{db: db}
+}
+
+func (s *MemoryOrderStore) AddProductToCart(ctx context.Context, productID, variantID primitive.ObjectID, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	product, ok := s.db.products[productID]
+	if !ok || product.Archived {
+		return ErrCantFindProduct
+	}
+	item, err := cartItem(product, variantID)
+	if err != nil {
+		return err
+	}
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	user.UserCart = append(user.UserCart, item)
+	return nil
+}
+
+func (s *MemoryOrderStore) RemoveCartItem(ctx context.Context, productID, variantID primitive.ObjectID, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	cart := make([]models.ProductUser, 0, len(user.UserCart))
+	for _, item := range user.UserCart {
+		if item.ProductID != productID || !variantID.IsZero() && (item.VariantID == nil || *item.VariantID != variantID) {
+			cart = append(cart, item)
+		}
+	}
+	user.UserCart = cart
+	return nil
+}
+
+func (s *MemoryOrderStore) GetCart(ctx context.Context, userID string) ([]models.ProductUser, uint64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	cart := copyUser(user).UserCart
+	return cart, cartTotal(cart), nil
+}
+
+func (s *MemoryOrderStore) BuyItemFromCart(ctx context.Context, userID string, checkout Checkout) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	available := make(map[primitive.ObjectID]bool, len(user.UserCart))
+	for _, item := range user.UserCart {
+		product, ok := s.db.products[item.ProductID]
+		available[item.ProductID] = ok && !product.Archived
+	}
+	if err = checkAvailable(user.UserCart, available); err != nil {
+		return err
+	}
+	order := newOrder(user.UserCart, checkout)
+	if err = s.db.reserve(&order, checkout.PinCode); err != nil {
+		return err
+	}
+	user.OrderStatus = append(user.OrderStatus, order)
+	user.UserCart = make([]models.ProductUser, 0)
+	return nil
+}
+
+func (s *MemoryOrderStore) InstantBuyer(ctx context.Context, productID, variantID primitive.ObjectID, userID string, checkout Checkout) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	product, ok := s.db.products[productID]
+	if !ok || product.Archived {
+		return ErrCantFindProduct
+	}
+	item, err := cartItem(product, variantID)
+	if err != nil {
+		return err
+	}
+	user, err := s.db.user(userID)
+	if err != nil {
+		return err
+	}
+	order := newOrder([]models.ProductUser{item}, checkout)
+	if err = s.db.reserve(&order, checkout.PinCode); err != nil {
+		return err
+	}
+	user.OrderStatus = append(user.OrderStatus, order)
+	return nil
+}
+
+func (s *MemoryOrderStore) FindOrder(ctx context.Context, orderID primitive.ObjectID) (models.Order, string, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user, i := s.db.order(orderID)
+	if user == nil {
+		return models.Order{}, "", ErrCantFindOrder
+	}
+	return copyUser(user).OrderStatus[i], user.ID.Hex(), nil
+}
+
+func (s *MemoryOrderStore) UpdateOrderStatus(ctx context.Context, orderID primitive.ObjectID, from, to string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, i := s.db.order(orderID)
+	if user == nil {
+		return ErrCantFindOrder
+	}
+	order := &user.OrderStatus[i]
+	if order.Status != from {
+		return ErrOrderStatusChanged
+	}
+	order.Status = to
+	onHand, reserved := stockEffect(to)
+	for _, allocation := range order.Allocations {
+		key := stockKey(allocation.WarehouseID, allocation.ProductID, allocation.VariantID)
+		level := s.db.inventory[key]
+		level.OnHand += onHand * allocation.Quantity
+		level.Reserved += reserved * allocation.Quantity
+		s.db.inventory[key] = level
+	}
+	return nil
+}
+
+func (s *MemoryOrderStore) UnpaidOrders(ctx context.Context, now time.Time) ([]primitive.ObjectID, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	users := make([]models.User, 0, len(s.db.users))
+	for _, user := range s.db.users {
+		users = append(users, *user)
+	}
+	return unpaidOrders(users, now), nil
+}
+
+// order returns the user who placed an order and the order's index in their
+// orders, or a nil user. Callers must hold db.mu.
+func (db *MemoryDB) order(orderID primitive.ObjectID) (*models.User, int) {
+	for _, user := range db.users {
+		for i, order := range user.OrderStatus {
+			if order.OrderID == orderID {
+				return user, i
+			}
+		}
+	}
+	return nil, 0
+}
+
+// reserve allocates order to warehouses and holds its stock there. Callers
+// must hold db.mu.
+func (db *MemoryDB) reserve(order *models.Order, pinCode string) error {
+	warehouses := make([]models.Warehouse, 0, len(db.warehouses))
+	for _, warehouse := range db.warehouses {
+		warehouses = append(warehouses, warehouse)
+	}
+	stock := make([]models.StockLevel, 0, len(db.inventory))
+	for _, level := range db.inventory {
+		stock = append(stock, level)
+	}
+	if err := allocate(order, warehouses, stock, pinCode); err != nil {
+		return err
+	}
+	for _, allocation := range order.Allocations {
+		key := stockKey(allocation.WarehouseID, allocation.ProductID, allocation.VariantID)
+		level := db.inventory[key]
+		level.Reserved += allocation.Quantity
+		db.inventory[key] = level
+	}
+	return nil
+}
+
+type MemoryInventoryStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryInventoryStore(db *MemoryDB) *MemoryInventoryStore {
+	return &MemoryInventoryStore{db: db}
+}
+
+func (s *MemoryInventoryStore) Levels(ctx context.Context, productID primitive.ObjectID) ([]models.StockLevel, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	levels := make([]models.StockLevel, 0)
+	for _, level := range s.db.inventory {
+		if level.ProductID == productID {
+			level.Available = level.OnHand - level.Reserved
+			levels = append(levels, level)
+		}
+	}
+	return levels, nil
+}
+
+func (s *MemoryInventoryStore) Adjust(ctx context.Context, adjustment *models.StockAdjustment) (models.StockLevel, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	key := stockKey(adjustment.WarehouseID, adjustment.ProductID, adjustment.VariantID)
+	level, ok := s.db.inventory[key]
+	if !ok {
+		level = models.StockLevel{WarehouseID: adjustment.WarehouseID, ProductID: adjustment.ProductID,
+			VariantID: adjustment.VariantID}
+	}
+	if level.OnHand+adjustment.Delta < level.Reserved {
+		return level, ErrInsufficientStock
+	}
+	level.OnHand += adjustment.Delta
+	s.db.inventory[key] = level
+
+	adjustment.OnHand = level.OnHand
+	s.db.adjustments = append(s.db.adjustments, *adjustment)
+	level.Available = level.OnHand - level.Reserved
+	return level, nil
+}
+
+func (s *MemoryInventoryStore) Adjustments(ctx context.Context, productID primitive.ObjectID) ([]models.StockAdjustment, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	adjustments := make([]models.StockAdjustment, 0)
+	for i := len(s.db.adjustments) - 1; i >= 0; i-- {
+		if s.db.adjustments[i].ProductID == productID {
+			adjustments = append(adjustments, s.db.adjustments[i])
+		}
+	}
+	return adjustments, nil
+}
+
+type MemoryWarehouseStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryWarehouseStore(db *MemoryDB) *MemoryWarehouseStore {
+	return &MemoryWarehouseStore{db: db}
+}
+
+func (s *MemoryWarehouseStore) Create(ctx context.Context, warehouse *models.Warehouse) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.warehouses[warehouse.WarehouseID] = *warehouse
+	return nil
+}
+
+func (s *MemoryWarehouseStore) FindAll(ctx context.Context) ([]models.Warehouse, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	warehouses := make([]models.Warehouse, 0, len(s.db.warehouses))
+	for _, warehouse := range s.db.warehouses {
+		warehouses = append(warehouses, warehouse)
+	}
+	sort.Slice(warehouses, func(i, j int) bool {
+		if warehouses[i].Name != warehouses[j].Name {
+			return warehouses[i].Name < warehouses[j].Name
+		}
+		return warehouses[i].WarehouseID.Hex() < warehouses[j].WarehouseID.Hex()
+	})
+	return warehouses, nil
+}
+
+func (s *MemoryWarehouseStore) Update(ctx context.Context, warehouse models.Warehouse) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.warehouses[warehouse.WarehouseID]; !ok {
+		return ErrCantFindWarehouse
+	}
+	s.db.warehouses[warehouse.WarehouseID] = warehouse
+	return nil
+}
+
+type MemoryAuditStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryAuditStore(db *MemoryDB) *MemoryAuditStore {
+	return &MemoryAuditStore{db: db}
+}
+
+func (s *MemoryAuditStore) Record(ctx context.Context, record models.AuditRecord) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.audit = append(s.db.audit, record)
+	return nil
+}
+
+type MemoryLoginAttemptStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryLoginAttemptStore(db *MemoryDB) *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{db: db}
+}
+
+func (s *MemoryLoginAttemptStore) Get(ctx context.Context, key string) (models.LoginAttempts, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	attempts, ok := s.db.attempts[key]
+	if !ok {
+		attempts.Key = key
+	}
+	return attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (models.LoginAttempts, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	attempts := s.db.attempts[key]
+	attempts.Key = key
+	if attempts.LastFailureAt.After(at.Add(-window)) {
+		attempts.Failures++
+	} else {
+		attempts.Failures = 1
+	}
+	attempts.LastFailureAt = at
+	s.db.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	attempts := s.db.attempts[key]
+	attempts.Key = key
+	attempts.LockedUntil = until
+	s.db.attempts[key] = attempts
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.attempts, key)
+	return nil
+}
@@ -0,0 +1,341 @@
+package database
+
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// migrations is the versioned schema of the SQL backend. Append new entries;
+// never edit one that has shipped. Statements must run on both SQLite and
+// PostgreSQL, so ids are the hex form of an ObjectID and stored as TEXT.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		statements: []string{
+			`CREATE TABLE users (
+				id            TEXT PRIMARY KEY,
+				first_name    TEXT NOT NULL,
+				last_name     TEXT NOT NULL,
+				password      TEXT NOT NULL,
+				email         TEXT NOT NULL UNIQUE,
+				phone         TEXT NOT NULL,
+				token         TEXT NOT NULL DEFAULT '',
+				refresh_token TEXT NOT NULL DEFAULT '',
+				created_at    TIMESTAMP NOT NULL,
+				updated_at    TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX users_phone_idx ON users (phone)`,
+			`CREATE TABLE products (
+				id           TEXT PRIMARY KEY,
+				product_name TEXT NOT NULL,
+				price        BIGINT NOT NULL,
+				rating       INTEGER NOT NULL,
+				image        TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX products_name_idx ON products (product_name)`,
+			`CREATE TABLE cart_items (
+				id         TEXT PRIMARY KEY,
+				user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				product_id TEXT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+				added_at   TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX cart_items_user_idx ON cart_items (user_id)`,
+			`CREATE TABLE orders (
+				id          TEXT PRIMARY KEY,
+				user_id     TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				ordered_at  TIMESTAMP NOT NULL,
+				total_price BIGINT NOT NULL,
+				discount    INTEGER NOT NULL DEFAULT 0,
+				digital     BOOLEAN NOT NULL DEFAULT FALSE,
+				cod         BOOLEAN NOT NULL DEFAULT FALSE
+			)`,
+			`CREATE INDEX orders_user_idx ON orders (user_id)`,
+			// Order lines snapshot the product as it was sold, so later
+			// catalog changes do not rewrite order history.
+			`CREATE TABLE order_lines (
+				order_id     TEXT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+				line_no      INTEGER NOT NULL,
+				product_id   TEXT NOT NULL,
+				product_name TEXT NOT NULL,
+				price        BIGINT NOT NULL,
+				rating       INTEGER NOT NULL,
+				image        TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (order_id, line_no)
+			)`,
+			`CREATE TABLE addresses (
+				id          TEXT PRIMARY KEY,
+				user_id     TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				position    INTEGER NOT NULL,
+				house       TEXT NOT NULL DEFAULT '',
+				street      TEXT NOT NULL DEFAULT '',
+				city        TEXT NOT NULL DEFAULT '',
+				pin_code    TEXT NOT NULL DEFAULT '',
+				UNIQUE (user_id, position)
+			)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "user token version",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 3,
+		name:    "user roles",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'customer'`,
+		},
+	},
+	{
+		version: 4,
+		name:    "audit log",
+		statements: []string{
+			`CREATE TABLE audit_log (
+				id             TEXT PRIMARY KEY,
+				actor_id       TEXT NOT NULL,
+				target_user_id TEXT NOT NULL,
+				action         TEXT NOT NULL,
+				detail         TEXT NOT NULL DEFAULT '',
+				at             TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX audit_log_target_idx ON audit_log (target_user_id, at)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "password reset tokens",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN password_reset_hash TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN password_reset_expires_at TIMESTAMP`,
+			`CREATE INDEX users_password_reset_idx ON users (password_reset_hash)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "email verification",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+	{
+		version: 7,
+		name:    "two-factor authentication",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN two_factor_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0`,
+			`CREATE TABLE recovery_codes (
+				user_id   TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				code_hash TEXT NOT NULL,
+				PRIMARY KEY (user_id, code_hash)
+			)`,
+		},
+	},
+	{
+		version: 8,
+		name:    "linked login identities",
+		statements: []string{
+			`CREATE TABLE user_identities (
+				provider  TEXT NOT NULL,
+				subject   TEXT NOT NULL,
+				user_id   TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				linked_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (provider, subject)
+			)`,
+			`CREATE INDEX user_identities_user_idx ON user_identities (user_id)`,
+		},
+	},
+	{
+		version: 9,
+		name:    "login attempts",
+		statements: []string{
+			`CREATE TABLE login_attempts (
+				attempt_key     TEXT PRIMARY KEY,
+				failures        INTEGER NOT NULL DEFAULT 0,
+				last_failure_at TIMESTAMP,
+				locked_until    TIMESTAMP
+			)`,
+		},
+	},
+	{
+		version: 10,
+		name:    "disabled users",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+	{
+		version: 11,
+		name:    "personal data erasure",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN erasure_scheduled_at TIMESTAMP`,
+			`ALTER TABLE users ADD COLUMN erased_at TIMESTAMP`,
+			`CREATE INDEX users_erasure_idx ON users (erasure_scheduled_at)`,
+		},
+	},
+	{
+		version: 12,
+		name:    "product versions and archiving",
+		statements: []string{
+			`ALTER TABLE products ADD COLUMN version INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE products ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+	{
+		version: 13,
+		name:    "product categories",
+		statements: []string{
+			`CREATE TABLE categories (
+				id         TEXT PRIMARY KEY,
+				name       TEXT NOT NULL,
+				slug       TEXT NOT NULL UNIQUE,
+				parent_id  TEXT REFERENCES categories (id),
+				sort_order INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE product_categories (
+				product_id  TEXT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+				category_id TEXT NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+				PRIMARY KEY (product_id, category_id)
+			)`,
+			`CREATE INDEX product_categories_category_idx ON product_categories (category_id)`,
+		},
+	},
+	{
+		version: 14,
+		name:    "product variants",
+		statements: []string{
+			// A NULL price or an empty image falls back to the product's.
+			`CREATE TABLE product_variants (
+				id         TEXT PRIMARY KEY,
+				product_id TEXT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+				position   INTEGER NOT NULL,
+				sku        TEXT NOT NULL UNIQUE,
+				attributes TEXT NOT NULL DEFAULT '{}',
+				price      BIGINT,
+				image      TEXT NOT NULL DEFAULT '',
+				stock      INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX product_variants_product_idx ON product_variants (product_id)`,
+			`ALTER TABLE cart_items ADD COLUMN variant_id TEXT REFERENCES product_variants (id) ON DELETE CASCADE`,
+			`ALTER TABLE order_lines ADD COLUMN variant_id TEXT`,
+			`ALTER TABLE order_lines ADD COLUMN sku TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 15,
+		name:    "inventory",
+		statements: []string{
+			// variant_id is '' for a product without variants, so it can be
+			// part of the key.
+			`CREATE TABLE inventory (
+				product_id TEXT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+				variant_id TEXT NOT NULL DEFAULT '',
+				on_hand    INTEGER NOT NULL DEFAULT 0,
+				reserved   INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (product_id, variant_id)
+			)`,
+			`INSERT INTO inventory (product_id, variant_id, on_hand)
+				SELECT product_id, id, stock FROM product_variants WHERE stock > 0`,
+			`CREATE TABLE stock_adjustments (
+				id         TEXT PRIMARY KEY,
+				product_id TEXT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+				variant_id TEXT NOT NULL DEFAULT '',
+				delta      INTEGER NOT NULL,
+				reason     TEXT NOT NULL,
+				note       TEXT NOT NULL DEFAULT '',
+				on_hand    INTEGER NOT NULL,
+				actor_id   TEXT NOT NULL,
+				at         TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX stock_adjustments_product_idx ON stock_adjustments (product_id, at)`,
+			`ALTER TABLE orders ADD COLUMN status TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE orders ADD COLUMN payment_due TIMESTAMP`,
+			`CREATE INDEX orders_status_idx ON orders (status)`,
+		},
+	},
+	{
+		version: 16,
+		name:    "warehouses",
+		statements: []string{
+			`CREATE TABLE warehouses (
+				id       TEXT PRIMARY KEY,
+				name     TEXT NOT NULL,
+				pin_code TEXT NOT NULL,
+				active   BOOLEAN NOT NULL DEFAULT TRUE
+			)`,
+			// Stock recorded before warehouses existed, and the orders
+			// holding it, move to a "Main" warehouse, whose PIN code the
+			// admins fill in.
+			`INSERT INTO warehouses (id, name, pin_code, active)
+				SELECT '000000000000000000000001', 'Main', '', TRUE WHERE EXISTS (SELECT 1 FROM inventory)`,
+			`CREATE TABLE warehouse_stock (
+				warehouse_id TEXT NOT NULL REFERENCES warehouses (id),
+				product_id   TEXT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+				variant_id   TEXT NOT NULL DEFAULT '',
+				on_hand      INTEGER NOT NULL DEFAULT 0,
+				reserved     INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (warehouse_id, product_id, variant_id)
+			)`,
+			`INSERT INTO warehouse_stock (warehouse_id, product_id, variant_id, on_hand, reserved)
+				SELECT '000000000000000000000001', product_id, variant_id, on_hand, reserved FROM inventory`,
+			`DROP TABLE inventory`,
+			`ALTER TABLE warehouse_stock RENAME TO inventory`,
+			`CREATE INDEX inventory_product_idx ON inventory (product_id)`,
+			`ALTER TABLE stock_adjustments ADD COLUMN warehouse_id TEXT NOT NULL DEFAULT ''`,
+			`UPDATE stock_adjustments SET warehouse_id = '000000000000000000000001'`,
+			`CREATE TABLE order_allocations (
+				order_id     TEXT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+				warehouse_id TEXT NOT NULL REFERENCES warehouses (id),
+				product_id   TEXT NOT NULL,
+				variant_id   TEXT NOT NULL DEFAULT '',
+				quantity     INTEGER NOT NULL,
+				PRIMARY KEY (order_id, warehouse_id, product_id, variant_id)
+			)`,
+			`INSERT INTO order_allocations (order_id, warehouse_id, product_id, variant_id, quantity)
+				SELECT l.order_id, '000000000000000000000001', l.product_id, COALESCE(l.variant_id, ''), COUNT(*)
+				FROM order_lines l JOIN orders o ON o.id = l.order_id
+				WHERE o.status IN ('pending_payment', 'placed')
+					AND EXISTS (SELECT 1 FROM warehouses WHERE id = '000000000000000000000001')
+				GROUP BY l.order_id, l.product_id, COALESCE(l.variant_id, '')`,
+		},
+	},
+	{
+		version: 17,
+		name:    "product listing indexes",
+		statements: []string{
+			`CREATE INDEX products_price_idx ON products (price, id)`,
+			`CREATE INDEX products_rating_idx ON products (rating, id)`,
+		},
+	},
+	{
+		version: 18,
+		name:    "product descriptions",
+		statements: []string{
+			`ALTER TABLE products ADD COLUMN description TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 19,
+		name:    "refresh token families",
+		statements: []string{
+			`CREATE TABLE refresh_families (
+				user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				family     TEXT NOT NULL,
+				token_id   TEXT NOT NULL,
+				started_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (user_id, family)
+			)`,
+		},
+	},
+	{
+		version: 20,
+		name:    "case-insensitive email lookup",
+		statements: []string{
+			`CREATE INDEX users_email_lower_idx ON users (LOWER(email))`,
+		},
+	},
+}
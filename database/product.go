@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+type MongoProductStore struct {
+	prodCollection *mongo.Collection
+}
+
+func NewMongoProductStore(prodCollection *mongo.Collection) *MongoProductStore {
+	return &MongoProductStore{prodCollection: prodCollection}
+}
+
+func (s *MongoProductStore) Create(ctx context.Context, product *models.Product) error {
+	if err := s.checkSKUs(ctx, *product); err != nil {
+		return err
+	}
+	_, err := s.prodCollection.InsertOne(ctx, product)
+	if err != nil {
+		log.Println(err)
+		return ErrCantCreateProduct
+	}
+	return nil
+}
+
+func (s *MongoProductStore) FindAll(ctx context.Context) ([]models.Product, error) {
+	return s.find(ctx, bson.M{"archived": bson.M{"$ne": true}})
+}
+
+func (s *MongoProductStore) FindByID(ctx context.Context, productID primitive.ObjectID) (models.Product, error) {
+	var product models.Product
+	err := s.prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return product, ErrCantFindProduct
+	}
+	return product, err
+}
+
+func (s *MongoProductStore) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Product, error) {
+	if len(ids) == 0 {
+		return make([]models.Product, 0), nil
+	}
+	return s.find(ctx, bson.M{"_id": bson.M{"$in": ids}, "archived": bson.M{"$ne": true}})
+}
+
+func (s *MongoProductStore) List(ctx context.Context, filter ProductFilter) ([]models.Product, int64, error) {
+	query := bson.D{{Key: "archived", Value: bson.M{"$ne": true}}}
+	if filter.CategoryIDs != nil {
+		query = append(query, primitive.E{Key: "category_ids", Value: bson.M{"$in": filter.CategoryIDs}})
+	}
+	price := bson.M{}
+	if filter.MinPrice > 0 {
+		price["$gte"] = filter.MinPrice
+	}
+	if filter.MaxPrice > 0 {
+		price["$lte"] = filter.MaxPrice
+	}
+	if len(price) > 0 {
+		query = append(query, primitive.E{Key: "price", Value: price})
+	}
+	if filter.MinRating > 0 {
+		query = append(query, primitive.E{Key: "rating", Value: bson.M{"$gte": filter.MinRating}})
+	}
+
+	total, err := s.prodCollection.CountDocuments(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+
+	field, desc := productSort(filter.Sort)
+	op, dir := "$gt", 1
+	if desc {
+		op, dir = "$lt", -1
+	}
+	opts := options.Find()
+	if field == "" {
+		opts.SetSort(bson.D{{Key: "_id", Value: dir}})
+	} else {
+		opts.SetSort(bson.D{{Key: field, Value: dir}, {Key: "_id", Value: dir}})
+	}
+	if filter.After == nil {
+		opts.SetSkip(int64(filter.Offset))
+	} else if field == "" {
+		query = append(query, primitive.E{Key: "_id", Value: bson.M{op: filter.After.ProductID}})
+	} else {
+		value := sortValue(*filter.After, field)
+		query = append(query, primitive.E{Key: "$or", Value: bson.A{
+			bson.M{field: bson.M{op: value}},
+			bson.M{field: value, "_id": bson.M{op: filter.After.ProductID}}}})
+	}
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	cursor, err := s.prodCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+	defer cursor.Close(ctx)
+
+	products := make([]models.Product, 0)
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantDecodeProducts
+	}
+	return products, total, nil
+}
+
+// EnsureIndexes creates the indexes List sorts and filters the catalog by.
+// Creating an index that already exists does nothing.
+func (s *MongoProductStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.prodCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "price", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "rating", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "product_name", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "category_ids", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoProductStore) Update(ctx context.Context, product models.Product, version int) error {
+	// Products created before versioning have no version field.
+	var current interface{} = version
+	if version == 0 {
+		current = bson.M{"$in": bson.A{0, nil}}
+	}
+	if err := s.checkSKUs(ctx, product); err != nil {
+		return err
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: product.ProductID}, {Key: "version", Value: current}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "product_name", Value: product.ProductName},
+		{Key: "description", Value: product.Description},
+		{Key: "price", Value: product.Price},
+		{Key: "rating", Value: product.Rating},
+		{Key: "image", Value: product.Image},
+		{Key: "archived", Value: product.Archived},
+		{Key: "category_ids", Value: product.CategoryIDs},
+		{Key: "variants", Value: product.Variants},
+		{Key: "version", Value: version + 1}}}}
+	result, err := s.prodCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		if _, err = s.FindByID(ctx, product.ProductID); err != nil {
+			return err
+		}
+		return ErrProductConflict
+	}
+	return nil
+}
+
+// checkSKUs fails with ErrSKUTaken when another product has a variant with
+// one of the SKUs of product.
+func (s *MongoProductStore) checkSKUs(ctx context.Context, product models.Product) error {
+	if len(product.Variants) == 0 {
+		return nil
+	}
+	skus := make(bson.A, 0, len(product.Variants))
+	for _, variant := range product.Variants {
+		skus = append(skus, variant.SKU)
+	}
+	count, err := s.prodCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$ne": product.ProductID},
+		"variants.sku": bson.M{"$in": skus}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	if count > 0 {
+		return ErrSKUTaken
+	}
+	return nil
+}
+
+// productSort returns the field a ProductFilter.Sort orders by, empty for
+// the id alone, and whether it runs high to low. Ties are broken by id in
+// the same direction. The field names are the same in Mongo and SQL.
+func productSort(sort string) (field string, desc bool) {
+	switch sort {
+	case SortPriceAsc:
+		return "price", false
+	case SortPriceDesc:
+		return "price", true
+	case SortRating:
+		return "rating", true
+	case SortName:
+		return "product_name", false
+	}
+	return "", true
+}
+
+// sortValue returns the value of product's field as named by productSort.
+func sortValue(product models.Product, field string) interface{} {
+	switch field {
+	case "price":
+		return product.Price
+	case "rating":
+		return product.Rating
+	}
+	return product.ProductName
+}
+
+func (s *MongoProductStore) find(ctx context.Context, filter interface{}) ([]models.Product, error) {
+	cursor, err := s.prodCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	products := make([]models.Product, 0)
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, ErrCantDecodeProducts
+	}
+	return products, cursor.Err()
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"testing"
+)
+
+func TestFindByIDs(t *testing.T) {
+	for name, stores := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			var ids []primitive.ObjectID
+			for _, product := range []models.Product{
+				{ProductID: primitive.NewObjectID(), ProductName: "Mug"},
+				{ProductID: primitive.NewObjectID(), ProductName: "Lamp", Archived: true},
+				{ProductID: primitive.NewObjectID(), ProductName: "Bowl"},
+			} {
+				if err := stores.Products.Create(ctx, &product); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, product.ProductID)
+			}
+
+			found, err := stores.Products.FindByIDs(ctx, append(ids, primitive.NewObjectID()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make([]string, 0, len(found))
+			for _, product := range found {
+				names = append(names, product.ProductName)
+			}
+			sort.Strings(names)
+			if len(names) != 2 || names[0] != "Bowl" || names[1] != "Mug" {
+				t.Errorf("FindByIDs() = %v, want Bowl and Mug", names)
+			}
+
+			if found, err = stores.Products.FindByIDs(ctx, nil); err != nil || len(found) != 0 {
+				t.Errorf("FindByIDs(nil) = %v, %v, want nothing", found, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"log"
+	_ "modernc.org/sqlite"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLDB is a relational connection shared by the SQL stores. SQLite and
+// PostgreSQL are supported; queries are written with ? placeholders and
+// rebound for the active driver.
+type SQLDB struct {
+	*sql.DB
+	driver string
+}
+
+// SQLSet opens a "sqlite" or "postgres" database and brings its schema up to
+// the latest migration.
+func SQLSet(cfg config.Database) (*SQLDB, error) {
+	driver, dsn := cfg.Driver, cfg.URL
+	if driver != "sqlite" && driver != "postgres" {
+		return nil, fmt.Errorf("unsupported sql driver %q", driver)
+	}
+	conn, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// SQLite allows a single writer; sharing one connection avoids
+		// "database is locked" errors and makes pragmas stick.
+		conn.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+
+	if err = conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	db := &SQLDB{DB: conn, driver: driver}
+	if driver == "sqlite" {
+		if _, err = db.ExecContext(ctx, "PRAGMA foreign_keys = ON"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if err = db.Migrate(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	fmt.Println("SQL Connection Success!")
+	return db, nil
+}
+
+// rebind rewrites ? placeholders into $1, $2, ... for PostgreSQL.
+func (db *SQLDB) rebind(query string) string {
+	if db.driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Migrate applies every migration newer than the recorded schema version,
+// each in its own transaction.
+func (db *SQLDB) Migrate(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	row := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	if err = row.Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range m.statements {
+			if _, err = tx.ExecContext(ctx, stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+		_, err = tx.ExecContext(ctx, db.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"),
+			m.version, time.Now().UTC())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("applied migration %d (%s)", m.version, m.name)
+	}
+	return nil
+}
+
+// withTx runs fn in a transaction and commits it when fn returns nil.
+func (db *SQLDB) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
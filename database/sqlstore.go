@@ -0,0 +1,1884 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const userColumns = "id, first_name, last_name, password, email, phone, token, refresh_token, token_version, role, email_verified, two_factor_enabled, totp_secret, totp_last_step, disabled, created_at, updated_at"
+
+// loadUser reads one user row and assembles the embedded cart, addresses and
+// orders that models.User carries.
+func (db *SQLDB) loadUser(ctx context.Context, q querier, where string, arg interface{}) (models.User, error) {
+	var user models.User
+	var id string
+	// The erasure timestamps are NULL until set, so they are read here but
+	// left out of userColumns, which Create inserts.
+	var erasureScheduledAt, erasedAt sql.NullTime
+	row := q.QueryRowContext(ctx, db.rebind("SELECT "+userColumns+", erasure_scheduled_at, erased_at FROM users WHERE "+where), arg)
+	err := row.Scan(&id, &user.FirstName, &user.LastName, &user.Password, &user.Email, &user.Phone,
+		&user.Token, &user.RefreshToken, &user.TokenVersion, &user.Role, &user.EmailVerified,
+		&user.TwoFactorEnabled, &user.TOTPSecret, &user.TOTPLastStep, &user.Disabled, &user.CreatedAt, &user.UpdatedAt,
+		&erasureScheduledAt, &erasedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrUserNotFound
+	}
+	if err != nil {
+		return user, err
+	}
+	if user.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return user, err
+	}
+	user.UserID = id
+	user.ErasureScheduledAt = erasureScheduledAt.Time
+	user.ErasedAt = erasedAt.Time
+
+	if user.UserCart, err = db.loadCart(ctx, q, id); err != nil {
+		return user, err
+	}
+	if user.AddressDetails, err = db.loadAddresses(ctx, q, id); err != nil {
+		return user, err
+	}
+	if user.OrderStatus, err = db.loadOrders(ctx, q, id); err != nil {
+		return user, err
+	}
+	if user.Identities, err = db.loadIdentities(ctx, q, id); err != nil {
+		return user, err
+	}
+	if user.RefreshFamilies, err = db.loadRefreshFamilies(ctx, q, id); err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// loadRefreshFamilies returns the user's refresh token families, oldest
+// first. Compared in Go: SQLite keeps timestamps as text.
+func (db *SQLDB) loadRefreshFamilies(ctx context.Context, q querier, userID string) ([]models.RefreshFamily, error) {
+	rows, err := q.QueryContext(ctx, db.rebind(`SELECT family, token_id, started_at FROM refresh_families
+		WHERE user_id = ?`), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var families []models.RefreshFamily
+	for rows.Next() {
+		var family models.RefreshFamily
+		if err = rows.Scan(&family.Family, &family.TokenID, &family.StartedAt); err != nil {
+			return nil, err
+		}
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		if !families[i].StartedAt.Equal(families[j].StartedAt) {
+			return families[i].StartedAt.Before(families[j].StartedAt)
+		}
+		return families[i].Family < families[j].Family
+	})
+	return families, rows.Err()
+}
+
+func (db *SQLDB) loadIdentities(ctx context.Context, q querier, userID string) ([]models.Identity, error) {
+	rows, err := q.QueryContext(ctx, db.rebind(`SELECT provider, subject, linked_at FROM user_identities
+		WHERE user_id = ? ORDER BY linked_at`), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]models.Identity, 0)
+	for rows.Next() {
+		var identity models.Identity
+		if err = rows.Scan(&identity.Provider, &identity.Subject, &identity.LinkedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (db *SQLDB) loadCart(ctx context.Context, q querier, userID string) ([]models.ProductUser, error) {
+	rows, err := q.QueryContext(ctx, db.rebind(`SELECT p.id, p.product_name, COALESCE(v.price, p.price), p.rating,
+		COALESCE(NULLIF(v.image, ''), p.image), v.id, COALESCE(v.sku, '')
+		FROM cart_items c JOIN products p ON p.id = c.product_id
+		LEFT JOIN product_variants v ON v.id = c.variant_id
+		WHERE c.user_id = ? ORDER BY c.added_at, c.id`), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cart := make([]models.ProductUser, 0)
+	for rows.Next() {
+		var item models.ProductUser
+		var id string
+		var variantID sql.NullString
+		err = rows.Scan(&id, &item.ProductName, &item.Price, &item.Rating, &item.Image, &variantID, &item.SKU)
+		if err != nil {
+			return nil, err
+		}
+		item.ProductID, _ = primitive.ObjectIDFromHex(id)
+		item.VariantID = variantRef(variantID)
+		cart = append(cart, item)
+	}
+	return cart, rows.Err()
+}
+
+func (db *SQLDB) loadAddresses(ctx context.Context, q querier, userID string) ([]models.Address, error) {
+	rows, err := q.QueryContext(ctx, db.rebind(`SELECT id, house, street, city, pin_code
+		FROM addresses WHERE user_id = ? ORDER BY position`), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := make([]models.Address, 0)
+	for rows.Next() {
+		var address models.Address
+		var id string
+		if err = rows.Scan(&id, &address.House, &address.Street, &address.City, &address.PinCode); err != nil {
+			return nil, err
+		}
+		address.AddressID, _ = primitive.ObjectIDFromHex(id)
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
+
+func (db *SQLDB) loadOrders(ctx context.Context, q querier, userID string) ([]models.Order, error) {
+	return db.queryOrders(ctx, q, "user_id = ? ORDER BY ordered_at, id", userID)
+}
+
+// queryOrders loads the orders matching where, with their lines and
+// allocations.
+func (db *SQLDB) queryOrders(ctx context.Context, q querier, where string, args ...interface{}) ([]models.Order, error) {
+	rows, err := q.QueryContext(ctx, db.rebind(`SELECT id, ordered_at, total_price, discount, digital, cod,
+		status, payment_due FROM orders WHERE `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]models.Order, 0)
+	for rows.Next() {
+		var order models.Order
+		var id string
+		var paymentDue sql.NullTime
+		err = rows.Scan(&id, &order.OrderedAt, &order.Price, &order.Discount,
+			&order.PaymentMethod.Digital, &order.PaymentMethod.COD, &order.Status, &paymentDue)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		order.OrderID, _ = primitive.ObjectIDFromHex(id)
+		if paymentDue.Valid {
+			order.PaymentDue = &paymentDue.Time
+		}
+		orders = append(orders, order)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range orders {
+		if orders[i].OrderCart, err = db.loadOrderLines(ctx, q, orders[i].OrderID.Hex()); err != nil {
+			return nil, err
+		}
+		if orders[i].Allocations, err = db.loadAllocations(ctx, q, orders[i].OrderID.Hex()); err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+func (db *SQLDB) loadAllocations(ctx context.Context, q querier, orderID string) ([]models.Allocation, error) {
+	rows, err := q.QueryContext(ctx, db.rebind(`SELECT warehouse_id, product_id, variant_id, quantity
+		FROM order_allocations WHERE order_id = ? ORDER BY warehouse_id, product_id, variant_id`), orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allocations []models.Allocation
+	for rows.Next() {
+		var allocation models.Allocation
+		var warehouseID, productID, variantID string
+		if err = rows.Scan(&warehouseID, &productID, &variantID, &allocation.Quantity); err != nil {
+			return nil, err
+		}
+		allocation.WarehouseID, _ = primitive.ObjectIDFromHex(warehouseID)
+		allocation.ProductID, _ = primitive.ObjectIDFromHex(productID)
+		allocation.VariantID = variantRef(sql.NullString{String: variantID, Valid: variantID != ""})
+		allocations = append(allocations, allocation)
+	}
+	return allocations, rows.Err()
+}
+
+func (db *SQLDB) loadOrderLines(ctx context.Context, q querier, orderID string) ([]models.ProductUser, error) {
+	rows, err := q.QueryContext(ctx, db.rebind(`SELECT product_id, product_name, price, rating, image, variant_id, sku
+		FROM order_lines WHERE order_id = ? ORDER BY line_no`), orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make([]models.ProductUser, 0)
+	for rows.Next() {
+		var line models.ProductUser
+		var id string
+		var variantID sql.NullString
+		err = rows.Scan(&id, &line.ProductName, &line.Price, &line.Rating, &line.Image, &variantID, &line.SKU)
+		if err != nil {
+			return nil, err
+		}
+		line.ProductID, _ = primitive.ObjectIDFromHex(id)
+		line.VariantID = variantRef(variantID)
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// insertOrder writes an order, its lines and its allocations inside tx.
+func (db *SQLDB) insertOrder(ctx context.Context, tx *sql.Tx, userID string, order models.Order) error {
+	var paymentDue interface{}
+	if order.PaymentDue != nil {
+		paymentDue = order.PaymentDue.UTC()
+	}
+	_, err := tx.ExecContext(ctx, db.rebind(`INSERT INTO orders
+		(id, user_id, ordered_at, total_price, discount, digital, cod, status, payment_due)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		order.OrderID.Hex(), userID, order.OrderedAt.UTC(), order.Price, order.Discount,
+		order.PaymentMethod.Digital, order.PaymentMethod.COD, order.Status, paymentDue)
+	if err != nil {
+		return err
+	}
+	for i, line := range order.OrderCart {
+		_, err = tx.ExecContext(ctx, db.rebind(`INSERT INTO order_lines
+			(order_id, line_no, product_id, product_name, price, rating, image, variant_id, sku)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			order.OrderID.Hex(), i, line.ProductID.Hex(), line.ProductName, line.Price, line.Rating, line.Image,
+			variantColumn(line.VariantID), line.SKU)
+		if err != nil {
+			return err
+		}
+	}
+	for _, allocation := range order.Allocations {
+		_, err = tx.ExecContext(ctx, db.rebind(`INSERT INTO order_allocations
+			(order_id, warehouse_id, product_id, variant_id, quantity) VALUES (?, ?, ?, ?, ?)`),
+			order.OrderID.Hex(), allocation.WarehouseID.Hex(), allocation.ProductID.Hex(),
+			stockVariant(allocation.VariantID), allocation.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantRef reads a nullable variant_id column.
+func variantRef(id sql.NullString) *primitive.ObjectID {
+	if !id.Valid {
+		return nil
+	}
+	variantID, _ := primitive.ObjectIDFromHex(id.String)
+	return &variantID
+}
+
+// variantColumn is the variant_id value of a cart or order line: NULL for a
+// product without variants.
+func variantColumn(variantID *primitive.ObjectID) interface{} {
+	if variantID == nil {
+		return nil
+	}
+	return variantID.Hex()
+}
+
+// userExists reports ErrUserIdIsNotValid or ErrUserNotFound for a bad user id.
+func (db *SQLDB) userExists(ctx context.Context, q querier, userID string) error {
+	if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+		return ErrUserIdIsNotValid
+	}
+	var one int
+	err := q.QueryRowContext(ctx, db.rebind("SELECT 1 FROM users WHERE id = ?"), userID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+type SQLUserStore struct {
+	db *SQLDB
+}
+
+func NewSQLUserStore(db *SQLDB) *SQLUserStore {
+	return &SQLUserStore{db: db}
+}
+
+func (s *SQLUserStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER(?)"), email).Scan(&count)
+	return count, err
+}
+
+func (s *SQLUserStore) CountByPhone(ctx context.Context, phone string) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT COUNT(*) FROM users WHERE phone = ?"), phone).Scan(&count)
+	return count, err
+}
+
+func (s *SQLUserStore) Create(ctx context.Context, user *models.User) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		user.ID.Hex(), user.FirstName, user.LastName, user.Password, user.Email, user.Phone,
+		user.Token, user.RefreshToken, user.TokenVersion, user.Role, user.EmailVerified,
+		user.TwoFactorEnabled, user.TOTPSecret, user.TOTPLastStep, user.Disabled, user.CreatedAt.UTC(), user.UpdatedAt.UTC())
+	if err != nil {
+		log.Println(err)
+		return ErrCantCreateUser
+	}
+	return nil
+}
+
+func (s *SQLUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	return s.db.loadUser(ctx, s.db, "LOWER(email) = LOWER(?) ORDER BY id LIMIT 1", email)
+}
+
+func (s *SQLUserStore) FindByID(ctx context.Context, userID string) (models.User, error) {
+	if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+		return models.User{}, ErrUserIdIsNotValid
+	}
+	return s.db.loadUser(ctx, s.db, "id = ?", userID)
+}
+
+// likeEscaper makes a search term match literally inside a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *SQLUserStore) List(ctx context.Context, filter UserFilter) ([]models.User, int64, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if filter.Role != "" {
+		where = append(where, "role = ?")
+		args = append(args, filter.Role)
+	}
+	if filter.Disabled != nil {
+		where = append(where, "disabled = ?")
+		args = append(args, *filter.Disabled)
+	}
+	if filter.EmailVerified != nil {
+		where = append(where, "email_verified = ?")
+		args = append(args, *filter.EmailVerified)
+	}
+	if filter.Search != "" {
+		where = append(where, `(LOWER(first_name) LIKE ? ESCAPE '\' OR LOWER(last_name) LIKE ? ESCAPE '\'
+			OR LOWER(email) LIKE ? ESCAPE '\' OR LOWER(phone) LIKE ? ESCAPE '\')`)
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(filter.Search)) + "%"
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+	clause := strings.Join(where, " AND ")
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT COUNT(*) FROM users WHERE "+clause), args...).Scan(&total)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListUsers
+	}
+
+	query := "SELECT id FROM users WHERE " + clause + " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.db.rebind(query), args...)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListUsers
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	users := make([]models.User, 0, len(ids))
+	for _, id := range ids {
+		user, err := s.db.loadUser(ctx, s.db, "id = ?", id)
+		if errors.Is(err, ErrUserNotFound) {
+			// Deleted since the page was read.
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, nil
+}
+
+func (s *SQLUserStore) FindByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	var userID string
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?"),
+		provider, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return s.db.loadUser(ctx, s.db, "id = ?", userID)
+}
+
+func (s *SQLUserStore) LinkIdentity(ctx context.Context, userID string, identity models.Identity) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.db.userExists(ctx, tx, userID); err != nil {
+			return err
+		}
+		var owner string
+		err := tx.QueryRowContext(ctx, s.db.rebind("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?"),
+			identity.Provider, identity.Subject).Scan(&owner)
+		if err == nil {
+			return ErrIdentityLinked
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.db.rebind("INSERT INTO user_identities (provider, subject, user_id, linked_at) VALUES (?, ?, ?, ?)"),
+			identity.Provider, identity.Subject, userID, identity.LinkedAt.UTC())
+		if err != nil {
+			log.Println(err)
+			return ErrCantLinkIdentity
+		}
+		return nil
+	})
+}
+
+func (s *SQLUserStore) UpdateTokens(ctx context.Context, userID, signedToken string, family models.RefreshFamily) error {
+	err := s.db.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.db.rebind("UPDATE users SET token = ?, refresh_token = '', updated_at = ? WHERE id = ?"),
+			signedToken, time.Now().UTC(), userID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.db.rebind(`INSERT INTO refresh_families (user_id, family, token_id, started_at)
+			VALUES (?, ?, ?, ?)`), userID, family.Family, family.TokenID, family.StartedAt.UTC())
+		if err != nil {
+			return err
+		}
+		families, err := s.db.loadRefreshFamilies(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(families)-MaxRefreshFamilies; i++ {
+			_, err = tx.ExecContext(ctx, s.db.rebind("DELETE FROM refresh_families WHERE user_id = ? AND family = ?"),
+				userID, families[i].Family)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+	return nil
+}
+
+func (s *SQLUserStore) RotateTokens(ctx context.Context, userID string, current models.RefreshFamily, newTokenID, signedToken string) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE refresh_families SET token_id = ?
+			WHERE user_id = ? AND family = ? AND token_id = ?`), newTokenID, userID, current.Family, current.TokenID)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateTokens
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			var count int
+			err = tx.QueryRowContext(ctx, s.db.rebind("SELECT COUNT(*) FROM refresh_families WHERE user_id = ? AND family = ?"),
+				userID, current.Family).Scan(&count)
+			if err != nil {
+				log.Println(err)
+				return ErrCantUpdateTokens
+			}
+			if count == 0 {
+				return ErrRefreshFamilyEnded
+			}
+			return ErrRefreshTokenStale
+		}
+		_, err = tx.ExecContext(ctx, s.db.rebind("UPDATE users SET token = ?, refresh_token = '', updated_at = ? WHERE id = ?"),
+			signedToken, time.Now().UTC(), userID)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateTokens
+		}
+		return nil
+	})
+}
+
+func (s *SQLUserStore) RevokeFamily(ctx context.Context, userID, family string) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("DELETE FROM refresh_families WHERE user_id = ? AND family = ?"),
+		userID, family)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+	return nil
+}
+
+func (s *SQLUserStore) RevokeTokens(ctx context.Context, userID string) error {
+	err := s.db.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE users SET token = '', refresh_token = '',
+			token_version = token_version + 1, updated_at = ? WHERE id = ?`), time.Now().UTC(), userID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.db.rebind("DELETE FROM refresh_families WHERE user_id = ?"), userID)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+	return nil
+}
+
+func (s *SQLUserStore) SetRole(ctx context.Context, userID, role string) error {
+	result, err := s.db.ExecContext(ctx, s.db.rebind("UPDATE users SET role = ?, updated_at = ? WHERE id = ?"),
+		role, time.Now().UTC(), userID)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateRole
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLUserStore) SetDisabled(ctx context.Context, userID string, disabled bool) error {
+	query := "UPDATE users SET disabled = ?, updated_at = ? WHERE id = ?"
+	if disabled {
+		query = `UPDATE users SET disabled = ?, token = '', refresh_token = '',
+			token_version = token_version + 1, updated_at = ? WHERE id = ?`
+	}
+	result, err := s.db.ExecContext(ctx, s.db.rebind(query), disabled, time.Now().UTC(), userID)
+	if err != nil {
+		log.Println(err)
+		return ErrCantDisableUser
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLUserStore) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	result, err := s.db.ExecContext(ctx, s.db.rebind("UPDATE users SET email_verified = ? WHERE id = ? AND email = ?"),
+		true, userID, email)
+	if err != nil {
+		log.Println(err)
+		return ErrCantVerifyEmail
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLUserStore) SetTOTPSecret(ctx context.Context, userID, secret string) error {
+	result, err := s.db.ExecContext(ctx, s.db.rebind("UPDATE users SET totp_secret = ? WHERE id = ? AND two_factor_enabled = ?"),
+		secret, userID, false)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		if err = s.db.userExists(ctx, s.db, userID); err != nil {
+			return err
+		}
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+func (s *SQLUserStore) EnableTwoFactor(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE users SET two_factor_enabled = ?, totp_last_step = ?,
+			token = '', refresh_token = '', token_version = token_version + 1, updated_at = ?
+			WHERE id = ? AND two_factor_enabled = ?`), true, step, time.Now().UTC(), userID, false)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateTwoFactor
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			if err = s.db.userExists(ctx, tx, userID); err != nil {
+				return err
+			}
+			return ErrTwoFactorEnabled
+		}
+		return s.db.replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	})
+}
+
+func (s *SQLUserStore) DisableTwoFactor(ctx context.Context, userID string) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE users SET two_factor_enabled = ?, totp_secret = '',
+			totp_last_step = 0 WHERE id = ?`), false, userID)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateTwoFactor
+		}
+		return s.db.replaceRecoveryCodes(ctx, tx, userID, nil)
+	})
+}
+
+// replaceRecoveryCodes swaps the stored recovery code hashes of a user.
+func (db *SQLDB) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, db.rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID); err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, db.rebind("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)"), userID, hash)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateTwoFactor
+		}
+	}
+	return nil
+}
+
+func (s *SQLUserStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	result, err := s.db.ExecContext(ctx, s.db.rebind("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?"),
+		step, userID, step)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrTOTPCodeUsed
+	}
+	return nil
+}
+
+func (s *SQLUserStore) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	result, err := s.db.ExecContext(ctx, s.db.rebind("DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?"),
+		userID, codeHash)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrRecoveryCodeUsed
+	}
+	return nil
+}
+
+func (s *SQLUserStore) UpdateProfile(ctx context.Context, user models.User) error {
+	result, err := s.db.ExecContext(ctx, s.db.rebind(`UPDATE users SET first_name = ?, last_name = ?, phone = ?,
+		email = ?, email_verified = ?, updated_at = ? WHERE id = ?`),
+		user.FirstName, user.LastName, user.Phone, user.Email, user.EmailVerified, time.Now().UTC(), user.UserID)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProfile
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLUserStore) ChangePassword(ctx context.Context, userID, hashedPassword string) error {
+	result, err := s.db.ExecContext(ctx, s.db.rebind(`UPDATE users SET password = ?, password_reset_hash = '',
+		token = '', refresh_token = '', token_version = token_version + 1, updated_at = ? WHERE id = ?`),
+		hashedPassword, time.Now().UTC(), userID)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePassword
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLUserStore) ScheduleErasure(ctx context.Context, userID string, at time.Time) error {
+	var scheduled interface{}
+	if !at.IsZero() {
+		scheduled = at.UTC()
+	}
+	result, err := s.db.ExecContext(ctx, s.db.rebind("UPDATE users SET erasure_scheduled_at = ? WHERE id = ?"),
+		scheduled, userID)
+	if err != nil {
+		log.Println(err)
+		return ErrCantEraseUser
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLUserStore) CancelErasure(ctx context.Context, userID string) error {
+	return s.ScheduleErasure(ctx, userID, time.Time{})
+}
+
+func (s *SQLUserStore) DueErasures(ctx context.Context, now time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, erasure_scheduled_at FROM users
+		WHERE erasure_scheduled_at IS NOT NULL AND erased_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		var scheduled time.Time
+		if err = rows.Scan(&id, &scheduled); err != nil {
+			return nil, err
+		}
+		// Compared in Go: SQLite keeps timestamps as text.
+		if !scheduled.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// Erase anonymizes the users row and deletes the rows holding personal
+// data. Orders reference the user by id only and are kept.
+func (s *SQLUserStore) Erase(ctx context.Context, userID string, at time.Time) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE users SET first_name = '', last_name = '', email = ?,
+			phone = '', password = '', token = '', refresh_token = '', token_version = token_version + 1,
+			role = ?, email_verified = ?, password_reset_hash = '', two_factor_enabled = ?, totp_secret = '',
+			disabled = ?, erasure_scheduled_at = NULL, erased_at = ?, updated_at = ? WHERE id = ?`),
+			ErasedEmail(userID), models.RoleCustomer, false, false, true, at.UTC(), at.UTC(), userID)
+		if err != nil {
+			log.Println(err)
+			return ErrCantEraseUser
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrUserNotFound
+		}
+		for _, table := range []string{"addresses", "cart_items", "recovery_codes", "user_identities"} {
+			if _, err = tx.ExecContext(ctx, s.db.rebind("DELETE FROM "+table+" WHERE user_id = ?"), userID); err != nil {
+				log.Println(err)
+				return ErrCantEraseUser
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLUserStore) UpdatePasswordHash(ctx context.Context, userID, hashedPassword string) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("UPDATE users SET password = ? WHERE id = ?"), hashedPassword, userID)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePassword
+	}
+	return nil
+}
+
+func (s *SQLUserStore) SetPasswordReset(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind(`UPDATE users SET password_reset_hash = ?, password_reset_expires_at = ?
+		WHERE id = ?`), tokenHash, expiresAt.UTC(), userID)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePassword
+	}
+	return nil
+}
+
+func (s *SQLUserStore) ResetPassword(ctx context.Context, tokenHash, hashedPassword string, now time.Time) error {
+	if tokenHash == "" {
+		return ErrResetTokenInvalid
+	}
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		// Expiry is compared in Go: SQLite keeps timestamps as text.
+		var id string
+		var expiresAt sql.NullTime
+		err := tx.QueryRowContext(ctx, s.db.rebind(`SELECT id, password_reset_expires_at FROM users
+			WHERE password_reset_hash = ?`), tokenHash).Scan(&id, &expiresAt)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && (!expiresAt.Valid || !expiresAt.Time.After(now))) {
+			return ErrResetTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE users SET password = ?, password_reset_hash = '',
+			token = '', refresh_token = '', token_version = token_version + 1, updated_at = ?
+			WHERE id = ? AND password_reset_hash = ?`),
+			hashedPassword, now.UTC(), id, tokenHash)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdatePassword
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return ErrResetTokenInvalid
+		}
+		return nil
+	})
+}
+
+func (s *SQLUserStore) AddAddress(ctx context.Context, userID string, address models.Address) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.db.userExists(ctx, tx, userID); err != nil {
+			return err
+		}
+		var count int
+		err := tx.QueryRowContext(ctx, s.db.rebind("SELECT COUNT(*) FROM addresses WHERE user_id = ?"), userID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count >= MaxAddresses {
+			return ErrAddressLimit
+		}
+		// UNIQUE (user_id, position) rejects a concurrent insert at the same slot.
+		_, err = tx.ExecContext(ctx, s.db.rebind(`INSERT INTO addresses
+			(id, user_id, position, house, street, city, pin_code) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+			address.AddressID.Hex(), userID, count, address.House, address.Street, address.City, address.PinCode)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateAddress
+		}
+		return nil
+	})
+}
+
+func (s *SQLUserStore) UpdateAddress(ctx context.Context, userID string, index int, address models.Address) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind(`UPDATE addresses SET house = ?, street = ?, city = ?, pin_code = ?
+		WHERE user_id = ? AND position = ?`),
+		address.House, address.Street, address.City, address.PinCode, userID, index)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	return nil
+}
+
+func (s *SQLUserStore) DeleteAddresses(ctx context.Context, userID string) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("DELETE FROM addresses WHERE user_id = ?"), userID)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	return nil
+}
+
+type SQLProductStore struct {
+	db *SQLDB
+}
+
+func NewSQLProductStore(db *SQLDB) *SQLProductStore {
+	return &SQLProductStore{db: db}
+}
+
+const productColumns = "id, product_name, price, rating, image, version, archived, description"
+
+func (s *SQLProductStore) Create(ctx context.Context, product *models.Product) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.checkSKUs(ctx, tx, *product); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.db.rebind("INSERT INTO products ("+productColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+			product.ProductID.Hex(), product.ProductName, product.Price, product.Rating, product.Image,
+			product.Version, product.Archived, product.Description)
+		if err != nil {
+			log.Println(err)
+			return ErrCantCreateProduct
+		}
+		if err = s.replaceCategories(ctx, tx, *product); err != nil {
+			log.Println(err)
+			return ErrCantCreateProduct
+		}
+		return s.replaceVariants(ctx, tx, *product)
+	})
+}
+
+func (s *SQLProductStore) FindAll(ctx context.Context) ([]models.Product, error) {
+	return s.query(ctx, s.db, "SELECT "+productColumns+" FROM products WHERE archived = ? ORDER BY id", false)
+}
+
+func (s *SQLProductStore) FindByID(ctx context.Context, productID primitive.ObjectID) (models.Product, error) {
+	return s.findByID(ctx, s.db, productID)
+}
+
+func (s *SQLProductStore) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Product, error) {
+	if len(ids) == 0 {
+		return make([]models.Product, 0), nil
+	}
+	args := []interface{}{false}
+	for _, id := range ids {
+		args = append(args, id.Hex())
+	}
+	return s.query(ctx, s.db, "SELECT "+productColumns+" FROM products WHERE archived = ? AND id IN ("+
+		placeholders(len(ids))+")", args...)
+}
+
+func (s *SQLProductStore) findByID(ctx context.Context, q querier, productID primitive.ObjectID) (models.Product, error) {
+	products, err := s.query(ctx, q, "SELECT "+productColumns+" FROM products WHERE id = ?", productID.Hex())
+	if err != nil {
+		return models.Product{}, err
+	}
+	if len(products) == 0 {
+		return models.Product{}, ErrCantFindProduct
+	}
+	return products[0], nil
+}
+
+func (s *SQLProductStore) List(ctx context.Context, filter ProductFilter) ([]models.Product, int64, error) {
+	where := []string{"archived = ?"}
+	args := []interface{}{false}
+	if filter.CategoryIDs != nil {
+		if len(filter.CategoryIDs) == 0 {
+			return make([]models.Product, 0), 0, nil
+		}
+		ids := make([]interface{}, 0, len(filter.CategoryIDs))
+		for _, id := range filter.CategoryIDs {
+			ids = append(ids, id.Hex())
+		}
+		where = append(where, "id IN (SELECT product_id FROM product_categories WHERE category_id IN ("+
+			placeholders(len(ids))+"))")
+		args = append(args, ids...)
+	}
+	if filter.MinPrice > 0 {
+		where = append(where, "price >= ?")
+		args = append(args, filter.MinPrice)
+	}
+	if filter.MaxPrice > 0 {
+		where = append(where, "price <= ?")
+		args = append(args, filter.MaxPrice)
+	}
+	if filter.MinRating > 0 {
+		where = append(where, "rating >= ?")
+		args = append(args, filter.MinRating)
+	}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT COUNT(*) FROM products WHERE "+strings.Join(where, " AND ")),
+		args...).Scan(&total)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+
+	field, desc := productSort(filter.Sort)
+	op, dir := ">", "ASC"
+	if desc {
+		op, dir = "<", "DESC"
+	}
+	order := "id " + dir
+	if field != "" {
+		order = field + " " + dir + ", " + order
+	}
+	if filter.After != nil {
+		if field == "" {
+			where = append(where, "id "+op+" ?")
+			args = append(args, filter.After.ProductID.Hex())
+		} else {
+			value := sortValue(*filter.After, field)
+			where = append(where, "("+field+" "+op+" ? OR "+field+" = ? AND id "+op+" ?)")
+			args = append(args, value, value, filter.After.ProductID.Hex())
+		}
+	}
+	query := "SELECT " + productColumns + " FROM products WHERE " + strings.Join(where, " AND ") + " ORDER BY " + order
+	if filter.Limit > 0 {
+		offset := filter.Offset
+		if filter.After != nil {
+			offset = 0
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, offset)
+	}
+	products, err := s.query(ctx, s.db, query, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+	return products, total, nil
+}
+
+func (s *SQLProductStore) Update(ctx context.Context, product models.Product, version int) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.checkSKUs(ctx, tx, product); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE products SET product_name = ?, price = ?, rating = ?,
+			image = ?, archived = ?, description = ?, version = ? WHERE id = ? AND version = ?`),
+			product.ProductName, product.Price, product.Rating, product.Image, product.Archived, product.Description, version+1,
+			product.ProductID.Hex(), version)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateProduct
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			if _, err = s.findByID(ctx, tx, product.ProductID); err != nil {
+				return err
+			}
+			return ErrProductConflict
+		}
+		if err = s.replaceCategories(ctx, tx, product); err != nil {
+			log.Println(err)
+			return ErrCantUpdateProduct
+		}
+		return s.replaceVariants(ctx, tx, product)
+	})
+}
+
+// replaceCategories makes product.CategoryIDs the categories of the product.
+func (s *SQLProductStore) replaceCategories(ctx context.Context, tx *sql.Tx, product models.Product) error {
+	if _, err := tx.ExecContext(ctx, s.db.rebind("DELETE FROM product_categories WHERE product_id = ?"),
+		product.ProductID.Hex()); err != nil {
+		return err
+	}
+	for _, categoryID := range product.CategoryIDs {
+		if _, err := tx.ExecContext(ctx, s.db.rebind("INSERT INTO product_categories (product_id, category_id) VALUES (?, ?)"),
+			product.ProductID.Hex(), categoryID.Hex()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceVariants makes product.Variants the variants of the product.
+// Variants that are kept are updated in place, so carts holding them keep
+// them; carts lose the variants that are dropped.
+func (s *SQLProductStore) replaceVariants(ctx context.Context, tx *sql.Tx, product models.Product) error {
+	keep := []interface{}{product.ProductID.Hex()}
+	for _, variant := range product.Variants {
+		keep = append(keep, variant.VariantID.Hex())
+	}
+	query := "DELETE FROM product_variants WHERE product_id = ?"
+	if len(product.Variants) > 0 {
+		query += " AND id NOT IN (" + placeholders(len(product.Variants)) + ")"
+	}
+	if _, err := tx.ExecContext(ctx, s.db.rebind(query), keep...); err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	for i, variant := range product.Variants {
+		attributes, err := json.Marshal(variant.Attributes)
+		if err != nil {
+			return err
+		}
+		var price interface{}
+		if variant.Price != nil {
+			price = *variant.Price
+		}
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE product_variants SET position = ?, sku = ?,
+			attributes = ?, price = ?, image = ? WHERE id = ? AND product_id = ?`),
+			i, variant.SKU, string(attributes), price, variant.Image,
+			variant.VariantID.Hex(), product.ProductID.Hex())
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateProduct
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, s.db.rebind(`INSERT INTO product_variants
+			(id, product_id, position, sku, attributes, price, image) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+			variant.VariantID.Hex(), product.ProductID.Hex(), i, variant.SKU, string(attributes), price,
+			variant.Image)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateProduct
+		}
+	}
+	return nil
+}
+
+// checkSKUs fails with ErrSKUTaken when another product has a variant with
+// one of the SKUs of product.
+func (s *SQLProductStore) checkSKUs(ctx context.Context, tx *sql.Tx, product models.Product) error {
+	if len(product.Variants) == 0 {
+		return nil
+	}
+	args := []interface{}{product.ProductID.Hex()}
+	for _, variant := range product.Variants {
+		args = append(args, variant.SKU)
+	}
+	var count int
+	err := tx.QueryRowContext(ctx, s.db.rebind(`SELECT COUNT(*) FROM product_variants
+		WHERE product_id <> ? AND sku IN (`+placeholders(len(product.Variants))+`)`), args...).Scan(&count)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	if count > 0 {
+		return ErrSKUTaken
+	}
+	return nil
+}
+
+// available finds a product that can be bought: it exists and is not
+// archived.
+func (s *SQLProductStore) available(ctx context.Context, productID primitive.ObjectID) (models.Product, error) {
+	product, err := s.FindByID(ctx, productID)
+	if err == nil && product.Archived {
+		return product, ErrCantFindProduct
+	}
+	return product, err
+}
+
+// query reads the products selected by query, with their categories and
+// variants.
+func (s *SQLProductStore) query(ctx context.Context, q querier, query string, args ...interface{}) ([]models.Product, error) {
+	rows, err := q.QueryContext(ctx, s.db.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := make([]models.Product, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		var product models.Product
+		var id string
+		err = rows.Scan(&id, &product.ProductName, &product.Price, &product.Rating, &product.Image,
+			&product.Version, &product.Archived, &product.Description)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantDecodeProducts
+		}
+		product.ProductID, _ = primitive.ObjectIDFromHex(id)
+		product.CategoryIDs = make([]primitive.ObjectID, 0)
+		product.Variants = make([]models.Variant, 0)
+		index[id] = len(products)
+		products = append(products, product)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(products) == 0 {
+		return products, nil
+	}
+
+	ids := make([]interface{}, 0, len(products))
+	for _, product := range products {
+		ids = append(ids, product.ProductID.Hex())
+	}
+	rows, err = q.QueryContext(ctx, s.db.rebind(`SELECT product_id, category_id FROM product_categories
+		WHERE product_id IN (`+placeholders(len(ids))+`) ORDER BY category_id`), ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID, categoryID string
+		if err = rows.Scan(&productID, &categoryID); err != nil {
+			log.Println(err)
+			return nil, ErrCantDecodeProducts
+		}
+		id, _ := primitive.ObjectIDFromHex(categoryID)
+		product := &products[index[productID]]
+		product.CategoryIDs = append(product.CategoryIDs, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = q.QueryContext(ctx, s.db.rebind(`SELECT product_id, id, sku, attributes, price, image
+		FROM product_variants WHERE product_id IN (`+placeholders(len(ids))+`) ORDER BY position`), ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID, variantID, attributes string
+		var variant models.Variant
+		var price sql.NullInt64
+		err = rows.Scan(&productID, &variantID, &variant.SKU, &attributes, &price, &variant.Image)
+		if err == nil {
+			err = json.Unmarshal([]byte(attributes), &variant.Attributes)
+		}
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantDecodeProducts
+		}
+		variant.VariantID, _ = primitive.ObjectIDFromHex(variantID)
+		if price.Valid {
+			value := uint64(price.Int64)
+			variant.Price = &value
+		}
+		product := &products[index[productID]]
+		product.Variants = append(product.Variants, variant)
+	}
+	return products, rows.Err()
+}
+
+// placeholders returns n comma-separated ? placeholders for an IN list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+type SQLCategoryStore struct {
+	db *SQLDB
+}
+
+func NewSQLCategoryStore(db *SQLDB) *SQLCategoryStore {
+	return &SQLCategoryStore{db: db}
+}
+
+const categoryColumns = "id, name, slug, parent_id, sort_order"
+
+func (s *SQLCategoryStore) Create(ctx context.Context, category *models.Category) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.checkSlug(ctx, tx, *category); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.db.rebind("INSERT INTO categories ("+categoryColumns+") VALUES (?, ?, ?, ?, ?)"),
+			category.CategoryID.Hex(), category.Name, category.Slug, parentColumn(category.ParentID), category.SortOrder)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateCategory
+		}
+		return nil
+	})
+}
+
+func (s *SQLCategoryStore) FindAll(ctx context.Context) ([]models.Category, error) {
+	return s.query(ctx, "SELECT "+categoryColumns+" FROM categories ORDER BY sort_order, name")
+}
+
+func (s *SQLCategoryStore) FindBySlug(ctx context.Context, slug string) (models.Category, error) {
+	categories, err := s.query(ctx, "SELECT "+categoryColumns+" FROM categories WHERE slug = ?", slug)
+	if err != nil {
+		return models.Category{}, err
+	}
+	if len(categories) == 0 {
+		return models.Category{}, ErrCantFindCategory
+	}
+	return categories[0], nil
+}
+
+func (s *SQLCategoryStore) Update(ctx context.Context, category models.Category) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.checkSlug(ctx, tx, category); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE categories SET name = ?, slug = ?, parent_id = ?,
+			sort_order = ? WHERE id = ?`),
+			category.Name, category.Slug, parentColumn(category.ParentID), category.SortOrder, category.CategoryID.Hex())
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateCategory
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrCantFindCategory
+		}
+		return nil
+	})
+}
+
+// Delete relies on ON DELETE CASCADE to take the products out of the
+// category.
+func (s *SQLCategoryStore) Delete(ctx context.Context, categoryID primitive.ObjectID) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		var children int
+		err := tx.QueryRowContext(ctx, s.db.rebind("SELECT COUNT(*) FROM categories WHERE parent_id = ?"),
+			categoryID.Hex()).Scan(&children)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateCategory
+		}
+		if children > 0 {
+			return ErrCategoryHasChildren
+		}
+		result, err := tx.ExecContext(ctx, s.db.rebind("DELETE FROM categories WHERE id = ?"), categoryID.Hex())
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateCategory
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrCantFindCategory
+		}
+		return nil
+	})
+}
+
+// checkSlug fails with ErrSlugTaken when another category has the slug of
+// category.
+func (s *SQLCategoryStore) checkSlug(ctx context.Context, tx *sql.Tx, category models.Category) error {
+	var owner string
+	err := tx.QueryRowContext(ctx, s.db.rebind("SELECT id FROM categories WHERE slug = ?"), category.Slug).Scan(&owner)
+	if err == nil && owner != category.CategoryID.Hex() {
+		return ErrSlugTaken
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	return nil
+}
+
+func (s *SQLCategoryStore) query(ctx context.Context, query string, args ...interface{}) ([]models.Category, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make([]models.Category, 0)
+	for rows.Next() {
+		var category models.Category
+		var id string
+		var parentID sql.NullString
+		if err = rows.Scan(&id, &category.Name, &category.Slug, &parentID, &category.SortOrder); err != nil {
+			return nil, err
+		}
+		category.CategoryID, _ = primitive.ObjectIDFromHex(id)
+		if parentID.Valid {
+			parent, _ := primitive.ObjectIDFromHex(parentID.String)
+			category.ParentID = &parent
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// parentColumn is the parent_id value of a category: NULL at the top level.
+func parentColumn(parentID *primitive.ObjectID) interface{} {
+	if parentID == nil {
+		return nil
+	}
+	return parentID.Hex()
+}
+
+type SQLOrderStore struct {
+	db *SQLDB
+}
+
+func NewSQLOrderStore(db *SQLDB) *SQLOrderStore {
+	return &SQLOrderStore{db: db}
+}
+
+func (s *SQLOrderStore) AddProductToCart(ctx context.Context, productID, variantID primitive.ObjectID, userID string) error {
+	if err := s.db.userExists(ctx, s.db, userID); err != nil {
+		return err
+	}
+	product, err := NewSQLProductStore(s.db).available(ctx, productID)
+	if err != nil {
+		return err
+	}
+	item, err := cartItem(product, variantID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.db.rebind(`INSERT INTO cart_items (id, user_id, product_id, variant_id, added_at)
+		VALUES (?, ?, ?, ?, ?)`),
+		primitive.NewObjectID().Hex(), userID, productID.Hex(), variantColumn(item.VariantID), time.Now().UTC())
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+func (s *SQLOrderStore) RemoveCartItem(ctx context.Context, productID, variantID primitive.ObjectID, userID string) error {
+	if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+		return ErrUserIdIsNotValid
+	}
+	query, args := "DELETE FROM cart_items WHERE user_id = ? AND product_id = ?", []interface{}{userID, productID.Hex()}
+	if !variantID.IsZero() {
+		query, args = query+" AND variant_id = ?", append(args, variantID.Hex())
+	}
+	_, err := s.db.ExecContext(ctx, s.db.rebind(query), args...)
+	if err != nil {
+		log.Println(err)
+		return ErrCantRemoteItemCart
+	}
+	return nil
+}
+
+func (s *SQLOrderStore) GetCart(ctx context.Context, userID string) ([]models.ProductUser, uint64, error) {
+	if err := s.db.userExists(ctx, s.db, userID); err != nil {
+		return nil, 0, err
+	}
+	cart, err := s.db.loadCart(ctx, s.db, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cart, cartTotal(cart), nil
+}
+
+func (s *SQLOrderStore) BuyItemFromCart(ctx context.Context, userID string, checkout Checkout) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.db.userExists(ctx, tx, userID); err != nil {
+			return err
+		}
+		cart, err := s.db.loadCart(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if err = s.checkAvailable(ctx, tx, userID, cart); err != nil {
+			return err
+		}
+		if err = s.placeOrder(ctx, tx, userID, checkout.PinCode, newOrder(cart, checkout)); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, s.db.rebind("DELETE FROM cart_items WHERE user_id = ?"), userID); err != nil {
+			log.Println(err)
+			return ErrCantBuyCartItem
+		}
+		return nil
+	})
+}
+
+// checkAvailable fails with ErrProductUnavailable when the user's cart holds
+// products archived since they were added. The cart keeps showing them so
+// they can be removed.
+func (s *SQLOrderStore) checkAvailable(ctx context.Context, tx *sql.Tx, userID string, cart []models.ProductUser) error {
+	rows, err := tx.QueryContext(ctx, s.db.rebind(`SELECT DISTINCT p.id FROM cart_items c
+		JOIN products p ON p.id = c.product_id WHERE c.user_id = ? AND p.archived = ?`), userID, false)
+	if err != nil {
+		log.Println(err)
+		return ErrCantFindProduct
+	}
+	defer rows.Close()
+
+	available := make(map[primitive.ObjectID]bool, len(cart))
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			log.Println(err)
+			return ErrCantFindProduct
+		}
+		productID, _ := primitive.ObjectIDFromHex(id)
+		available[productID] = true
+	}
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return ErrCantFindProduct
+	}
+	return checkAvailable(cart, available)
+}
+
+func (s *SQLOrderStore) InstantBuyer(ctx context.Context, productID, variantID primitive.ObjectID, userID string, checkout Checkout) error {
+	product, err := NewSQLProductStore(s.db).available(ctx, productID)
+	if err != nil {
+		return err
+	}
+	item, err := cartItem(product, variantID)
+	if err != nil {
+		return err
+	}
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.db.userExists(ctx, tx, userID); err != nil {
+			return err
+		}
+		return s.placeOrder(ctx, tx, userID, checkout.PinCode, newOrder([]models.ProductUser{item}, checkout))
+	})
+}
+
+// placeOrder allocates order to warehouses, reserves its stock there and
+// writes it inside tx.
+func (s *SQLOrderStore) placeOrder(ctx context.Context, tx *sql.Tx, userID, pinCode string, order models.Order) error {
+	warehouses, err := NewSQLWarehouseStore(s.db).query(ctx, tx)
+	if err != nil {
+		return err
+	}
+	stock, err := NewSQLInventoryStore(s.db).levels(ctx, tx, lineProducts(stockLines(order.OrderCart)))
+	if err != nil {
+		return err
+	}
+	if err = allocate(&order, warehouses, stock, pinCode); err != nil {
+		return err
+	}
+	for _, allocation := range order.Allocations {
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE inventory SET reserved = reserved + ?
+			WHERE warehouse_id = ? AND product_id = ? AND variant_id = ? AND on_hand - reserved >= ?`),
+			allocation.Quantity, allocation.WarehouseID.Hex(), allocation.ProductID.Hex(),
+			stockVariant(allocation.VariantID), allocation.Quantity)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateStock
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrInsufficientStock
+		}
+	}
+	if err := s.db.insertOrder(ctx, tx, userID, order); err != nil {
+		log.Println(err)
+		return ErrCantBuyCartItem
+	}
+	return nil
+}
+
+func (s *SQLOrderStore) FindOrder(ctx context.Context, orderID primitive.ObjectID) (models.Order, string, error) {
+	return s.findOrder(ctx, s.db, orderID)
+}
+
+func (s *SQLOrderStore) findOrder(ctx context.Context, q querier, orderID primitive.ObjectID) (models.Order, string, error) {
+	var userID string
+	err := q.QueryRowContext(ctx, s.db.rebind("SELECT user_id FROM orders WHERE id = ?"), orderID.Hex()).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Order{}, "", ErrCantFindOrder
+	}
+	if err != nil {
+		return models.Order{}, "", err
+	}
+	orders, err := s.db.queryOrders(ctx, q, "id = ?", orderID.Hex())
+	if err != nil {
+		return models.Order{}, "", err
+	}
+	if len(orders) == 0 {
+		return models.Order{}, "", ErrCantFindOrder
+	}
+	return orders[0], userID, nil
+}
+
+func (s *SQLOrderStore) UpdateOrderStatus(ctx context.Context, orderID primitive.ObjectID, from, to string) error {
+	return s.db.withTx(ctx, func(tx *sql.Tx) error {
+		order, _, err := s.findOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, s.db.rebind("UPDATE orders SET status = ? WHERE id = ? AND status = ?"),
+			to, orderID.Hex(), from)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrOrderStatusChanged
+		}
+		onHand, reserved := stockEffect(to)
+		if onHand == 0 && reserved == 0 {
+			return nil
+		}
+		for _, allocation := range order.Allocations {
+			_, err = tx.ExecContext(ctx, s.db.rebind(`UPDATE inventory SET on_hand = on_hand + ?,
+				reserved = reserved + ? WHERE warehouse_id = ? AND product_id = ? AND variant_id = ?`),
+				onHand*allocation.Quantity, reserved*allocation.Quantity, allocation.WarehouseID.Hex(),
+				allocation.ProductID.Hex(), stockVariant(allocation.VariantID))
+			if err != nil {
+				log.Println(err)
+				return ErrCantUpdateStock
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLOrderStore) UnpaidOrders(ctx context.Context, now time.Time) ([]primitive.ObjectID, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.rebind(`SELECT id, payment_due FROM orders
+		WHERE status = ? AND payment_due IS NOT NULL ORDER BY id`), models.OrderPendingPayment)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]primitive.ObjectID, 0)
+	for rows.Next() {
+		var id string
+		var due time.Time
+		if err = rows.Scan(&id, &due); err != nil {
+			return nil, err
+		}
+		// Compared in Go: SQLite keeps timestamps as text.
+		if !due.After(now) {
+			orderID, _ := primitive.ObjectIDFromHex(id)
+			ids = append(ids, orderID)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// stockVariant is the variant_id value of an inventory or allocation row:
+// empty for a product without variants.
+func stockVariant(variantID *primitive.ObjectID) string {
+	if variantID == nil {
+		return ""
+	}
+	return variantID.Hex()
+}
+
+type SQLInventoryStore struct {
+	db *SQLDB
+}
+
+func NewSQLInventoryStore(db *SQLDB) *SQLInventoryStore {
+	return &SQLInventoryStore{db: db}
+}
+
+func (s *SQLInventoryStore) Levels(ctx context.Context, productID primitive.ObjectID) ([]models.StockLevel, error) {
+	return s.levels(ctx, s.db, []primitive.ObjectID{productID})
+}
+
+func (s *SQLInventoryStore) levels(ctx context.Context, q querier, productIDs []primitive.ObjectID) ([]models.StockLevel, error) {
+	levels := make([]models.StockLevel, 0)
+	if len(productIDs) == 0 {
+		return levels, nil
+	}
+	ids := make([]interface{}, 0, len(productIDs))
+	for _, id := range productIDs {
+		ids = append(ids, id.Hex())
+	}
+	rows, err := q.QueryContext(ctx, s.db.rebind(`SELECT warehouse_id, product_id, variant_id, on_hand, reserved
+		FROM inventory WHERE product_id IN (`+placeholders(len(ids))+`) ORDER BY warehouse_id, product_id, variant_id`), ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var level models.StockLevel
+		var warehouseID, productID, variantID string
+		if err = rows.Scan(&warehouseID, &productID, &variantID, &level.OnHand, &level.Reserved); err != nil {
+			return nil, err
+		}
+		level.WarehouseID, _ = primitive.ObjectIDFromHex(warehouseID)
+		level.ProductID, _ = primitive.ObjectIDFromHex(productID)
+		level.VariantID = variantRef(sql.NullString{String: variantID, Valid: variantID != ""})
+		level.Available = level.OnHand - level.Reserved
+		levels = append(levels, level)
+	}
+	return levels, rows.Err()
+}
+
+func (s *SQLInventoryStore) Adjust(ctx context.Context, adjustment *models.StockAdjustment) (models.StockLevel, error) {
+	level := models.StockLevel{WarehouseID: adjustment.WarehouseID, ProductID: adjustment.ProductID,
+		VariantID: adjustment.VariantID}
+	key := []interface{}{adjustment.WarehouseID.Hex(), adjustment.ProductID.Hex(), stockVariant(adjustment.VariantID)}
+	err := s.db.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, s.db.rebind(`UPDATE inventory SET on_hand = on_hand + ?
+			WHERE warehouse_id = ? AND product_id = ? AND variant_id = ? AND on_hand + ? >= reserved`),
+			append(append([]interface{}{adjustment.Delta}, key...), adjustment.Delta)...)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateStock
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			// Either the item has no stock in the warehouse yet, or too much
+			// is reserved.
+			var one int
+			err = tx.QueryRowContext(ctx, s.db.rebind(`SELECT 1 FROM inventory
+				WHERE warehouse_id = ? AND product_id = ? AND variant_id = ?`), key...).Scan(&one)
+			if err == nil || adjustment.Delta < 0 {
+				return ErrInsufficientStock
+			}
+			_, err = tx.ExecContext(ctx, s.db.rebind(`INSERT INTO inventory (warehouse_id, product_id, variant_id, on_hand)
+				VALUES (?, ?, ?, ?)`), append(key, adjustment.Delta)...)
+			if err != nil {
+				log.Println(err)
+				return ErrCantUpdateStock
+			}
+		}
+		err = tx.QueryRowContext(ctx, s.db.rebind(`SELECT on_hand, reserved FROM inventory
+			WHERE warehouse_id = ? AND product_id = ? AND variant_id = ?`), key...).Scan(&level.OnHand, &level.Reserved)
+		if err != nil {
+			return err
+		}
+
+		adjustment.OnHand = level.OnHand
+		_, err = tx.ExecContext(ctx, s.db.rebind(`INSERT INTO stock_adjustments
+			(id, warehouse_id, product_id, variant_id, delta, reason, note, on_hand, actor_id, at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			adjustment.ID.Hex(), key[0], key[1], key[2], adjustment.Delta, adjustment.Reason, adjustment.Note,
+			adjustment.OnHand, adjustment.ActorID, adjustment.At.UTC())
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateStock
+		}
+		return nil
+	})
+	level.Available = level.OnHand - level.Reserved
+	return level, err
+}
+
+func (s *SQLInventoryStore) Adjustments(ctx context.Context, productID primitive.ObjectID) ([]models.StockAdjustment, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.rebind(`SELECT id, warehouse_id, variant_id, delta, reason, note, on_hand,
+		actor_id, at FROM stock_adjustments WHERE product_id = ? ORDER BY at DESC, id DESC`), productID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := make([]models.StockAdjustment, 0)
+	for rows.Next() {
+		adjustment := models.StockAdjustment{ProductID: productID}
+		var id, warehouseID, variantID string
+		err = rows.Scan(&id, &warehouseID, &variantID, &adjustment.Delta, &adjustment.Reason, &adjustment.Note,
+			&adjustment.OnHand, &adjustment.ActorID, &adjustment.At)
+		if err != nil {
+			return nil, err
+		}
+		adjustment.ID, _ = primitive.ObjectIDFromHex(id)
+		adjustment.WarehouseID, _ = primitive.ObjectIDFromHex(warehouseID)
+		adjustment.VariantID = variantRef(sql.NullString{String: variantID, Valid: variantID != ""})
+		adjustments = append(adjustments, adjustment)
+	}
+	return adjustments, rows.Err()
+}
+
+type SQLWarehouseStore struct {
+	db *SQLDB
+}
+
+func NewSQLWarehouseStore(db *SQLDB) *SQLWarehouseStore {
+	return &SQLWarehouseStore{db: db}
+}
+
+func (s *SQLWarehouseStore) Create(ctx context.Context, warehouse *models.Warehouse) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("INSERT INTO warehouses (id, name, pin_code, active) VALUES (?, ?, ?, ?)"),
+		warehouse.WarehouseID.Hex(), warehouse.Name, warehouse.PinCode, warehouse.Active)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateWarehouse
+	}
+	return nil
+}
+
+func (s *SQLWarehouseStore) FindAll(ctx context.Context) ([]models.Warehouse, error) {
+	return s.query(ctx, s.db)
+}
+
+func (s *SQLWarehouseStore) query(ctx context.Context, q querier) ([]models.Warehouse, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, name, pin_code, active FROM warehouses ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warehouses := make([]models.Warehouse, 0)
+	for rows.Next() {
+		var warehouse models.Warehouse
+		var id string
+		if err = rows.Scan(&id, &warehouse.Name, &warehouse.PinCode, &warehouse.Active); err != nil {
+			return nil, err
+		}
+		warehouse.WarehouseID, _ = primitive.ObjectIDFromHex(id)
+		warehouses = append(warehouses, warehouse)
+	}
+	return warehouses, rows.Err()
+}
+
+func (s *SQLWarehouseStore) Update(ctx context.Context, warehouse models.Warehouse) error {
+	result, err := s.db.ExecContext(ctx, s.db.rebind("UPDATE warehouses SET name = ?, pin_code = ?, active = ? WHERE id = ?"),
+		warehouse.Name, warehouse.PinCode, warehouse.Active, warehouse.WarehouseID.Hex())
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateWarehouse
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrCantFindWarehouse
+	}
+	return nil
+}
+
+type SQLAuditStore struct {
+	db *SQLDB
+}
+
+func NewSQLAuditStore(db *SQLDB) *SQLAuditStore {
+	return &SQLAuditStore{db: db}
+}
+
+func (s *SQLAuditStore) Record(ctx context.Context, record models.AuditRecord) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind(`INSERT INTO audit_log
+		(id, actor_id, target_user_id, action, detail, at) VALUES (?, ?, ?, ?, ?, ?)`),
+		record.ID.Hex(), record.ActorID, record.TargetUserID, record.Action, record.Detail, record.At.UTC())
+	if err != nil {
+		log.Println(err)
+		return ErrCantRecordAudit
+	}
+	return nil
+}
+
+type SQLLoginAttemptStore struct {
+	db *SQLDB
+}
+
+func NewSQLLoginAttemptStore(db *SQLDB) *SQLLoginAttemptStore {
+	return &SQLLoginAttemptStore{db: db}
+}
+
+func (s *SQLLoginAttemptStore) Get(ctx context.Context, key string) (models.LoginAttempts, error) {
+	return s.get(ctx, s.db, key)
+}
+
+func (s *SQLLoginAttemptStore) get(ctx context.Context, q querier, key string) (models.LoginAttempts, error) {
+	attempts := models.LoginAttempts{Key: key}
+	var lastFailureAt, lockedUntil sql.NullTime
+	err := q.QueryRowContext(ctx, s.db.rebind(`SELECT failures, last_failure_at, locked_until FROM login_attempts
+		WHERE attempt_key = ?`), key).Scan(&attempts.Failures, &lastFailureAt, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return attempts, nil
+	}
+	if err != nil {
+		return attempts, err
+	}
+	attempts.LastFailureAt = lastFailureAt.Time
+	attempts.LockedUntil = lockedUntil.Time
+	return attempts, nil
+}
+
+func (s *SQLLoginAttemptStore) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (models.LoginAttempts, error) {
+	var attempts models.LoginAttempts
+	err := s.db.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		// The window is applied in Go: SQLite keeps timestamps as text.
+		if attempts, err = s.get(ctx, tx, key); err != nil {
+			return err
+		}
+		if attempts.LastFailureAt.After(at.Add(-window)) {
+			attempts.Failures++
+		} else {
+			attempts.Failures = 1
+		}
+		attempts.LastFailureAt = at
+		_, err = tx.ExecContext(ctx, s.db.rebind(`INSERT INTO login_attempts (attempt_key, failures, last_failure_at)
+			VALUES (?, ?, ?) ON CONFLICT (attempt_key) DO UPDATE
+			SET failures = excluded.failures, last_failure_at = excluded.last_failure_at`),
+			key, attempts.Failures, at.UTC())
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		return attempts, ErrCantCountAttempts
+	}
+	return attempts, nil
+}
+
+func (s *SQLLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind(`INSERT INTO login_attempts (attempt_key, locked_until) VALUES (?, ?)
+		ON CONFLICT (attempt_key) DO UPDATE SET locked_until = excluded.locked_until`), key, until.UTC())
+	if err != nil {
+		log.Println(err)
+		return ErrCantCountAttempts
+	}
+	return nil
+}
+
+func (s *SQLLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("DELETE FROM login_attempts WHERE attempt_key = ?"), key)
+	if err != nil {
+		log.Println(err)
+		return ErrCantCountAttempts
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+var (
+	ErrUserNotFound        = errors.New("user not found")
+	ErrCantCreateUser      = errors.New("unable to create user")
+	ErrCantUpdateTokens    = errors.New("cannot update the user tokens")
+	ErrRefreshTokenStale   = errors.New("refresh token is not the current one")
+	ErrRefreshFamilyEnded  = errors.New("refresh token family has ended")
+	ErrCantUpdateRole      = errors.New("cannot update the user role")
+	ErrCantDisableUser     = errors.New("cannot update whether the user is disabled")
+	ErrCantListUsers       = errors.New("cannot list the users")
+	ErrCantListProducts    = errors.New("cannot list the products")
+	ErrCantVerifyEmail     = errors.New("cannot mark the email as verified")
+	ErrCantUpdateProfile   = errors.New("cannot update the profile")
+	ErrCantEraseUser       = errors.New("cannot erase the user")
+	ErrResetTokenInvalid   = errors.New("password reset token is invalid or expired")
+	ErrCantUpdatePassword  = errors.New("cannot update the password")
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTOTPCodeUsed        = errors.New("this two-factor code has already been used")
+	ErrRecoveryCodeUsed    = errors.New("this recovery code is invalid or has been used")
+	ErrCantUpdateTwoFactor = errors.New("cannot update the two-factor settings")
+	ErrIdentityLinked      = errors.New("this login is already linked to an account")
+	ErrCantLinkIdentity    = errors.New("cannot link the login to the account")
+	ErrAddressLimit        = errors.New("address limit reached")
+	ErrCantUpdateAddress   = errors.New("cannot update the address")
+	ErrCantCreateProduct   = errors.New("cannot add this product")
+	ErrCantUpdateProduct   = errors.New("cannot update this product")
+	ErrProductConflict     = errors.New("the product was changed by someone else, reload it and try again")
+	ErrSKUTaken            = errors.New("another product already uses this SKU")
+	ErrCantFindVariant     = errors.New("can't find the product variant")
+	ErrVariantRequired     = errors.New("choose a variant of this product")
+	ErrInsufficientStock   = errors.New("not enough stock")
+	ErrProductUnavailable  = errors.New("products in the cart are no longer sold")
+	ErrCantUpdateStock     = errors.New("cannot update the stock")
+	ErrCantFindOrder       = errors.New("can't find the order")
+	ErrCantFindWarehouse   = errors.New("can't find the warehouse")
+	ErrCantUpdateWarehouse = errors.New("cannot update the warehouse")
+	ErrOrderStatusChanged  = errors.New("the order status has changed, reload it and try again")
+	ErrCantFindCategory    = errors.New("can't find the category")
+	ErrCantUpdateCategory  = errors.New("cannot update the category")
+	ErrSlugTaken           = errors.New("another category already uses this slug")
+	ErrCategoryHasChildren = errors.New("the category still has subcategories")
+	ErrCantRecordAudit     = errors.New("cannot write the audit record")
+	ErrCantCountAttempts   = errors.New("cannot update the login attempts")
+)
+
+// ErasedEmail is the placeholder address of an erased user. It is unique
+// per account and frees the original address for a new signup.
+func ErasedEmail(userID string) string {
+	return "erased-" + userID + "@invalid"
+}
+
+// MaxAddresses is the number of addresses a user can keep: one home, one work.
+const MaxAddresses = 2
+
+// MaxRefreshFamilies is the number of logins a user can keep at once. A new
+// login beyond it ends the oldest.
+const MaxRefreshFamilies = 10
+
+// UserFilter selects and pages the users returned by UserStore.List. Zero
+// fields do not filter.
+type UserFilter struct {
+	Role          string
+	Disabled      *bool
+	EmailVerified *bool
+	// Search matches a case-insensitive substring of the names, email or
+	// phone.
+	Search string
+	Offset int
+	Limit  int
+}
+
+// The orders ProductStore.List can sort the catalog in. Products tied on
+// price, rating or name keep the order they were added in.
+const (
+	SortNewest    = "newest"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortRating    = "rating"
+	SortName      = "name"
+)
+
+// ProductSorts lists every valid ProductFilter.Sort.
+var ProductSorts = []string{SortNewest, SortPriceAsc, SortPriceDesc, SortRating, SortName}
+
+// ProductFilter selects, sorts and pages the products returned by
+// ProductStore.List. Zero fields do not filter. Prices are the products'
+// own, not their variants'.
+type ProductFilter struct {
+	// CategoryIDs keeps the products listed in any of the categories.
+	CategoryIDs []primitive.ObjectID
+	MinPrice    uint64
+	MaxPrice    uint64
+	MinRating   uint8
+	// Sort is one of ProductSorts, SortNewest when empty.
+	Sort string
+	// After continues the listing past this product, the last one of the
+	// previous page. Only its id and the field sorted on are read, and
+	// Offset is ignored.
+	After  *models.Product
+	Offset int
+	Limit  int
+}
+
+// UserStore persists users together with their tokens and addresses.
+type UserStore interface {
+	// CountByEmail and FindByEmail match the email regardless of case, as
+	// accounts created before emails were lower-cased may be stored in mixed
+	// case. Should two accounts differ only in case, FindByEmail returns the
+	// older one.
+	CountByEmail(ctx context.Context, email string) (int64, error)
+	CountByPhone(ctx context.Context, phone string) (int64, error)
+	Create(ctx context.Context, user *models.User) error
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+	FindByID(ctx context.Context, userID string) (models.User, error)
+	// List returns one page of the users matching filter, newest first, and
+	// how many match in total.
+	List(ctx context.Context, filter UserFilter) ([]models.User, int64, error)
+	// FindByIdentity returns the user linked to a login provider account.
+	FindByIdentity(ctx context.Context, provider, subject string) (models.User, error)
+	// LinkIdentity links a login provider account to the user, or fails with
+	// ErrIdentityLinked when it already belongs to someone.
+	LinkIdentity(ctx context.Context, userID string, identity models.Identity) error
+	// UpdateTokens stores the access token of a new login and starts
+	// tracking its refresh token family, ending the oldest beyond
+	// MaxRefreshFamilies. Refresh tokens themselves are not stored, only the
+	// id of each family's latest; the stored refresh token is cleared.
+	UpdateTokens(ctx context.Context, userID, signedToken string, family models.RefreshFamily) error
+	// RotateTokens stores a new access token and moves the family of current
+	// on to the refresh token newTokenID, provided current is still its
+	// latest token. It returns ErrRefreshTokenStale when it is not, and
+	// ErrRefreshFamilyEnded when the family is no longer tracked.
+	RotateTokens(ctx context.Context, userID string, current models.RefreshFamily, newTokenID, signedToken string) error
+	// RevokeFamily ends one login, leaving the user's other logins alone.
+	RevokeFamily(ctx context.Context, userID, family string) error
+	// RevokeTokens clears the stored tokens and families and bumps the
+	// user's token version, which invalidates every token issued before the
+	// call.
+	RevokeTokens(ctx context.Context, userID string) error
+	SetRole(ctx context.Context, userID, role string) error
+	// SetDisabled disables or re-enables the user. Disabling also revokes the
+	// user's tokens.
+	SetDisabled(ctx context.Context, userID string, disabled bool) error
+	// MarkEmailVerified flags the user as verified if their email is still
+	// email, and returns ErrUserNotFound otherwise.
+	MarkEmailVerified(ctx context.Context, userID, email string) error
+	// SetTOTPSecret stores a pending TOTP enrollment. It fails with
+	// ErrTwoFactorEnabled rather than replace an active secret.
+	SetTOTPSecret(ctx context.Context, userID, secret string) error
+	// EnableTwoFactor activates the pending secret, whose code for step was
+	// just confirmed, and replaces the recovery codes. It also revokes every
+	// token issued without the second factor.
+	EnableTwoFactor(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	DisableTwoFactor(ctx context.Context, userID string) error
+	// UseTOTPStep records step as used, or fails with ErrTOTPCodeUsed when
+	// it is not newer than the last accepted one.
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	// UseRecoveryCode consumes a recovery code, or fails with
+	// ErrRecoveryCodeUsed.
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) error
+	// UpdateProfile writes the user's names, phone, email and whether that
+	// email is verified.
+	UpdateProfile(ctx context.Context, user models.User) error
+	// ChangePassword sets a new password, drops any pending reset token and
+	// revokes the user's tokens.
+	ChangePassword(ctx context.Context, userID, hashedPassword string) error
+	// ScheduleErasure marks the user's personal data for erasure at at.
+	ScheduleErasure(ctx context.Context, userID string, at time.Time) error
+	// CancelErasure drops a pending erasure.
+	CancelErasure(ctx context.Context, userID string) error
+	// DueErasures returns the ids of the users whose erasure is due at now.
+	DueErasures(ctx context.Context, now time.Time) ([]string, error)
+	// Erase anonymizes the user: names, email, phone, credentials, role,
+	// addresses, cart and linked logins are removed and the account is
+	// disabled. The orders are kept for accounting.
+	Erase(ctx context.Context, userID string, at time.Time) error
+	// UpdatePasswordHash replaces the stored hash of an unchanged password,
+	// e.g. to move it to a stronger algorithm.
+	UpdatePasswordHash(ctx context.Context, userID, hashedPassword string) error
+	// SetPasswordReset records the hash of a new reset token, replacing any
+	// earlier one.
+	SetPasswordReset(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	// ResetPassword sets hashedPassword on the user holding an unexpired
+	// tokenHash, clears the reset token and revokes the user's tokens. It
+	// returns ErrResetTokenInvalid when no such user exists.
+	ResetPassword(ctx context.Context, tokenHash, hashedPassword string, now time.Time) error
+
+	AddAddress(ctx context.Context, userID string, address models.Address) error
+	UpdateAddress(ctx context.Context, userID string, index int, address models.Address) error
+	DeleteAddresses(ctx context.Context, userID string) error
+}
+
+// ProductStore persists the product catalog.
+type ProductStore interface {
+	Create(ctx context.Context, product *models.Product) error
+	// FindAll and List leave out archived products.
+	FindAll(ctx context.Context) ([]models.Product, error)
+	// FindByID also finds archived products.
+	FindByID(ctx context.Context, productID primitive.ObjectID) (models.Product, error)
+	// FindByIDs returns the products among ids in one query, in no
+	// particular order. Archived and unknown products are left out.
+	FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Product, error)
+	// List returns one page of the products matching filter and how many
+	// match in total, regardless of filter.After.
+	List(ctx context.Context, filter ProductFilter) ([]models.Product, int64, error)
+	// Update writes product if its stored version is still version, and
+	// stores it as version+1. It returns ErrProductConflict when the product
+	// has changed since. Create and Update fail with ErrSKUTaken when another
+	// product has a variant with the same SKU.
+	Update(ctx context.Context, product models.Product, version int) error
+}
+
+// CategoryStore persists the category tree.
+type CategoryStore interface {
+	// Create adds a category, or fails with ErrSlugTaken.
+	Create(ctx context.Context, category *models.Category) error
+	// FindAll returns every category ordered by sort order, then name.
+	FindAll(ctx context.Context) ([]models.Category, error)
+	FindBySlug(ctx context.Context, slug string) (models.Category, error)
+	// Update replaces the name, slug, parent and sort order of a category,
+	// or fails with ErrSlugTaken.
+	Update(ctx context.Context, category models.Category) error
+	// Delete removes a category and takes its products out of it. It fails
+	// with ErrCategoryHasChildren rather than orphan subcategories.
+	Delete(ctx context.Context, categoryID primitive.ObjectID) error
+}
+
+// Checkout says how a new order is paid for.
+type Checkout struct {
+	Payment models.Payment
+	// PaymentDue is when the order is cancelled unless it has been paid. It
+	// is zero for orders paid on delivery, which are placed straight away.
+	PaymentDue time.Time
+	// PinCode is where the order ships to. The warehouses nearest to it are
+	// preferred; it may be empty when the user has no address.
+	PinCode string
+}
+
+// OrderStore handles the cart and turns it into orders. A variantID of
+// primitive.NilObjectID buys a product that has no variants; products with
+// variants fail with ErrVariantRequired without one.
+type OrderStore interface {
+	AddProductToCart(ctx context.Context, productID, variantID primitive.ObjectID, userID string) error
+	// RemoveCartItem removes the variant from the cart, or every line of the
+	// product when variantID is primitive.NilObjectID.
+	RemoveCartItem(ctx context.Context, productID, variantID primitive.ObjectID, userID string) error
+	GetCart(ctx context.Context, userID string) ([]models.ProductUser, uint64, error)
+	// BuyItemFromCart and InstantBuyer allocate the order they place to
+	// warehouses with fulfillment.Plan and reserve its stock there, or fail
+	// with ErrInsufficientStock and place nothing. BuyItemFromCart fails
+	// with ErrProductUnavailable, naming the lines, when the cart holds
+	// archived products.
+	BuyItemFromCart(ctx context.Context, userID string, checkout Checkout) error
+	InstantBuyer(ctx context.Context, productID, variantID primitive.ObjectID, userID string, checkout Checkout) error
+	// FindOrder returns an order and the id of the user who placed it.
+	FindOrder(ctx context.Context, orderID primitive.ObjectID) (models.Order, string, error)
+	// UpdateOrderStatus moves an order from status from to status to, or
+	// fails with ErrOrderStatusChanged. Cancelling releases the order's
+	// reserved stock in its warehouses; shipping takes it off their stock on
+	// hand.
+	UpdateOrderStatus(ctx context.Context, orderID primitive.ObjectID, from, to string) error
+	// UnpaidOrders returns the ids of the orders still pending payment at
+	// now, when it was due.
+	UnpaidOrders(ctx context.Context, now time.Time) ([]primitive.ObjectID, error)
+}
+
+// InventoryStore keeps the stock each warehouse holds of every product
+// without variants and of every variant. Items never stocked in a warehouse
+// have nothing on hand there.
+type InventoryStore interface {
+	// Levels returns the recorded stock of a product's items in every
+	// warehouse.
+	Levels(ctx context.Context, productID primitive.ObjectID) ([]models.StockLevel, error)
+	// Adjust adds adjustment.Delta to the stock on hand in its warehouse and
+	// records the adjustment with the resulting OnHand. It fails with
+	// ErrInsufficientStock rather than leave less on hand than is reserved.
+	Adjust(ctx context.Context, adjustment *models.StockAdjustment) (models.StockLevel, error)
+	// Adjustments returns a product's adjustments, newest first.
+	Adjustments(ctx context.Context, productID primitive.ObjectID) ([]models.StockAdjustment, error)
+}
+
+// WarehouseStore keeps the warehouses stock is shipped from.
+type WarehouseStore interface {
+	Create(ctx context.Context, warehouse *models.Warehouse) error
+	// FindAll returns every warehouse, by name.
+	FindAll(ctx context.Context) ([]models.Warehouse, error)
+	// Update replaces the name, PIN code and status of a warehouse, or fails
+	// with ErrCantFindWarehouse.
+	Update(ctx context.Context, warehouse models.Warehouse) error
+}
+
+// AuditStore keeps an append-only trail of privileged actions.
+type AuditStore interface {
+	Record(ctx context.Context, record models.AuditRecord) error
+}
+
+// LoginAttemptStore counts failed logins per key, an account or a client
+// address, for login throttling and lockout.
+type LoginAttemptStore interface {
+	// Get returns the attempts recorded for key, or a zero record.
+	Get(ctx context.Context, key string) (models.LoginAttempts, error)
+	// RecordFailure counts a failed login made at at and returns the updated
+	// record. Failures are counted afresh when the previous one is older
+	// than window.
+	RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (models.LoginAttempts, error)
+	// Lock refuses logins for key until until.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset forgets the failures and any lock recorded for key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Stores bundles one implementation of every store, as opened by main.
+type Stores struct {
+	Users         UserStore
+	Products      ProductStore
+	Categories    CategoryStore
+	Orders        OrderStore
+	Inventory     InventoryStore
+	Warehouses    WarehouseStore
+	Audit         AuditStore
+	LoginAttempts LoginAttemptStore
+}
@@ -0,0 +1,670 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"regexp"
+	"time"
+)
+
+type MongoUserStore struct {
+	userCollection *mongo.Collection
+}
+
+func NewMongoUserStore(userCollection *mongo.Collection) *MongoUserStore {
+	return &MongoUserStore{userCollection: userCollection}
+}
+
+// caseInsensitive compares strings ignoring case but not accents.
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+func (s *MongoUserStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	return s.userCollection.CountDocuments(ctx, bson.M{"email": email}, options.Count().SetCollation(caseInsensitive))
+}
+
+func (s *MongoUserStore) CountByPhone(ctx context.Context, phone string) (int64, error) {
+	return s.userCollection.CountDocuments(ctx, bson.M{"phone": phone})
+}
+
+func (s *MongoUserStore) Create(ctx context.Context, user *models.User) error {
+	_, err := s.userCollection.InsertOne(ctx, user)
+	if err != nil {
+		log.Println(err)
+		return ErrCantCreateUser
+	}
+	return nil
+}
+
+func (s *MongoUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	var user models.User
+	opts := options.FindOne().SetCollation(caseInsensitive).SetSort(bson.D{{Key: "_id", Value: 1}})
+	err := s.userCollection.FindOne(ctx, bson.M{"email": email}, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (s *MongoUserStore) FindByID(ctx context.Context, userID string) (models.User, error) {
+	var user models.User
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return user, ErrUserIdIsNotValid
+	}
+	err = s.userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: userId}}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (s *MongoUserStore) List(ctx context.Context, filter UserFilter) ([]models.User, int64, error) {
+	query := bson.D{}
+	if filter.Role != "" {
+		query = append(query, primitive.E{Key: "role", Value: filter.Role})
+	}
+	if filter.Disabled != nil {
+		// Documents written before the flag existed have no disabled field.
+		var disabled interface{} = bson.M{"$ne": true}
+		if *filter.Disabled {
+			disabled = true
+		}
+		query = append(query, primitive.E{Key: "disabled", Value: disabled})
+	}
+	if filter.EmailVerified != nil {
+		query = append(query, primitive.E{Key: "email_verified", Value: *filter.EmailVerified})
+	}
+	if filter.Search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(filter.Search), Options: "i"}
+		query = append(query, primitive.E{Key: "$or", Value: bson.A{
+			bson.M{"firstname": pattern}, bson.M{"lastname": pattern},
+			bson.M{"email": pattern}, bson.M{"phone": pattern}}})
+	}
+
+	total, err := s.userCollection.CountDocuments(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListUsers
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetSkip(int64(filter.Offset))
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	cursor, err := s.userCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListUsers
+	}
+	defer cursor.Close(ctx)
+
+	users := make([]models.User, 0)
+	if err = cursor.All(ctx, &users); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListUsers
+	}
+	return users, total, nil
+}
+
+func (s *MongoUserStore) FindByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	var user models.User
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+	err := s.userCollection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+// LinkIdentity checks for an existing owner first. Two concurrent links of
+// the same provider account to different users need a unique index on
+// identities.provider + identities.subject to be ruled out.
+func (s *MongoUserStore) LinkIdentity(ctx context.Context, userID string, identity models.Identity) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	_, err = s.FindByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return ErrIdentityLinked
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "identities", Value: identity}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantLinkIdentity
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) UpdateTokens(ctx context.Context, userID, signedToken string, family models.RefreshFamily) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	update := bson.D{
+		{Key: "$set", Value: tokenFields(signedToken)},
+		{Key: "$push", Value: bson.M{"refresh_families": bson.M{"$each": bson.A{family}, "$slice": -MaxRefreshFamilies}}}}
+	if _, err = s.userCollection.UpdateOne(ctx, bson.M{"_id": userId}, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+	return nil
+}
+
+func (s *MongoUserStore) RotateTokens(ctx context.Context, userID string, current models.RefreshFamily, newTokenID, signedToken string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.M{"_id": userId, "refresh_families": bson.M{"$elemMatch": bson.M{
+		"family": current.Family, "token_id": current.TokenID}}}
+	set := append(tokenFields(signedToken),
+		primitive.E{Key: "refresh_families.$.token_id", Value: newTokenID})
+	result, err := s.userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	count, err := s.userCollection.CountDocuments(ctx, bson.M{"_id": userId, "refresh_families.family": current.Family})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+	if count == 0 {
+		return ErrRefreshFamilyEnded
+	}
+	return ErrRefreshTokenStale
+}
+
+func (s *MongoUserStore) RevokeFamily(ctx context.Context, userID, family string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	_, err = s.userCollection.UpdateOne(ctx, bson.M{"_id": userId},
+		bson.M{"$pull": bson.M{"refresh_families": bson.M{"family": family}}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+	return nil
+}
+
+func (s *MongoUserStore) RevokeTokens(ctx context.Context, userID string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			primitive.E{Key: "token", Value: ""},
+			{Key: "refreshtoken", Value: ""},
+			{Key: "refresh_families", Value: bson.A{}},
+			{Key: "updatedat", Value: updatedAt}}},
+		{Key: "$inc", Value: bson.D{primitive.E{Key: "token_version", Value: 1}}}}
+
+	_, err = s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+	return nil
+}
+
+// tokenFields are the $set fields storing the access token and clearing the
+// refresh token stored before families were tracked.
+func tokenFields(signedToken string) bson.D {
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return bson.D{
+		primitive.E{Key: "token", Value: signedToken},
+		{Key: "refreshtoken", Value: ""},
+		{Key: "updatedat", Value: updatedAt}}
+}
+
+func (s *MongoUserStore) SetRole(ctx context.Context, userID, role string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "role", Value: role}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateRole
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) SetDisabled(ctx context.Context, userID string, disabled bool) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "disabled", Value: disabled},
+		{Key: "updatedat", Value: updatedAt}}}}
+	if disabled {
+		update = bson.D{
+			{Key: "$set", Value: bson.D{
+				primitive.E{Key: "disabled", Value: true},
+				{Key: "token", Value: ""},
+				{Key: "refreshtoken", Value: ""},
+				{Key: "updatedat", Value: updatedAt}}},
+			{Key: "$inc", Value: bson.D{primitive.E{Key: "token_version", Value: 1}}}}
+	}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantDisableUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}, {Key: "email", Value: email}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "email_verified", Value: true}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantVerifyEmail
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) SetTOTPSecret(ctx context.Context, userID, secret string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}, {Key: "two_factor_enabled", Value: bson.M{"$ne": true}}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "totp_secret", Value: secret}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	if result.MatchedCount == 0 {
+		if _, err := s.FindByID(ctx, userID); err != nil {
+			return err
+		}
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+func (s *MongoUserStore) EnableTwoFactor(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}, {Key: "two_factor_enabled", Value: bson.M{"$ne": true}}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			primitive.E{Key: "two_factor_enabled", Value: true},
+			{Key: "totp_last_step", Value: step},
+			{Key: "recovery_code_hashes", Value: recoveryCodeHashes},
+			{Key: "token", Value: ""},
+			{Key: "refreshtoken", Value: ""},
+			{Key: "updatedat", Value: updatedAt}}},
+		{Key: "$inc", Value: bson.D{primitive.E{Key: "token_version", Value: 1}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	if result.MatchedCount == 0 {
+		if _, err := s.FindByID(ctx, userID); err != nil {
+			return err
+		}
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+func (s *MongoUserStore) DisableTwoFactor(ctx context.Context, userID string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "two_factor_enabled", Value: false},
+		{Key: "totp_secret", Value: ""},
+		{Key: "totp_last_step", Value: int64(0)},
+		{Key: "recovery_code_hashes", Value: make([]string, 0)}}}}
+	_, err = s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	return nil
+}
+
+func (s *MongoUserStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	// $not also matches documents that have no last step yet.
+	filter := bson.D{primitive.E{Key: "_id", Value: userId},
+		{Key: "totp_last_step", Value: bson.M{"$not": bson.M{"$gte": step}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "totp_last_step", Value: step}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	if result.MatchedCount == 0 {
+		return ErrTOTPCodeUsed
+	}
+	return nil
+}
+
+func (s *MongoUserStore) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}, {Key: "recovery_code_hashes", Value: codeHash}}
+	update := bson.D{{Key: "$pull", Value: bson.D{primitive.E{Key: "recovery_code_hashes", Value: codeHash}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTwoFactor
+	}
+	if result.MatchedCount == 0 {
+		return ErrRecoveryCodeUsed
+	}
+	return nil
+}
+
+func (s *MongoUserStore) UpdateProfile(ctx context.Context, user models.User) error {
+	userId, err := primitive.ObjectIDFromHex(user.UserID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "firstname", Value: user.FirstName},
+		{Key: "lastname", Value: user.LastName},
+		{Key: "phone", Value: user.Phone},
+		{Key: "email", Value: user.Email},
+		{Key: "email_verified", Value: user.EmailVerified},
+		{Key: "updatedat", Value: updatedAt}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProfile
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) ChangePassword(ctx context.Context, userID, hashedPassword string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			primitive.E{Key: "password", Value: hashedPassword},
+			{Key: "password_reset_hash", Value: ""},
+			{Key: "token", Value: ""},
+			{Key: "refreshtoken", Value: ""},
+			{Key: "updatedat", Value: updatedAt}}},
+		{Key: "$inc", Value: bson.D{primitive.E{Key: "token_version", Value: 1}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePassword
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) ScheduleErasure(ctx context.Context, userID string, at time.Time) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "erasure_scheduled_at", Value: at}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantEraseUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) CancelErasure(ctx context.Context, userID string) error {
+	return s.ScheduleErasure(ctx, userID, time.Time{})
+}
+
+func (s *MongoUserStore) DueErasures(ctx context.Context, now time.Time) ([]string, error) {
+	filter := bson.M{"erasure_scheduled_at": bson.M{"$gt": time.Time{}, "$lte": now}}
+	cursor, err := s.userCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := make([]string, 0)
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err = cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids = append(ids, doc.ID.Hex())
+	}
+	return ids, cursor.Err()
+}
+
+// Erase anonymizes the user document in place. The orders embedded in it
+// carry no personal data and are kept.
+func (s *MongoUserStore) Erase(ctx context.Context, userID string, at time.Time) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	updatedAt, _ := time.Parse(time.RFC3339, at.Format(time.RFC3339))
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			primitive.E{Key: "firstname", Value: ""},
+			{Key: "lastname", Value: ""},
+			{Key: "email", Value: ErasedEmail(userID)},
+			{Key: "role", Value: models.RoleCustomer},
+			{Key: "phone", Value: ""},
+			{Key: "password", Value: ""},
+			{Key: "token", Value: ""},
+			{Key: "refreshtoken", Value: ""},
+			{Key: "email_verified", Value: false},
+			{Key: "password_reset_hash", Value: ""},
+			{Key: "two_factor_enabled", Value: false},
+			{Key: "totp_secret", Value: ""},
+			{Key: "recovery_code_hashes", Value: bson.A{}},
+			{Key: "identities", Value: bson.A{}},
+			{Key: "address_details", Value: bson.A{}},
+			{Key: "user_cart", Value: bson.A{}},
+			{Key: "disabled", Value: true},
+			{Key: "erasure_scheduled_at", Value: time.Time{}},
+			{Key: "erased_at", Value: at},
+			{Key: "updatedat", Value: updatedAt}}},
+		{Key: "$inc", Value: bson.D{primitive.E{Key: "token_version", Value: 1}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantEraseUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) UpdatePasswordHash(ctx context.Context, userID, hashedPassword string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "password", Value: hashedPassword}}}}
+	_, err = s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePassword
+	}
+	return nil
+}
+
+func (s *MongoUserStore) SetPasswordReset(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "password_reset_hash", Value: tokenHash},
+		{Key: "password_reset_expires_at", Value: expiresAt}}}}
+	_, err = s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePassword
+	}
+	return nil
+}
+
+func (s *MongoUserStore) ResetPassword(ctx context.Context, tokenHash, hashedPassword string, now time.Time) error {
+	if tokenHash == "" {
+		return ErrResetTokenInvalid
+	}
+	updatedAt, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	filter := bson.D{primitive.E{Key: "password_reset_hash", Value: tokenHash},
+		{Key: "password_reset_expires_at", Value: bson.M{"$gt": now}}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			primitive.E{Key: "password", Value: hashedPassword},
+			{Key: "password_reset_hash", Value: ""},
+			{Key: "token", Value: ""},
+			{Key: "refreshtoken", Value: ""},
+			{Key: "updatedat", Value: updatedAt}}},
+		{Key: "$inc", Value: bson.D{primitive.E{Key: "token_version", Value: 1}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePassword
+	}
+	if result.MatchedCount == 0 {
+		return ErrResetTokenInvalid
+	}
+	return nil
+}
+
+func (s *MongoUserStore) AddAddress(ctx context.Context, userID string, address models.Address) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	// Only push while the slot after the last allowed one is still empty, so
+	// two concurrent requests cannot both squeeze past the limit.
+	filter := bson.D{primitive.E{Key: "_id", Value: userId},
+		{Key: fmt.Sprintf("address_details.%d", MaxAddresses-1), Value: bson.M{"$exists": false}}}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address_details", Value: address}}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	if result.MatchedCount == 0 {
+		if _, err := s.FindByID(ctx, userID); err != nil {
+			return err
+		}
+		return ErrAddressLimit
+	}
+	return nil
+}
+
+func (s *MongoUserStore) UpdateAddress(ctx context.Context, userID string, index int, address models.Address) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	prefix := fmt.Sprintf("address_details.%d.", index)
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: prefix + "house", Value: address.House},
+		{Key: prefix + "street", Value: address.Street},
+		{Key: prefix + "city", Value: address.City},
+		{Key: prefix + "pincode", Value: address.PinCode}}}}
+
+	_, err = s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	return nil
+}
+
+func (s *MongoUserStore) DeleteAddresses(ctx context.Context, userID string) error {
+	userId, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: userId}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address_details", Value: make([]models.Address, 0)}}}}
+	_, err = s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	return nil
+}
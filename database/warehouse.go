@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+type MongoWarehouseStore struct {
+	whCollection *mongo.Collection
+}
+
+func NewMongoWarehouseStore(whCollection *mongo.Collection) *MongoWarehouseStore {
+	return &MongoWarehouseStore{whCollection: whCollection}
+}
+
+func (s *MongoWarehouseStore) Create(ctx context.Context, warehouse *models.Warehouse) error {
+	if _, err := s.whCollection.InsertOne(ctx, warehouse); err != nil {
+		log.Println(err)
+		return ErrCantUpdateWarehouse
+	}
+	return nil
+}
+
+func (s *MongoWarehouseStore) FindAll(ctx context.Context) ([]models.Warehouse, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.whCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	warehouses := make([]models.Warehouse, 0)
+	if err = cursor.All(ctx, &warehouses); err != nil {
+		return nil, err
+	}
+	return warehouses, nil
+}
+
+func (s *MongoWarehouseStore) Update(ctx context.Context, warehouse models.Warehouse) error {
+	update := bson.M{"$set": bson.M{"name": warehouse.Name, "pin_code": warehouse.PinCode, "active": warehouse.Active}}
+	result, err := s.whCollection.UpdateOne(ctx, bson.M{"_id": warehouse.WarehouseID}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateWarehouse
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindWarehouse
+	}
+	return nil
+}
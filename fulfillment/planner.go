@@ -0,0 +1,125 @@
+// Package fulfillment decides which warehouses ship an order.
+package fulfillment
+
+import (
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+)
+
+var ErrInsufficientStock = errors.New("not enough stock")
+
+// Line is a quantity of one product, or of one of its variants, to ship.
+type Line struct {
+	ProductID primitive.ObjectID
+	VariantID *primitive.ObjectID
+	Quantity  int
+}
+
+func (line Line) key() itemKey {
+	key := itemKey{product: line.ProductID}
+	if line.VariantID != nil {
+		key.variant = *line.VariantID
+	}
+	return key
+}
+
+// itemKey names a product without variants, or one variant.
+type itemKey struct {
+	product, variant primitive.ObjectID
+}
+
+// Distance is how far apart two PIN codes are, from 0 for the same code to
+// 6 for codes in different postal zones. The leading digits of a PIN code
+// name its zone, sub-zone and sorting district, so codes sharing more of
+// them are closer. Unknown codes are farther than any known one.
+func Distance(a, b string) int {
+	if a == "" || b == "" {
+		return 7
+	}
+	if a == b {
+		return 0
+	}
+	shared := 0
+	for shared < len(a) && shared < len(b) && shared < 5 && a[shared] == b[shared] {
+		shared++
+	}
+	return 6 - shared
+}
+
+// Plan allocates lines to the active warehouses holding stock for them,
+// shipping to pinCode. It favours as few shipments as possible: it keeps
+// picking the warehouse that can ship the most of what is left, the nearest
+// one on a tie. It fails with ErrInsufficientStock when the warehouses
+// together cannot ship every line.
+func Plan(lines []Line, warehouses []models.Warehouse, stock []models.StockLevel, pinCode string) ([]models.Allocation, error) {
+	ranked := make([]models.Warehouse, 0, len(warehouses))
+	for _, warehouse := range warehouses {
+		if warehouse.Active {
+			ranked = append(ranked, warehouse)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		di, dj := Distance(pinCode, ranked[i].PinCode), Distance(pinCode, ranked[j].PinCode)
+		if di != dj {
+			return di < dj
+		}
+		if ranked[i].Name != ranked[j].Name {
+			return ranked[i].Name < ranked[j].Name
+		}
+		return ranked[i].WarehouseID.Hex() < ranked[j].WarehouseID.Hex()
+	})
+
+	available := make(map[primitive.ObjectID]map[itemKey]int, len(ranked))
+	for _, warehouse := range ranked {
+		available[warehouse.WarehouseID] = make(map[itemKey]int)
+	}
+	for _, level := range stock {
+		items, ok := available[level.WarehouseID]
+		if !ok {
+			continue
+		}
+		key := itemKey{product: level.ProductID}
+		if level.VariantID != nil {
+			key.variant = *level.VariantID
+		}
+		items[key] += level.OnHand - level.Reserved
+	}
+
+	remaining := make([]int, len(lines))
+	left := 0
+	for i, line := range lines {
+		remaining[i] = line.Quantity
+		left += line.Quantity
+	}
+	allocations := make([]models.Allocation, 0)
+	for left > 0 {
+		best, bestUnits := -1, 0
+		for i, warehouse := range ranked {
+			units := 0
+			for j, line := range lines {
+				units += min(remaining[j], available[warehouse.WarehouseID][line.key()])
+			}
+			if units > bestUnits {
+				best, bestUnits = i, units
+			}
+		}
+		if best < 0 {
+			return nil, ErrInsufficientStock
+		}
+		warehouse := ranked[best]
+		for j, line := range lines {
+			units := min(remaining[j], available[warehouse.WarehouseID][line.key()])
+			if units <= 0 {
+				continue
+			}
+			allocations = append(allocations, models.Allocation{WarehouseID: warehouse.WarehouseID,
+				ProductID: line.ProductID, VariantID: line.VariantID, Quantity: units})
+			available[warehouse.WarehouseID][line.key()] -= units
+			remaining[j] -= units
+			left -= units
+		}
+	}
+	return allocations, nil
+}
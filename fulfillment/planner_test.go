@@ -0,0 +1,133 @@
+package fulfillment
+
+import (
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+)
+
+func objectID(n byte) primitive.ObjectID {
+	var id primitive.ObjectID
+	id[len(id)-1] = n
+	return id
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"560001", "560001", 0},
+		{"560001", "560002", 1},
+		{"560001", "560011", 2},
+		{"560001", "560101", 3},
+		{"560001", "561001", 4},
+		{"560001", "570001", 5},
+		{"560001", "110001", 6},
+		{"560001", "", 7},
+		{"", "", 7},
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	mug, bowl := objectID(1), objectID(2)
+	small, large := objectID(3), objectID(4)
+	delhi := models.Warehouse{WarehouseID: objectID(10), Name: "Delhi", PinCode: "110001", Active: true}
+	mumbai := models.Warehouse{WarehouseID: objectID(11), Name: "Mumbai", PinCode: "400001", Active: true}
+	pune := models.Warehouse{WarehouseID: objectID(12), Name: "Pune", PinCode: "411001", Active: true}
+	closed := models.Warehouse{WarehouseID: objectID(13), Name: "Closed", PinCode: "400050"}
+	all := []models.Warehouse{delhi, mumbai, pune, closed}
+
+	stock := func(warehouse models.Warehouse, product primitive.ObjectID, variant *primitive.ObjectID, onHand, reserved int) models.StockLevel {
+		return models.StockLevel{WarehouseID: warehouse.WarehouseID, ProductID: product, VariantID: variant,
+			OnHand: onHand, Reserved: reserved}
+	}
+	allocation := func(warehouse models.Warehouse, product primitive.ObjectID, variant *primitive.ObjectID, quantity int) models.Allocation {
+		return models.Allocation{WarehouseID: warehouse.WarehouseID, ProductID: product, VariantID: variant,
+			Quantity: quantity}
+	}
+
+	tests := []struct {
+		name    string
+		lines   []Line
+		stock   []models.StockLevel
+		pinCode string
+		want    []models.Allocation
+		wantErr error
+	}{
+		{
+			name:    "nearest warehouse on a tie",
+			lines:   []Line{{ProductID: mug, Quantity: 2}},
+			stock:   []models.StockLevel{stock(delhi, mug, nil, 5, 0), stock(mumbai, mug, nil, 5, 0)},
+			pinCode: "400050",
+			want:    []models.Allocation{allocation(mumbai, mug, nil, 2)},
+		},
+		{
+			name:    "one shipment over the nearest warehouse",
+			lines:   []Line{{ProductID: mug, Quantity: 1}, {ProductID: bowl, Quantity: 1}},
+			stock:   []models.StockLevel{stock(mumbai, mug, nil, 5, 0), stock(delhi, mug, nil, 1, 0), stock(delhi, bowl, nil, 1, 0)},
+			pinCode: "400050",
+			want:    []models.Allocation{allocation(delhi, mug, nil, 1), allocation(delhi, bowl, nil, 1)},
+		},
+		{
+			name:    "split across warehouses",
+			lines:   []Line{{ProductID: mug, Quantity: 4}},
+			stock:   []models.StockLevel{stock(delhi, mug, nil, 1, 0), stock(mumbai, mug, nil, 3, 0)},
+			pinCode: "110001",
+			want:    []models.Allocation{allocation(mumbai, mug, nil, 3), allocation(delhi, mug, nil, 1)},
+		},
+		{
+			name:    "reserved units are not available",
+			lines:   []Line{{ProductID: mug, Quantity: 2}},
+			stock:   []models.StockLevel{stock(mumbai, mug, nil, 3, 2), stock(pune, mug, nil, 2, 0)},
+			pinCode: "400001",
+			want:    []models.Allocation{allocation(pune, mug, nil, 2)},
+		},
+		{
+			name:    "variants are stocked apart",
+			lines:   []Line{{ProductID: mug, VariantID: &large, Quantity: 1}},
+			stock:   []models.StockLevel{stock(mumbai, mug, &small, 5, 0), stock(delhi, mug, &large, 1, 0)},
+			pinCode: "400001",
+			want:    []models.Allocation{allocation(delhi, mug, &large, 1)},
+		},
+		{
+			name:    "unknown PIN code falls back to names",
+			lines:   []Line{{ProductID: mug, Quantity: 1}},
+			stock:   []models.StockLevel{stock(pune, mug, nil, 1, 0), stock(delhi, mug, nil, 1, 0)},
+			pinCode: "",
+			want:    []models.Allocation{allocation(delhi, mug, nil, 1)},
+		},
+		{
+			name:    "inactive warehouses ship nothing",
+			lines:   []Line{{ProductID: mug, Quantity: 1}},
+			stock:   []models.StockLevel{stock(closed, mug, nil, 9, 0)},
+			pinCode: "400050",
+			wantErr: ErrInsufficientStock,
+		},
+		{
+			name:    "not enough stock",
+			lines:   []Line{{ProductID: mug, Quantity: 3}},
+			stock:   []models.StockLevel{stock(delhi, mug, nil, 1, 0), stock(mumbai, mug, nil, 1, 0)},
+			pinCode: "110001",
+			wantErr: ErrInsufficientStock,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Plan(tt.lines, all, tt.stock, tt.pinCode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Plan() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Plan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Sender delivers transactional mail such as password reset links.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the sender selected by cfg.Driver.
+func New(cfg config.Mail) (Sender, error) {
+	switch cfg.Driver {
+	case "log":
+		return &LogSender{from: cfg.From}, nil
+	case "file":
+		return NewFileSender(cfg.File, cfg.From), nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+}
+
+// LogSender writes every message to the standard logger. It is meant for
+// local development.
+type LogSender struct {
+	from string
+}
+
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From = s.from
+	}
+	log.Printf("mail from %s to %s: %s\n%s", msg.From, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileSender appends every message as one JSON line to a file, so tests can
+// read the mail that was sent.
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+func NewFileSender(path, from string) *FileSender {
+	return &FileSender{path: path, from: from}
+}
+
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From = s.from
+	}
+	msg.SentAt = time.Now()
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err = os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"github.com/mukulmantosh/ecommerce-gin/mailer"
 	"github.com/mukulmantosh/ecommerce-gin/middleware"
+	"github.com/mukulmantosh/ecommerce-gin/models"
 	"github.com/mukulmantosh/ecommerce-gin/routes"
+	"github.com/mukulmantosh/ecommerce-gin/tokens"
 	"log"
-	"os"
 )
 import (
 	"github.com/mukulmantosh/ecommerce-gin/controllers"
@@ -14,24 +18,133 @@ import (
 )
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
+	cfg := config.MustLoad()
+	if err := tokens.Configure(cfg.Tokens); err != nil {
+		log.Fatal(err)
 	}
 
-	app := controllers.NewApplication(database.ProductData(database.Client, "Products"),
-		database.UserData(database.Client, "Users"))
+	stores := newStores(cfg)
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app, err := controllers.NewApplication(stores, mail, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Request)
+	if err := controllers.BootstrapAdmin(ctx, stores.Users, cfg.AdminEmail); err != nil {
+		log.Fatal(err)
+	}
+	cancel()
+	go app.RunErasures(context.Background(), cfg.Privacy.ErasureCheckInterval)
+	go app.RunPaymentTimeouts(context.Background(), cfg.Orders.PaymentCheckInterval)
+	go app.RunSearchIndex(context.Background(), cfg.Search.ReindexInterval)
 
 	router := gin.New()
-	router.Use(gin.Logger())
-	routes.UserRoutes(router)
-	router.Use(middleware.Authentication())
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
+	router.Use(gin.Logger(), gin.Recovery())
+	routes.UserRoutes(router, app)
+	router.Use(middleware.Authentication(stores.Users, cfg.Timeouts.Request))
 
+	router.POST("/users/logout", app.Logout())
+	router.GET("/users/me", app.GetProfile())
+	router.PATCH("/users/me", app.UpdateProfile())
+	router.DELETE("/users/me", app.DeleteAccount())
+	router.DELETE("/users/me/erasure", app.CancelAccountDeletion())
+	router.GET("/users/me/export", app.ExportUserData())
+	router.POST("/users/me/password", app.ChangePassword())
+	router.POST("/users/verify-email/resend", app.ResendVerification())
+	router.POST("/users/2fa/enroll", app.EnrollTwoFactor())
+	router.POST("/users/2fa/verify", app.ConfirmTwoFactor())
+	router.POST("/users/2fa/disable", app.DisableTwoFactor())
 	router.GET("/addtocart", app.AddToCart())
 	router.GET("/removeitem", app.RemoveItem())
+	router.GET("/listcart", app.GetItemFromCart())
 	router.GET("/cartcheckout", app.BuyFromCart())
 	router.GET("/instantbuy", app.InstantBuy())
+	router.POST("/addaddress", app.AddAddress())
+	router.PUT("/edithomeaddress", app.EditHomeAddress())
+	router.PUT("/editworkaddress", app.EditWorkAddress())
+	router.GET("/deleteaddresses", app.DeleteAddress())
+	router.GET("/users/me/orders", app.ListOrders())
+	router.POST("/orders/:orderID/cancel", app.CancelOrder())
+
+	routes.AdminRoutes(router.Group("/admin", middleware.RequireRole(models.RoleAdmin), middleware.RequireTwoFactor()), app)
+	routes.UserAdminRoutes(router.Group("/admin/users",
+		middleware.RequireRole(models.RoleAdmin, models.RoleSupport), middleware.RequireTwoFactor()), app)
 
-	log.Fatal(router.Run(":" + port))
+	log.Fatal(router.Run(":" + cfg.Port))
 
 }
+
+// newStores opens the stores for cfg.Database.Driver: mongo, memory (no
+// database at all), sqlite or postgres.
+func newStores(cfg config.Config) database.Stores {
+	switch cfg.Database.Driver {
+	case "mongo":
+		client := database.DBSet(cfg.Database)
+		if client == nil {
+			log.Fatal("unable to connect to mongodb")
+		}
+		userCollection := database.UserData(client, cfg.Database.Name, "Users")
+		prodCollection := database.ProductData(client, cfg.Database.Name, "Products")
+		catCollection := database.CategoryData(client, cfg.Database.Name, "Categories")
+		invCollection := database.InventoryData(client, cfg.Database.Name, "Inventory")
+		adjCollection := database.AdjustmentData(client, cfg.Database.Name, "StockAdjustments")
+		whCollection := database.WarehouseData(client, cfg.Database.Name, "Warehouses")
+		auditCollection := database.AuditData(client, cfg.Database.Name, "AuditLog")
+		attemptCollection := database.LoginAttemptData(client, cfg.Database.Name, "LoginAttempts")
+
+		products := database.NewMongoProductStore(prodCollection)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Database.ConnectTimeout)
+		defer cancel()
+		if err := products.EnsureIndexes(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+		return database.Stores{
+			Users:         database.NewMongoUserStore(userCollection),
+			Products:      products,
+			Categories:    database.NewMongoCategoryStore(catCollection, prodCollection),
+			Orders:        database.NewMongoOrderStore(prodCollection, userCollection, invCollection, whCollection),
+			Inventory:     database.NewMongoInventoryStore(invCollection, adjCollection),
+			Warehouses:    database.NewMongoWarehouseStore(whCollection),
+			Audit:         database.NewMongoAuditStore(auditCollection),
+			LoginAttempts: database.NewMongoLoginAttemptStore(attemptCollection),
+		}
+	case "memory":
+		db := database.NewMemoryDB()
+		return database.Stores{
+			Users:         database.NewMemoryUserStore(db),
+			Products:      database.NewMemoryProductStore(db),
+			Categories:    database.NewMemoryCategoryStore(db),
+			Orders:        database.NewMemoryOrderStore(db),
+			Inventory:     database.NewMemoryInventoryStore(db),
+			Warehouses:    database.NewMemoryWarehouseStore(db),
+			Audit:         database.NewMemoryAuditStore(db),
+			LoginAttempts: database.NewMemoryLoginAttemptStore(db),
+		}
+	case "sqlite", "postgres":
+		db, err := database.SQLSet(cfg.Database)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return database.Stores{
+			Users:         database.NewSQLUserStore(db),
+			Products:      database.NewSQLProductStore(db),
+			Categories:    database.NewSQLCategoryStore(db),
+			Orders:        database.NewSQLOrderStore(db),
+			Inventory:     database.NewSQLInventoryStore(db),
+			Warehouses:    database.NewSQLWarehouseStore(db),
+			Audit:         database.NewSQLAuditStore(db),
+			LoginAttempts: database.NewSQLLoginAttemptStore(db),
+		}
+	default:
+		log.Fatalf("unknown DB_DRIVER %q", cfg.Database.Driver)
+		return database.Stores{}
+	}
+}
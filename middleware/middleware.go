@@ -1,13 +1,21 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
 	"github.com/mukulmantosh/ecommerce-gin/tokens"
+	"log"
 	"net/http"
+	"time"
 )
 
-func Authentication() gin.HandlerFunc {
+// Authentication accepts a valid access token whose version still matches
+// the user's stored token version and whose login is still live, so tokens
+// revoked by logout or refresh token reuse stop working before they expire.
+func Authentication(users database.UserStore, timeout time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clientToken := c.Request.Header.Get("token")
 		if clientToken == "" {
@@ -23,12 +31,76 @@ func Authentication() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if claims.TokenType != tokens.AccessToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "an access token is required"})
+			c.Abort()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		user, findErr := users.FindByID(ctx, claims.UID)
+		if findErr != nil {
+			log.Println(findErr)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the token has been revoked"})
+			c.Abort()
+			return
+		}
+		// Access tokens issued before families were tracked have none.
+		if user.TokenVersion != claims.Version || user.Disabled ||
+			claims.Family != "" && !user.HasRefreshFamily(claims.Family) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the token has been revoked"})
+			c.Abort()
+			return
+		}
 
 		c.Set("email", claims.Email)
 		c.Set("first_name", claims.FirstName)
 		c.Set("last_name", claims.LastName)
 		c.Set("uid", claims.UID)
+		c.Set("role", roleOf(user))
+		c.Set("two_factor", user.TwoFactorEnabled)
 		c.Next()
 
 	}
 }
+
+// RequireRole lets the request through only when Authentication has placed
+// one of roles in the context. It must run after Authentication.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to access this resource"})
+		c.Abort()
+	}
+}
+
+// RequireTwoFactor refuses users who have not enabled two-factor
+// authentication. Enabling it revokes older tokens, so every token that gets
+// through was issued after a second factor was checked. It must run after
+// Authentication.
+func RequireTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("two_factor") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication must be enabled to access this resource"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// roleOf treats accounts created before roles existed as customers.
+func roleOf(user models.User) string {
+	if user.Role == "" {
+		return models.RoleCustomer
+	}
+	return user.Role
+}
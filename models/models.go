@@ -5,12 +5,42 @@ import (
 	"time"
 )
 
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+	RoleSupport  = "support"
+)
+
+// Roles lists every role a user can hold.
+var Roles = []string{RoleCustomer, RoleAdmin, RoleSupport}
+
+// Order statuses. An order holds reserved stock until it is shipped or
+// cancelled; orders placed before stock was tracked have no status.
+const (
+	OrderPendingPayment = "pending_payment"
+	OrderPlaced         = "placed"
+	OrderShipped        = "shipped"
+	OrderCancelled      = "cancelled"
+)
+
+// Stock adjustment reasons.
+const (
+	StockReceived   = "received"
+	StockReturned   = "returned"
+	StockDamaged    = "damaged"
+	StockLost       = "lost"
+	StockCorrection = "correction"
+)
+
+// StockReasons lists every reason a stock adjustment can give.
+var StockReasons = []string{StockReceived, StockReturned, StockDamaged, StockLost, StockCorrection}
+
 type User struct {
 	ID             primitive.ObjectID `bson:"_id" json:"_id"`
 	FirstName      string             `json:"first_name" validate:"required,min=2,max=30"`
 	LastName       string             `json:"last_name" validate:"required,min=2,max=30"`
-	Password       string             `json:"password" validate:"required,min=6,max=15"`
-	Email          string             `json:"email" validate:"required"`
+	Password       string             `json:"password" validate:"required"`
+	Email          string             `json:"email" validate:"required,email"`
 	Phone          string             `json:"phone" validate:"required"`
 	Token          string             `json:"token"`
 	RefreshToken   string             `json:"refresh_token"`
@@ -20,14 +50,161 @@ type User struct {
 	UserCart       []ProductUser      `json:"user_cart" bson:"user_cart"`
 	AddressDetails []Address          `json:"address_details" bson:"address_details"`
 	OrderStatus    []Order            `json:"order_status" bson:"order_status"`
+	TokenVersion   int                `json:"-" bson:"token_version"`
+	Role           string             `json:"role" bson:"role"`
+	EmailVerified  bool               `json:"email_verified" bson:"email_verified"`
+	// Disabled accounts cannot log in; disabling also revokes their tokens.
+	Disabled bool `json:"disabled" bson:"disabled"`
+	// RefreshFamilies are the logins that can still refresh their tokens,
+	// oldest first.
+	RefreshFamilies []RefreshFamily `json:"-" bson:"refresh_families,omitempty"`
+
+	// PasswordResetHash is the SHA-256 of the outstanding reset token; the
+	// token itself is only ever mailed.
+	PasswordResetHash      string    `json:"-" bson:"password_reset_hash"`
+	PasswordResetExpiresAt time.Time `json:"-" bson:"password_reset_expires_at"`
+
+	// TwoFactorEnabled is set once the user has confirmed a TOTP code for
+	// TOTPSecret; until then the secret is only a pending enrollment.
+	TwoFactorEnabled bool   `json:"two_factor_enabled" bson:"two_factor_enabled"`
+	TOTPSecret       string `json:"-" bson:"totp_secret"`
+	// TOTPLastStep is the time step of the last accepted code, so each code
+	// can be used once.
+	TOTPLastStep       int64    `json:"-" bson:"totp_last_step"`
+	RecoveryCodeHashes []string `json:"-" bson:"recovery_code_hashes"`
+
+	// Identities are the external login provider accounts linked to the user.
+	Identities []Identity `json:"identities" bson:"identities"`
+
+	// ErasureScheduledAt is when the user's personal data is due to be
+	// erased at their request; zero when no erasure is pending.
+	ErasureScheduledAt time.Time `json:"-" bson:"erasure_scheduled_at"`
+	// ErasedAt is when the personal data was erased. The anonymized account
+	// is kept so its orders stay on the books.
+	ErasedAt time.Time `json:"-" bson:"erased_at"`
+}
+
+// UserProfile is how a user is shown in API responses. Unlike User it has no
+// password hash, tokens or other secrets, so it is safe to serialize.
+type UserProfile struct {
+	UserID           string `json:"user_id"`
+	FirstName        string `json:"first_name"`
+	LastName         string `json:"last_name"`
+	Email            string `json:"email"`
+	Phone            string `json:"phone"`
+	Role             string `json:"role"`
+	EmailVerified    bool   `json:"email_verified"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+	Disabled         bool   `json:"disabled"`
+	// ErasureScheduledAt and ErasedAt are only set while an erasure is
+	// pending and once it has run.
+	ErasureScheduledAt *time.Time `json:"erasure_scheduled_at,omitempty"`
+	ErasedAt           *time.Time `json:"erased_at,omitempty"`
+	Identities         []Identity `json:"identities"`
+	AddressDetails     []Address  `json:"address_details"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// RefreshFamily is the chain of refresh tokens rotated from one login. Only
+// its latest token, TokenID, can be exchanged for new tokens.
+type RefreshFamily struct {
+	Family    string    `bson:"family"`
+	TokenID   string    `bson:"token_id"`
+	StartedAt time.Time `bson:"started_at"`
+}
+
+// HasRefreshFamily reports whether the login that started family is still
+// live.
+func (u User) HasRefreshFamily(family string) bool {
+	for _, f := range u.RefreshFamilies {
+		if f.Family == family {
+			return true
+		}
+	}
+	return false
+}
+
+// Profile returns the public view of the user.
+func (u User) Profile() UserProfile {
+	profile := UserProfile{
+		UserID:           u.UserID,
+		FirstName:        u.FirstName,
+		LastName:         u.LastName,
+		Email:            u.Email,
+		Phone:            u.Phone,
+		Role:             u.Role,
+		EmailVerified:    u.EmailVerified,
+		TwoFactorEnabled: u.TwoFactorEnabled,
+		Disabled:         u.Disabled,
+		Identities:       u.Identities,
+		AddressDetails:   u.AddressDetails,
+		CreatedAt:        u.CreatedAt,
+		UpdatedAt:        u.UpdatedAt,
+	}
+	if !u.ErasureScheduledAt.IsZero() {
+		at := u.ErasureScheduledAt
+		profile.ErasureScheduledAt = &at
+	}
+	if !u.ErasedAt.IsZero() {
+		at := u.ErasedAt
+		profile.ErasedAt = &at
+	}
+	if profile.Identities == nil {
+		profile.Identities = make([]Identity, 0)
+	}
+	if profile.AddressDetails == nil {
+		profile.AddressDetails = make([]Address, 0)
+	}
+	return profile
+}
+
+// Identity links a user to an account at an OpenID Connect provider, which
+// is identified by the provider's subject, not by email.
+type Identity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"subject" bson:"subject"`
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
 }
 
 type Product struct {
 	ProductID   primitive.ObjectID `bson:"_id" json:"_id"`
-	ProductName string             `json:"product_name" bson:"product_name"`
-	Price       uint64             `json:"price"`
-	Rating      uint8              `json:"rating"`
+	ProductName string             `json:"product_name" bson:"product_name" validate:"required,max=200"`
+	Price       uint64             `json:"price" validate:"gt=0"`
+	Rating      uint8              `json:"rating" validate:"lte=5"`
 	Image       string             `json:"image"`
+	// Description is searched along with the name.
+	Description string `json:"description" bson:"description" validate:"max=5000"`
+	// Version is bumped by every update, for optimistic concurrency.
+	Version int `json:"version" bson:"version"`
+	// Archived products are hidden from the catalog and cannot be bought.
+	Archived bool `json:"archived" bson:"archived"`
+	// CategoryIDs are the categories the product is listed in.
+	CategoryIDs []primitive.ObjectID `json:"category_ids" bson:"category_ids"`
+	// Variants are the versions of the product that can be bought, e.g. one
+	// per size. A product without variants is bought as it is.
+	Variants []Variant `json:"variants" bson:"variants" validate:"dive"`
+}
+
+// Variant is one version of a product, told apart by its Attributes such as
+// size or color. Its stock is kept in a StockLevel.
+type Variant struct {
+	VariantID  primitive.ObjectID `json:"_id" bson:"_id"`
+	SKU        string             `json:"sku" bson:"sku" validate:"required,max=64"`
+	Attributes map[string]string  `json:"attributes" bson:"attributes"`
+	// Price and Image override the product's when set.
+	Price *uint64 `json:"price,omitempty" bson:"price,omitempty" validate:"omitempty,gt=0"`
+	Image string  `json:"image,omitempty" bson:"image,omitempty"`
+}
+
+// Category is a node of the catalog's category tree. Top-level categories
+// have no parent; siblings are listed by SortOrder, then by name.
+type Category struct {
+	CategoryID primitive.ObjectID  `json:"_id" bson:"_id"`
+	Name       string              `json:"name" bson:"name" validate:"required,max=100"`
+	Slug       string              `json:"slug" bson:"slug" validate:"required,max=100"`
+	ParentID   *primitive.ObjectID `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	SortOrder  int                 `json:"sort_order" bson:"sort_order"`
 }
 
 type ProductUser struct {
@@ -36,6 +213,9 @@ type ProductUser struct {
 	Price       uint64             `json:"price"`
 	Rating      uint8              `json:"rating"`
 	Image       string             `json:"image"`
+	// VariantID and SKU name the variant bought, for products with variants.
+	VariantID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	SKU       string              `json:"sku,omitempty" bson:"sku,omitempty"`
 }
 
 type Address struct {
@@ -53,9 +233,81 @@ type Order struct {
 	Price         uint64             `json:"total_price" bson:"total_price"`
 	Discount      int                `json:"discount" bson:"discount"`
 	PaymentMethod Payment            `json:"payment_method" bson:"payment_method"`
+	Status        string             `json:"status,omitempty" bson:"status,omitempty"`
+	// PaymentDue is when an unpaid digital order is cancelled.
+	PaymentDue *time.Time `json:"payment_due,omitempty" bson:"payment_due,omitempty"`
+	// Allocations say which warehouses ship the order and hold its stock.
+	Allocations []Allocation `json:"allocations,omitempty" bson:"allocations,omitempty"`
+}
+
+// Allocation is the quantity of one product or variant of an order that a
+// warehouse ships.
+type Allocation struct {
+	WarehouseID primitive.ObjectID  `json:"warehouse_id" bson:"warehouse_id"`
+	ProductID   primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID   *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Quantity    int                 `json:"quantity" bson:"quantity"`
 }
 
 type Payment struct {
 	Digital bool `json:"digital"`
 	COD     bool `json:"cod"`
 }
+
+// Warehouse is a location stock is kept in and shipped from. Orders ship
+// from the warehouses nearest to their PinCode.
+type Warehouse struct {
+	WarehouseID primitive.ObjectID `json:"_id" bson:"_id"`
+	Name        string             `json:"name" bson:"name" validate:"required,max=100"`
+	PinCode     string             `json:"pin_code" bson:"pin_code" validate:"required,numeric,len=6"`
+	// Inactive warehouses keep their stock but ship nothing.
+	Active bool `json:"active" bson:"active"`
+}
+
+// StockLevel is the stock a warehouse holds of a product without variants,
+// or of one variant. Reserved units are held by open orders; the rest are
+// Available.
+type StockLevel struct {
+	WarehouseID primitive.ObjectID  `json:"warehouse_id" bson:"warehouse_id"`
+	ProductID   primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID   *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	OnHand      int                 `json:"on_hand" bson:"on_hand"`
+	Reserved    int                 `json:"reserved" bson:"reserved"`
+	Available   int                 `json:"available" bson:"-"`
+}
+
+// StockAdjustment records a manual change to the stock a warehouse has on
+// hand, such as a delivery or a write-off.
+type StockAdjustment struct {
+	ID          primitive.ObjectID  `json:"_id" bson:"_id"`
+	WarehouseID primitive.ObjectID  `json:"warehouse_id" bson:"warehouse_id"`
+	ProductID   primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID   *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Delta       int                 `json:"delta" bson:"delta"`
+	Reason      string              `json:"reason" bson:"reason"`
+	Note        string              `json:"note,omitempty" bson:"note,omitempty"`
+	// OnHand is the stock on hand after the adjustment.
+	OnHand  int       `json:"on_hand" bson:"on_hand"`
+	ActorID string    `json:"actor_id" bson:"actor_id"`
+	At      time.Time `json:"at" bson:"at"`
+}
+
+// AuditRecord is written whenever a privileged user acts on behalf of
+// another user.
+type AuditRecord struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id"`
+	ActorID      string             `json:"actor_id" bson:"actor_id"`
+	TargetUserID string             `json:"target_user_id" bson:"target_user_id"`
+	Action       string             `json:"action" bson:"action"`
+	Detail       string             `json:"detail" bson:"detail"`
+	At           time.Time          `json:"at" bson:"at"`
+}
+
+// LoginAttempts tracks the recent failed logins for one key: an account or
+// a client address.
+type LoginAttempts struct {
+	Key           string    `json:"key" bson:"_id"`
+	Failures      int       `json:"failures" bson:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at" bson:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until" bson:"locked_until"`
+}
@@ -0,0 +1,64 @@
+package passwords
+
+import (
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+// testHasher hashes with the cheapest parameters, to keep the tests fast.
+func testHasher(algorithm string, cost, argon2Time int) *Hasher {
+	return NewHasher(config.Passwords{Algorithm: algorithm, BcryptCost: cost,
+		Argon2Time: argon2Time, Argon2MemoryKiB: 64, Argon2Threads: 1})
+}
+
+func TestHasherVerify(t *testing.T) {
+	argon2 := testHasher(Argon2id, bcrypt.MinCost, 1)
+	bcrypt4 := testHasher(Bcrypt, bcrypt.MinCost, 1)
+
+	argon2Hash, err := argon2.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt4.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		hasher     *Hasher
+		password   string
+		hash       string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{"argon2id", argon2, "correct horse", argon2Hash, true, false},
+		{"argon2id wrong password", argon2, "battery staple", argon2Hash, false, false},
+		{"argon2id to bcrypt", bcrypt4, "correct horse", argon2Hash, true, true},
+		{"argon2id with other parameters", testHasher(Argon2id, bcrypt.MinCost, 2), "correct horse", argon2Hash, true, true},
+		{"bcrypt", bcrypt4, "correct horse", bcryptHash, true, false},
+		{"bcrypt wrong password", bcrypt4, "battery staple", bcryptHash, false, false},
+		{"bcrypt to argon2id", argon2, "correct horse", bcryptHash, true, true},
+		{"bcrypt with another cost", testHasher(Bcrypt, bcrypt.MinCost+1, 1), "correct horse", bcryptHash, true, true},
+		{"malformed argon2id", argon2, "correct horse", "$argon2id$v=19$m=64$salt", false, false},
+		{"unknown format", argon2, "correct horse", "correct horse", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := tt.hasher.Verify(tt.password, tt.hash)
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("Verify() = %v, %v, want %v, %v", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestHashIsSalted(t *testing.T) {
+	h := testHasher(Argon2id, bcrypt.MinCost, 1)
+	first, _ := h.Hash("correct horse")
+	second, _ := h.Hash("correct horse")
+	if first == second {
+		t.Errorf("Hash() returned %q twice", first)
+	}
+}
@@ -0,0 +1,112 @@
+package passwords
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// commonPasswords is the blocklist used when no blocklist file is configured.
+//
+//go:embed common-passwords.txt
+var commonPasswords string
+
+// Policy decides which new passwords are acceptable. It is applied when a
+// password is chosen, never at login, so tightening it does not lock out
+// existing accounts.
+type Policy struct {
+	minLength  int
+	maxLength  int
+	minClasses int
+	// maxBytes is bcrypt's input limit; past it bcrypt ignores the rest.
+	maxBytes int
+	// blocked holds lower-cased breached or common passwords.
+	blocked map[string]struct{}
+}
+
+// NewPolicy builds the policy, reading the blocklist file if one is set and
+// using the built-in list of common passwords otherwise.
+func NewPolicy(cfg config.Passwords) (*Policy, error) {
+	p := &Policy{minLength: cfg.MinLength, maxLength: cfg.MaxLength, minClasses: cfg.MinClasses,
+		blocked: make(map[string]struct{})}
+	if cfg.Algorithm == Bcrypt {
+		p.maxBytes = 72
+	}
+	if cfg.BlocklistFile == "" {
+		if err := p.block(strings.NewReader(commonPasswords)); err != nil {
+			return nil, fmt.Errorf("password blocklist: %w", err)
+		}
+		return p, nil
+	}
+
+	f, err := os.Open(cfg.BlocklistFile)
+	if err != nil {
+		return nil, fmt.Errorf("password blocklist: %w", err)
+	}
+	defer f.Close()
+	if err = p.block(f); err != nil {
+		return nil, fmt.Errorf("password blocklist: %w", err)
+	}
+	return p, nil
+}
+
+// block adds the passwords listed in r, one per line, to the blocklist.
+// Blank lines and lines starting with # are skipped.
+func (p *Policy) block(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.blocked[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Check returns a user-facing reason when password breaks the policy.
+func (p *Policy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		return fmt.Errorf("password must be at least %d characters", p.minLength)
+	}
+	if length > p.maxLength || (p.maxBytes > 0 && len(password) > p.maxBytes) {
+		return fmt.Errorf("password must be at most %d characters", p.maxLength)
+	}
+	if classes := characterClasses(password); classes < p.minClasses {
+		return fmt.Errorf("password must mix at least %d of lower case letters, upper case letters, digits and symbols", p.minClasses)
+	}
+	if _, ok := p.blocked[strings.ToLower(password)]; ok {
+		return fmt.Errorf("this password is too common or has appeared in a data breach, choose another one")
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
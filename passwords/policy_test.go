@@ -0,0 +1,64 @@
+package passwords
+
+import (
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.Passwords
+		password string
+		wantErr  bool
+	}{
+		{"long enough", config.Passwords{MinLength: 8, MaxLength: 64, MinClasses: 1}, "correct horse", false},
+		{"too short", config.Passwords{MinLength: 8, MaxLength: 64, MinClasses: 1}, "horse", true},
+		{"length counts characters", config.Passwords{MinLength: 4, MaxLength: 4, MinClasses: 1}, "ñañá", false},
+		{"too long", config.Passwords{MinLength: 8, MaxLength: 10, MinClasses: 1}, "correct horse", true},
+		{"over bcrypt's limit", config.Passwords{MinLength: 8, MaxLength: 128, MinClasses: 1, Algorithm: Bcrypt},
+			strings.Repeat("a", 73), true},
+		{"argon2id has no byte limit", config.Passwords{MinLength: 8, MaxLength: 128, MinClasses: 1, Algorithm: Argon2id},
+			strings.Repeat("a", 73), false},
+		{"too few classes", config.Passwords{MinLength: 8, MaxLength: 64, MinClasses: 3}, "correcthorse", true},
+		{"enough classes", config.Passwords{MinLength: 8, MaxLength: 64, MinClasses: 3}, "Correct horse", false},
+		{"built-in blocklist", config.Passwords{MinLength: 8, MaxLength: 64, MinClasses: 1}, "password123", true},
+		{"blocklist ignores case", config.Passwords{MinLength: 8, MaxLength: 64, MinClasses: 1}, "PassWord123", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = policy.Check(tt.password); (err != nil) != tt.wantErr {
+				t.Errorf("Check(%q) error = %v, want error %v", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicyBlocklistFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte("# ours\n\nCorrect Horse\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPolicy(config.Passwords{MinLength: 8, MaxLength: 64, MinClasses: 1, BlocklistFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = policy.Check("correct horse"); err == nil {
+		t.Error("Check() accepted a password from the blocklist file")
+	}
+	// The file replaces the built-in list.
+	if err = policy.Check("password123"); err != nil {
+		t.Errorf("Check() = %v, want the built-in list unused", err)
+	}
+
+	if _, err = NewPolicy(config.Passwords{BlocklistFile: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Error("NewPolicy() accepted a missing blocklist file")
+	}
+}
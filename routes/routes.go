@@ -3,12 +3,73 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/mukulmantosh/ecommerce-gin/controllers"
+	"github.com/mukulmantosh/ecommerce-gin/middleware"
+	"github.com/mukulmantosh/ecommerce-gin/models"
 )
 
-func UserRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/users/signup", controllers.Signup())
-	incomingRoutes.POST("/users/login", controllers.Login())
-	incomingRoutes.POST("/admin/addproduct", controllers.ProductViewerAdmin())
-	incomingRoutes.GET("/users/productview", controllers.SearchProduct())
-	incomingRoutes.GET("/users/search", controllers.SearchProductByQuery())
+func UserRoutes(incomingRoutes *gin.Engine, app *controllers.Application) {
+	incomingRoutes.POST("/users/signup", app.Signup())
+	incomingRoutes.POST("/users/login", app.Login())
+	incomingRoutes.POST("/users/login/2fa", app.LoginTwoFactor())
+	incomingRoutes.GET("/users/oidc/:provider/login", app.OIDCLogin())
+	incomingRoutes.GET("/users/oidc/:provider/callback", app.OIDCCallback())
+	incomingRoutes.POST("/users/refresh", app.RefreshToken())
+	incomingRoutes.POST("/users/password/forgot", app.ForgotPassword())
+	incomingRoutes.POST("/users/password/reset", app.ResetPassword())
+	incomingRoutes.GET("/users/verify-email", app.VerifyEmail())
+	incomingRoutes.GET("/users/productview", app.SearchProduct())
+	incomingRoutes.GET("/users/search", app.SearchProductByQuery())
+	incomingRoutes.GET("/categories", app.ListCategories())
+	incomingRoutes.GET("/categories/:slug/products", app.CategoryProducts())
+	incomingRoutes.GET("/.well-known/jwks.json", app.JWKS())
+}
+
+// AdminRoutes registers the catalog and back-office endpoints. The group must
+// already be guarded by Authentication, RequireRole and RequireTwoFactor.
+func AdminRoutes(adminRoutes *gin.RouterGroup, app *controllers.Application) {
+	adminRoutes.POST("/addproduct", app.ProductViewerAdmin())
+	adminRoutes.GET("/products/:productID", app.GetProductAdmin())
+	adminRoutes.PUT("/products/:productID", app.ReplaceProduct())
+	adminRoutes.PATCH("/products/:productID", app.PatchProduct())
+	adminRoutes.DELETE("/products/:productID", app.ArchiveProduct())
+	adminRoutes.POST("/products/:productID/restore", app.RestoreProduct())
+	adminRoutes.POST("/categories", app.CreateCategory())
+	adminRoutes.PUT("/categories/:categoryID", app.UpdateCategory())
+	adminRoutes.DELETE("/categories/:categoryID", app.DeleteCategory())
+	adminRoutes.GET("/warehouses", app.ListWarehouses())
+	adminRoutes.POST("/warehouses", app.CreateWarehouse())
+	adminRoutes.PUT("/warehouses/:warehouseID", app.UpdateWarehouse())
+	adminRoutes.GET("/inventory/:productID", app.GetStock())
+	adminRoutes.POST("/inventory/:productID/adjust", app.AdjustStock())
+	adminRoutes.GET("/inventory/:productID/adjustments", app.ListStockAdjustments())
+	adminRoutes.POST("/orders/:orderID/paid", app.MarkOrderPaid())
+	adminRoutes.POST("/orders/:orderID/ship", app.ShipOrder())
+	adminRoutes.POST("/orders/:orderID/cancel", app.CancelOrderAdmin())
+	adminRoutes.POST("/unlock", app.UnlockAccount())
+
+	onBehalf := adminRoutes.Group("/onbehalf", app.OnBehalfOf())
+	onBehalf.GET("/addtocart", app.AddToCart())
+	onBehalf.GET("/removeitem", app.RemoveItem())
+	onBehalf.GET("/listcart", app.GetItemFromCart())
+	onBehalf.GET("/cartcheckout", app.BuyFromCart())
+	onBehalf.GET("/instantbuy", app.InstantBuy())
+	onBehalf.POST("/addaddress", app.AddAddress())
+	onBehalf.PUT("/edithomeaddress", app.EditHomeAddress())
+	onBehalf.PUT("/editworkaddress", app.EditWorkAddress())
+	onBehalf.GET("/deleteaddresses", app.DeleteAddress())
+}
+
+// UserAdminRoutes registers the user management endpoints used by admins and
+// support. The group must already be guarded by Authentication,
+// RequireRole(admin, support) and RequireTwoFactor; changing roles and
+// overriding erasures are further limited to admins.
+func UserAdminRoutes(userRoutes *gin.RouterGroup, app *controllers.Application) {
+	userRoutes.GET("", app.ListUsers())
+	userRoutes.GET("/:userID", app.GetUser())
+	userRoutes.POST("/:userID/disable", app.DisableUser())
+	userRoutes.POST("/:userID/enable", app.EnableUser())
+	userRoutes.POST("/:userID/logout", app.ForceLogout())
+	userRoutes.PUT("/:userID/role", middleware.RequireRole(models.RoleAdmin), app.SetUserRole())
+	userRoutes.POST("/:userID/erase", middleware.RequireRole(models.RoleAdmin), app.EraseUser())
+	userRoutes.DELETE("/:userID/erasure", middleware.RequireRole(models.RoleAdmin), app.CancelUserErasure())
 }
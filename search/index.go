@@ -0,0 +1,227 @@
+// Package search finds products by free text.
+package search
+
+import (
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// A word in the name counts this many times one in the description.
+const nameBoost = 3
+
+// How well a query word matches a word of a product, for scoring: the same
+// word, the same stem, the start of it, or the word with typos.
+const (
+	exactMatch  = 1.0
+	stemMatch   = 0.9
+	prefixMatch = 0.7
+	typoMatch   = 0.5
+)
+
+// Searcher is a full-text index of the catalog.
+type Searcher interface {
+	// Add indexes product, replacing what was indexed for it before.
+	// Archived products are removed instead.
+	Add(product models.Product)
+	Remove(productID primitive.ObjectID)
+	// Rebuild replaces the whole index with products.
+	Rebuild(products []models.Product)
+	// Search returns the products matching every word of query, most
+	// relevant first.
+	Search(query string) []Hit
+}
+
+// Hit is a product found by Searcher.Search.
+type Hit struct {
+	ProductID primitive.ObjectID `json:"product_id"`
+	Score     float64            `json:"score"`
+}
+
+// term is a word of the index with the products it appears in.
+type term struct {
+	runes    []rune
+	stem     string
+	postings map[primitive.ObjectID]posting
+}
+
+// posting counts a word in the name and in the description of a product.
+type posting struct {
+	name, description int
+}
+
+// Index is an in-process inverted index implementing Searcher. It is safe
+// for concurrent use.
+type Index struct {
+	mu    sync.RWMutex
+	terms map[string]*term
+	// docs holds the words indexed for each product, to remove them again.
+	docs map[primitive.ObjectID][]string
+}
+
+func NewIndex() *Index {
+	return &Index{terms: make(map[string]*term), docs: make(map[primitive.ObjectID][]string)}
+}
+
+func (ix *Index) Add(product models.Product) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(product.ProductID)
+	if !product.Archived {
+		ix.add(product)
+	}
+}
+
+func (ix *Index) Remove(productID primitive.ObjectID) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(productID)
+}
+
+func (ix *Index) Rebuild(products []models.Product) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.terms = make(map[string]*term)
+	ix.docs = make(map[primitive.ObjectID][]string)
+	for _, product := range products {
+		if !product.Archived {
+			ix.add(product)
+		}
+	}
+}
+
+func (ix *Index) add(product models.Product) {
+	counts := make(map[string]posting)
+	for _, word := range tokenize(product.ProductName) {
+		p := counts[word]
+		p.name++
+		counts[word] = p
+	}
+	for _, word := range tokenize(product.Description) {
+		p := counts[word]
+		p.description++
+		counts[word] = p
+	}
+	words := make([]string, 0, len(counts))
+	for word, p := range counts {
+		t, ok := ix.terms[word]
+		if !ok {
+			t = &term{runes: []rune(word), stem: stem(word), postings: make(map[primitive.ObjectID]posting)}
+			ix.terms[word] = t
+		}
+		t.postings[product.ProductID] = p
+		words = append(words, word)
+	}
+	ix.docs[product.ProductID] = words
+}
+
+func (ix *Index) remove(productID primitive.ObjectID) {
+	for _, word := range ix.docs[productID] {
+		t := ix.terms[word]
+		delete(t.postings, productID)
+		if len(t.postings) == 0 {
+			delete(ix.terms, word)
+		}
+	}
+	delete(ix.docs, productID)
+}
+
+// Search scores each product by the words of query it matches: how well
+// each word matches, weighted by how rare the matched word is in the
+// catalog and by how often it appears in the name and description. Equal
+// scores list newer products first.
+func (ix *Index) Search(query string) []Hit {
+	words := unique(tokenize(query))
+	if len(words) == 0 {
+		return make([]Hit, 0)
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	docs := float64(len(ix.docs))
+	scores := make(map[primitive.ObjectID]float64)
+	matched := make(map[primitive.ObjectID]int)
+	for _, word := range words {
+		wordRunes, wordStem := []rune(word), stem(word)
+		// A product scores for the best of the terms matching word.
+		best := make(map[primitive.ObjectID]float64)
+		for text, t := range ix.terms {
+			weight := match(word, wordRunes, wordStem, text, t)
+			if weight == 0 {
+				continue
+			}
+			idf := math.Log(1 + docs/float64(len(t.postings)))
+			for id, p := range t.postings {
+				score := weight * idf * (nameBoost*frequency(p.name) + frequency(p.description))
+				if score > best[id] {
+					best[id] = score
+				}
+			}
+		}
+		for id, score := range best {
+			scores[id] += score
+			matched[id]++
+		}
+	}
+
+	hits := make([]Hit, 0)
+	for id, score := range scores {
+		if matched[id] == len(words) {
+			hits = append(hits, Hit{ProductID: id, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ProductID.Hex() > hits[j].ProductID.Hex()
+	})
+	return hits
+}
+
+// match returns how well the query word matches the indexed term text, or
+// 0 when it does not.
+func match(word string, wordRunes []rune, wordStem, text string, t *term) float64 {
+	switch {
+	case text == word:
+		return exactMatch
+	case t.stem == wordStem:
+		return stemMatch
+	case utf8.RuneCountInString(word) >= 2 && strings.HasPrefix(text, word):
+		return prefixMatch
+	}
+	allowed := typos(len(wordRunes))
+	if allowed == 0 {
+		return 0
+	}
+	if d := distance(wordRunes, t.runes, allowed); d <= allowed {
+		return typoMatch / float64(d)
+	}
+	return 0
+}
+
+// frequency dampens how often a word appears, so repeating it adds less
+// and less.
+func frequency(count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return 1 + math.Log(float64(count))
+}
+
+func unique(words []string) []string {
+	seen := make(map[string]bool, len(words))
+	out := words[:0]
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			out = append(out, word)
+		}
+	}
+	return out
+}
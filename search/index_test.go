@@ -0,0 +1,118 @@
+package search
+
+import (
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+)
+
+// catalog indexes products numbered in the order given, so later ones are
+// newer, and returns the index with the products by name.
+func catalog(products ...models.Product) (*Index, map[string]models.Product) {
+	byName := make(map[string]models.Product, len(products))
+	for i := range products {
+		products[i].ProductID[len(products[i].ProductID)-1] = byte(i + 1)
+		byName[products[i].ProductName] = products[i]
+	}
+	ix := NewIndex()
+	ix.Rebuild(products)
+	return ix, byName
+}
+
+func names(hits []Hit, byName map[string]models.Product) []string {
+	byID := make(map[primitive.ObjectID]string, len(byName))
+	for name, product := range byName {
+		byID[product.ProductID] = name
+	}
+	out := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		out = append(out, byID[hit.ProductID])
+	}
+	return out
+}
+
+func TestIndexSearch(t *testing.T) {
+	ix, byName := catalog(
+		models.Product{ProductName: "Café Mug", Description: "Ceramic coffee mug"},
+		models.Product{ProductName: "Kettle", Description: "Electric kettle for boiling water"},
+		models.Product{ProductName: "Coffee beans", Description: "Dark roast"},
+		models.Product{ProductName: "Painted bowl"},
+		models.Product{ProductName: "Glasses set"},
+		models.Product{ProductName: "Old mug", Archived: true},
+		models.Product{ProductName: "Teapot", Description: "Pours coffee too"},
+	)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"cafe", []string{"Café Mug"}},
+		{"MUG", []string{"Café Mug"}},
+		{"mugs", []string{"Café Mug"}},
+		{"ket", []string{"Kettle"}},
+		{"ketle", []string{"Kettle"}},
+		{"kettel", []string{"Kettle"}},
+		{"painting", []string{"Painted bowl"}},
+		{"glass", []string{"Glasses set"}},
+		{"boiled", []string{"Kettle"}},
+		// A match in the name ranks above one in the description.
+		{"coffee", []string{"Coffee beans", "Teapot", "Café Mug"}},
+		// Every word must match.
+		{"coffee mug", []string{"Café Mug"}},
+		{"coffee kettle", []string{}},
+		{"xyz", []string{}},
+		{".*", []string{}},
+		{"", []string{}},
+		// Short words are not matched with typos.
+		{"mig", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := names(ix.Search(tt.query), byName); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexAddAndRemove(t *testing.T) {
+	ix, byName := catalog(
+		models.Product{ProductName: "Kettle", Description: "Electric"},
+		models.Product{ProductName: "Mug"},
+	)
+	kettle := byName["Kettle"]
+
+	kettle.ProductName, kettle.Description = "Teapot", "Porcelain"
+	ix.Add(kettle)
+	for query, want := range map[string]int{"kettle": 0, "electric": 0, "teapot": 1, "porcelain": 1} {
+		if got := len(ix.Search(query)); got != want {
+			t.Errorf("after renaming, Search(%q) found %d, want %d", query, got, want)
+		}
+	}
+
+	kettle.Archived = true
+	ix.Add(kettle)
+	if got := ix.Search("teapot"); len(got) != 0 {
+		t.Errorf("Search found the archived product: %v", got)
+	}
+
+	ix.Remove(byName["Mug"].ProductID)
+	if got := ix.Search("mug"); len(got) != 0 {
+		t.Errorf("Search found the removed product: %v", got)
+	}
+	if len(ix.terms) != 0 || len(ix.docs) != 0 {
+		t.Errorf("the index kept %d terms and %d products after removing everything", len(ix.terms), len(ix.docs))
+	}
+}
+
+func TestIndexSearchTies(t *testing.T) {
+	ix, byName := catalog(
+		models.Product{ProductName: "Blue mug"},
+		models.Product{ProductName: "Red mug"},
+	)
+	// Equal scores list the newer product first.
+	if got, want := names(ix.Search("mug"), byName), []string{"Red mug", "Blue mug"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search(%q) = %q, want %q", "mug", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package search
+
+import (
+	"context"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IndexedStore is a database.ProductStore that keeps a Searcher in step
+// with the products written through it.
+type IndexedStore struct {
+	database.ProductStore
+	index Searcher
+}
+
+func NewIndexedStore(store database.ProductStore, index Searcher) *IndexedStore {
+	return &IndexedStore{ProductStore: store, index: index}
+}
+
+func (s *IndexedStore) Create(ctx context.Context, product *models.Product) error {
+	if err := s.ProductStore.Create(ctx, product); err != nil {
+		return err
+	}
+	s.index.Add(*product)
+	return nil
+}
+
+func (s *IndexedStore) Update(ctx context.Context, product models.Product, version int) error {
+	if err := s.ProductStore.Update(ctx, product, version); err != nil {
+		return err
+	}
+	s.index.Add(product)
+	return nil
+}
+
+// Reindex rebuilds the index from the store, picking up products written
+// by other instances of the application.
+func (s *IndexedStore) Reindex(ctx context.Context) error {
+	products, err := s.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	s.index.Rebuild(products)
+	return nil
+}
+
+// Search returns limit products matching query from offset on, most relevant
+// first, and how many match in total. Only that page is loaded from the
+// store. Products archived or deleted since they were indexed, by another
+// instance of the application, are left out and removed from the index;
+// Reindex drops the rest of them.
+func (s *IndexedStore) Search(ctx context.Context, query string, offset, limit int) ([]models.Product, int, error) {
+	hits := s.index.Search(query)
+	page := hits[min(offset, len(hits)):min(offset+limit, len(hits))]
+	ids := make([]primitive.ObjectID, 0, len(page))
+	for _, hit := range page {
+		ids = append(ids, hit.ProductID)
+	}
+	found, err := s.FindByIDs(ctx, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[primitive.ObjectID]models.Product, len(found))
+	for _, product := range found {
+		byID[product.ProductID] = product
+	}
+
+	products := make([]models.Product, 0, len(page))
+	total := len(hits)
+	for _, hit := range page {
+		product, ok := byID[hit.ProductID]
+		if !ok {
+			s.index.Remove(hit.ProductID)
+			total--
+			continue
+		}
+		products = append(products, product)
+	}
+	return products, total, nil
+}
@@ -0,0 +1,96 @@
+package search
+
+import (
+	"context"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"testing"
+)
+
+// countingStore counts the product lookups made through it.
+type countingStore struct {
+	database.ProductStore
+	lookups, loaded int
+}
+
+func (s *countingStore) FindByID(ctx context.Context, productID primitive.ObjectID) (models.Product, error) {
+	s.lookups++
+	s.loaded++
+	return s.ProductStore.FindByID(ctx, productID)
+}
+
+func (s *countingStore) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Product, error) {
+	s.lookups++
+	s.loaded += len(ids)
+	return s.ProductStore.FindByIDs(ctx, ids)
+}
+
+func TestIndexedStoreSearchLoadsOnePage(t *testing.T) {
+	ctx := context.Background()
+	products := &countingStore{ProductStore: database.NewMemoryProductStore(database.NewMemoryDB())}
+	store := NewIndexedStore(products, NewIndex())
+	for i := 0; i < 10; i++ {
+		product := models.Product{ProductID: primitive.NewObjectID(), ProductName: "Mug " + strconv.Itoa(i)}
+		if err := store.Create(ctx, &product); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		offset, limit int
+		want          int
+	}{
+		{"first page", 0, 4, 4},
+		{"last page", 8, 4, 2},
+		{"past the end", 12, 4, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products.lookups, products.loaded = 0, 0
+			found, total, err := store.Search(ctx, "mug", tt.offset, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != tt.want || total != 10 {
+				t.Errorf("Search() = %d products of %d, want %d of 10", len(found), total, tt.want)
+			}
+			if products.lookups != 1 || products.loaded != tt.want {
+				t.Errorf("made %d lookups for %d products, want 1 for %d", products.lookups, products.loaded, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexedStoreSearchDropsStaleHits(t *testing.T) {
+	ctx := context.Background()
+	products := database.NewMemoryProductStore(database.NewMemoryDB())
+	store := NewIndexedStore(products, NewIndex())
+
+	blue := models.Product{ProductID: primitive.NewObjectID(), ProductName: "Blue mug"}
+	red := models.Product{ProductID: primitive.NewObjectID(), ProductName: "Red mug"}
+	for _, product := range []*models.Product{&blue, &red} {
+		if err := store.Create(ctx, product); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Archived by another instance, bypassing this one's index.
+	red.Archived = true
+	if err := products.Update(ctx, red, red.Version); err != nil {
+		t.Fatal(err)
+	}
+
+	found, total, err := store.Search(ctx, "mug", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ProductID != blue.ProductID || total != 1 {
+		t.Fatalf("Search() = %v, %d, want only the blue mug", found, total)
+	}
+	if hits := store.index.Search("red"); len(hits) != 0 {
+		t.Errorf("the archived product is still indexed: %v", hits)
+	}
+}
@@ -0,0 +1,99 @@
+package search
+
+import (
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+// fold lowercases s and strips its diacritics, so "Café" and "cafe" are the
+// same word.
+func fold(s string) string {
+	// A transform.Chain keeps state, so each call needs its own.
+	folder := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(folder, s)
+	if err != nil {
+		folded = s
+	}
+	return strings.ToLower(folded)
+}
+
+// tokenize splits s into folded words: runs of letters and digits.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// stem reduces an English word to a common form for its plural and its -ing
+// and -ed forms, so "mugs" matches "mug" and "painted" matches "painting".
+// It is deliberately light: words it does not recognise are left alone.
+func stem(word string) string {
+	n := len(word)
+	switch {
+	case n > 4 && strings.HasSuffix(word, "ies"):
+		return word[:n-3] + "y"
+	case n > 4 && strings.HasSuffix(word, "sses"):
+		return word[:n-2]
+	case n > 4 && (strings.HasSuffix(word, "xes") || strings.HasSuffix(word, "ches") ||
+		strings.HasSuffix(word, "shes") || strings.HasSuffix(word, "zes")):
+		return word[:n-2]
+	case n > 5 && strings.HasSuffix(word, "ing"):
+		return word[:n-3]
+	case n > 4 && strings.HasSuffix(word, "ed"):
+		return word[:n-2]
+	case n > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return word[:n-1]
+	}
+	return word
+}
+
+// typos is how many edits a query word of n letters may be from a word it
+// matches: none for short words, where one edit turns most words into
+// others.
+func typos(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// distance is the number of single-letter insertions, deletions,
+// substitutions and swaps of neighbours that turn a into b, or max+1 when
+// it is more than max.
+func distance(a, b []rune, max int) int {
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+	// Three rows of the edit matrix: two back, previous and current.
+	back, prev, cur := make([]int, len(b)+1), make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], back[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		if best > max {
+			return max + 1
+		}
+		back, prev, cur = prev, cur, back
+	}
+	return min(prev[len(b)], max+1)
+}
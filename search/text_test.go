@@ -0,0 +1,86 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"Café Mug", []string{"cafe", "mug"}},
+		{"  ÉCLAIR, crème-brûlée! ", []string{"eclair", "creme", "brulee"}},
+		{"USB-C cable 2m", []string{"usb", "c", "cable", "2m"}},
+		{".*+?", nil},
+	}
+	for _, tt := range tests {
+		got := tokenize(tt.in)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word, want string
+	}{
+		{"mugs", "mug"},
+		{"berries", "berry"},
+		{"glasses", "glass"},
+		{"boxes", "box"},
+		{"watches", "watch"},
+		{"dishes", "dish"},
+		{"painting", "paint"},
+		{"painted", "paint"},
+		{"boiled", "boil"},
+		{"ring", "ring"},
+		{"red", "red"},
+		{"glass", "glass"},
+		{"cactus", "cactus"},
+		{"tennis", "tennis"},
+		{"gas", "gas"},
+		{"mug", "mug"},
+	}
+	for _, tt := range tests {
+		if got := stem(tt.word); got != tt.want {
+			t.Errorf("stem(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestTypos(t *testing.T) {
+	tests := []struct{ n, want int }{{1, 0}, {3, 0}, {4, 1}, {7, 1}, {8, 2}, {20, 2}}
+	for _, tt := range tests {
+		if got := typos(tt.n); got != tt.want {
+			t.Errorf("typos(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"kettle", "kettle", 2, 0},
+		{"kettle", "ketle", 2, 1},
+		{"kettle", "kettles", 2, 1},
+		{"kettle", "kettel", 2, 1},
+		{"kettle", "kattle", 2, 1},
+		{"kettle", "kattel", 2, 2},
+		{"kettle", "bottle", 2, 2},
+		{"kettle", "kit", 2, 3},
+		{"kettle", "bottle", 1, 2},
+		{"", "mug", 3, 3},
+		{"café", "cafe", 1, 1},
+	}
+	for _, tt := range tests {
+		if got := distance([]rune(tt.a), []rune(tt.b), tt.max); got != tt.want {
+			t.Errorf("distance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
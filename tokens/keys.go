@@ -0,0 +1,400 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rsaKeyBits = 2048
+
+// keyReloadInterval limits how often a token naming an unknown kid makes the
+// key set re-read its directory, so forged kids cannot keep it busy.
+const keyReloadInterval = 5 * time.Second
+
+// staleLockAge is how old a rotation lock file must be before it is taken
+// to belong to an instance that died while rotating.
+const staleLockAge = time.Minute
+
+var errKeyDirLocked = errors.New("another instance is rotating the signing key")
+
+// signingKey is one asymmetric key pair, identified in token headers by kid.
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+	// retiredAt is set once a newer key takes over signing. The key keeps
+	// verifying until every token it signed has expired.
+	retiredAt time.Time
+}
+
+// KeySet holds the active signing key and the retired keys that still verify
+// tokens. When dir is set, keys are persisted there as PKCS#8 PEM files so
+// restarts and other instances sharing the directory use the same keys: a
+// lock file lets only one instance rotate, the others re-read the directory
+// when they check for rotation or meet a kid they do not know.
+type KeySet struct {
+	mu         sync.RWMutex
+	algorithm  string
+	dir        string
+	retention  time.Duration
+	keys       []*signingKey // oldest first; the last one signs
+	reloadedAt time.Time
+}
+
+// NewKeySet loads the keys for algorithm ("RS256" or "EdDSA") from dir, or
+// starts with a fresh key when there are none. Retired keys are dropped
+// after retention.
+func NewKeySet(algorithm, dir string, retention time.Duration) (*KeySet, error) {
+	if _, err := signingMethod(algorithm); err != nil {
+		return nil, err
+	}
+	ks := &KeySet{algorithm: algorithm, dir: dir, retention: retention}
+	if dir != "" {
+		if err := ks.reload(); err != nil {
+			return nil, err
+		}
+	}
+	if len(ks.keys) == 0 {
+		if err := ks.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		return jwt.SigningMethodRS256, nil
+	case jwt.SigningMethodEdDSA.Alg():
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+// Rotate creates a new signing key, retires the current one and prunes keys
+// whose retention has passed.
+func (ks *KeySet) Rotate() error {
+	key, err := ks.generate()
+	if err != nil {
+		return err
+	}
+	if ks.dir != "" {
+		if err = ks.save(key); err != nil {
+			return err
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if n := len(ks.keys); n > 0 {
+		ks.keys[n-1].retiredAt = key.createdAt
+	}
+	ks.keys = append(ks.keys, key)
+	ks.prune(key.createdAt)
+	log.Printf("token signing key rotated, kid %s", key.kid)
+	return nil
+}
+
+// StartRotation replaces the signing key once it is older than interval,
+// checking a tenth as often until stop is closed, and right away for a key
+// loaded from disk that is already too old.
+func (ks *KeySet) StartRotation(interval time.Duration, stop <-chan struct{}) {
+	if err := ks.rotateIfDue(interval); err != nil {
+		log.Println(err)
+	}
+	go func() {
+		ticker := time.NewTicker(max(interval/10, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ks.rotateIfDue(interval); err != nil {
+					log.Println(err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// rotateIfDue rotates when the signing key is older than interval. With a
+// key directory it first picks up keys other instances wrote, and rotates
+// only while holding the directory's lock, so one instance makes the new key
+// and the rest adopt it.
+func (ks *KeySet) rotateIfDue(interval time.Duration) error {
+	if ks.dir == "" {
+		if time.Since(ks.current().createdAt) < interval {
+			return nil
+		}
+		return ks.Rotate()
+	}
+
+	unlock, err := ks.lock()
+	if errors.Is(err, errKeyDirLocked) {
+		return ks.reload()
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = ks.reload(); err != nil {
+		return err
+	}
+	if time.Since(ks.current().createdAt) < interval {
+		return nil
+	}
+	return ks.Rotate()
+}
+
+// lock takes the rotation lock file in dir and returns the func releasing
+// it. A lock left behind by a crashed instance is broken after staleLockAge.
+func (ks *KeySet) lock() (func(), error) {
+	if err := os.MkdirAll(ks.dir, 0o700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(ks.dir, "rotate.lock")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if os.IsExist(err) {
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < staleLockAge {
+			return nil, errKeyDirLocked
+		}
+		log.Printf("breaking stale key rotation lock %s", path)
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if os.IsExist(err) {
+			return nil, errKeyDirLocked
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return func() {
+		if err := os.Remove(path); err != nil {
+			log.Println(err)
+		}
+	}, nil
+}
+
+func (ks *KeySet) current() *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[len(ks.keys)-1]
+}
+
+// sign signs claims with the active key and stamps its kid in the header.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	key := ks.current()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// verificationKey finds the public key a token names in its kid header. An
+// unknown kid may come from a key another instance has just made, so the
+// directory is re-read, at most once every keyReloadInterval.
+func (ks *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := ks.find(kid)
+	if key == nil && ks.dir != "" && ks.reloadDue() {
+		if err := ks.reload(); err != nil {
+			log.Println(err)
+		}
+		key = ks.find(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("token algorithm does not match its key")
+	}
+	return key.private.Public(), nil
+}
+
+func (ks *KeySet) find(kid string) *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, key := range ks.keys {
+		if key.kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// reloadDue reports whether keyReloadInterval has passed since the last
+// reload it allowed.
+func (ks *KeySet) reloadDue() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if time.Since(ks.reloadedAt) < keyReloadInterval {
+		return false
+	}
+	ks.reloadedAt = time.Now()
+	return true
+}
+
+// prune drops retired keys past their retention. Callers must hold ks.mu.
+func (ks *KeySet) prune(now time.Time) {
+	kept := ks.keys[:0]
+	for _, key := range ks.keys {
+		if !key.retiredAt.IsZero() && now.Sub(key.retiredAt) > ks.retention {
+			if ks.dir != "" {
+				if err := os.Remove(ks.keyPath(key.kid)); err != nil && !os.IsNotExist(err) {
+					log.Println(err)
+				}
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	ks.keys = kept
+}
+
+func (ks *KeySet) generate() (*signingKey, error) {
+	method, _ := signingMethod(ks.algorithm)
+	var private crypto.Signer
+	var err error
+	switch method {
+	case jwt.SigningMethodRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	kid, err := keyID(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{kid: kid, method: method, private: private, createdAt: time.Now()}, nil
+}
+
+// keyID derives a stable kid from the SHA-256 of the public key.
+func keyID(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+func (ks *KeySet) keyPath(kid string) string {
+	return filepath.Join(ks.dir, kid+".pem")
+}
+
+func (ks *KeySet) save(key *signingKey) error {
+	if err := os.MkdirAll(ks.dir, 0o700); err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	// Renamed into place so other instances never read a partial file.
+	tmp := ks.keyPath(key.kid) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.keyPath(key.kid))
+}
+
+// reload replaces the keys with the ones in dir, unless it holds none.
+func (ks *KeySet) reload() error {
+	keys, err := ks.load()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.prune(time.Now())
+	return nil
+}
+
+// load reads every key of the configured algorithm from dir. A key's
+// creation time is its file's modification time, and each key is retired
+// when the next one was created.
+func (ks *KeySet) load() ([]*signingKey, error) {
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	method, _ := signingMethod(ks.algorithm)
+	var keys []*signingKey
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			// Pruned by another instance since the glob.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		private, ok := parsed.(crypto.Signer)
+		if !ok || !matchesMethod(private, method) {
+			log.Printf("skipping %s: not a %s key", path, ks.algorithm)
+			continue
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		keys = append(keys, &signingKey{kid: kid, method: method, private: private, createdAt: info.ModTime()})
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].createdAt.Before(keys[j].createdAt) })
+	for i := 0; i+1 < len(keys); i++ {
+		keys[i].retiredAt = keys[i+1].createdAt
+	}
+	return keys, nil
+}
+
+func matchesMethod(private crypto.Signer, method jwt.SigningMethod) bool {
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		return method == jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		return method == jwt.SigningMethodEdDSA
+	}
+	return false
+}
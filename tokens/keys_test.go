@@ -0,0 +1,71 @@
+package tokens
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+func TestKeySetSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	newKeySet := func() *KeySet {
+		t.Helper()
+		ks, err := NewKeySet(jwt.SigningMethodEdDSA.Alg(), dir, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ks
+	}
+	verifies := func(signer, verifier *KeySet) bool {
+		t.Helper()
+		token, err := signer.sign(jwt.RegisteredClaims{Subject: "ann"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = jwt.Parse(token, verifier.verificationKey)
+		return err == nil
+	}
+
+	a, b := newKeySet(), newKeySet()
+	if a.current().kid != b.current().kid {
+		t.Fatalf("instances sharing a directory sign with %s and %s", a.current().kid, b.current().kid)
+	}
+
+	// b learns a key it has not loaded when a token names it.
+	if err := a.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if !verifies(a, b) {
+		t.Fatal("a token signed with a rotated key did not verify on the other instance")
+	}
+
+	// Only the instance holding the lock rotates; the other adopts its key.
+	unlock, err := a.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := b.current().kid
+	if err = b.rotateIfDue(0); err != nil {
+		t.Fatal(err)
+	}
+	if b.current().kid != before {
+		t.Fatal("rotated while another instance held the lock")
+	}
+	unlock()
+
+	if err = b.rotateIfDue(0); err != nil {
+		t.Fatal(err)
+	}
+	if b.current().kid == before {
+		t.Fatal("did not rotate a key older than the interval")
+	}
+	if err = a.rotateIfDue(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if a.current().kid != b.current().kid {
+		t.Fatalf("after rotation the instances sign with %s and %s", a.current().kid, b.current().kid)
+	}
+	if !verifies(b, a) || !verifies(a, b) {
+		t.Fatal("instances do not verify each other's tokens")
+	}
+}
@@ -0,0 +1,47 @@
+package tokens
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// oidcFlowTTL bounds how long a user may spend at the login provider.
+const oidcFlowTTL = 10 * time.Minute
+
+var ErrInvalidOIDCFlow = errors.New("the login attempt is invalid or expired, please start again")
+
+// OIDCFlow is what the OIDC login needs to remember between sending the user
+// to the provider and the callback. It travels in a cookie, never in the
+// URL, so an intercepted callback cannot be replayed without it.
+type OIDCFlow struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+	jwt.RegisteredClaims
+}
+
+// SignOIDCFlow signs flow so it can be handed to the browser.
+func SignOIDCFlow(flow OIDCFlow) (string, error) {
+	now := time.Now()
+	flow.RegisteredClaims = jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(oidcFlowTTL)),
+	}
+	return sign(&flow)
+}
+
+// ParseOIDCFlow returns the flow signed by SignOIDCFlow for provider.
+func ParseOIDCFlow(signedFlow, provider string) (*OIDCFlow, error) {
+	flow := &OIDCFlow{}
+	if err := parse(signedFlow, flow); err != nil || flow.Provider != provider || flow.State == "" {
+		return nil, ErrInvalidOIDCFlow
+	}
+	return flow, nil
+}
+
+// OIDCFlowMaxAge is the cookie lifetime matching the signed flow.
+func OIDCFlowMaxAge() int {
+	return int(oidcFlowTTL / time.Second)
+}
@@ -0,0 +1,68 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"log"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, please log in again")
+)
+
+// RefreshTokens exchanges a refresh token for a new access/refresh pair in
+// the same family. Each refresh token works once: presenting one that is no
+// longer the latest of its family means it was replayed, so that family is
+// revoked and its login has to start over. The user's other logins are left
+// alone.
+func RefreshTokens(ctx context.Context, users database.UserStore, signedRefreshToken string) (signedToken string, newRefreshToken string, err error) {
+	claims, msg := ValidateToken(signedRefreshToken)
+	if msg != "" || claims.TokenType != RefreshToken || claims.Family == "" {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	user, err := users.FindByID(ctx, claims.UID)
+	if errors.Is(err, database.ErrUserNotFound) || errors.Is(err, database.ErrUserIdIsNotValid) {
+		return "", "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", "", err
+	}
+	// A token from before a logout or revocation is dead already; replaying
+	// it must not revoke the session the user has started since.
+	if claims.Version != user.TokenVersion {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	signedToken, newRefreshToken, newID, err := generatePair(user, claims.Family)
+	if err != nil {
+		return "", "", err
+	}
+
+	current := models.RefreshFamily{Family: claims.Family, TokenID: claims.ID}
+	err = users.RotateTokens(ctx, user.UserID, current, newID, signedToken)
+	// A refresh token stored before families were tracked starts one, once:
+	// starting it clears the stored token.
+	if errors.Is(err, database.ErrRefreshFamilyEnded) && user.RefreshToken == signedRefreshToken {
+		current.TokenID, current.StartedAt = newID, time.Now()
+		err = users.UpdateTokens(ctx, user.UserID, signedToken, current)
+	}
+	if errors.Is(err, database.ErrRefreshFamilyEnded) {
+		return "", "", ErrInvalidRefreshToken
+	}
+	if errors.Is(err, database.ErrRefreshTokenStale) {
+		log.Printf("refresh token reuse detected for user %s, revoking token family %s", user.UserID, claims.Family)
+		if err := users.RevokeFamily(ctx, user.UserID, claims.Family); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return signedToken, newRefreshToken, nil
+}
@@ -0,0 +1,180 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"github.com/mukulmantosh/ecommerce-gin/config"
+	"github.com/mukulmantosh/ecommerce-gin/database"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	cfg := config.Default().Tokens
+	cfg.SecretKey = "test secret"
+	if err := Configure(cfg); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// refreshSession is a user in a memory store with the logins made so far,
+// each holding its latest token pair.
+type refreshSession struct {
+	t      *testing.T
+	ctx    context.Context
+	users  database.UserStore
+	user   models.User
+	logins []*login
+}
+
+type login struct {
+	token, refresh string
+	// used are the refresh tokens the login has already exchanged.
+	used []string
+}
+
+func newRefreshSession(t *testing.T) *refreshSession {
+	id := primitive.NewObjectID()
+	return newRefreshSessionFor(t, models.User{ID: id, UserID: id.Hex(), Email: "ann@example.com",
+		Role: models.RoleCustomer})
+}
+
+func newRefreshSessionFor(t *testing.T, user models.User) *refreshSession {
+	users := database.NewMemoryUserStore(database.NewMemoryDB())
+	if err := users.Create(context.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+	return &refreshSession{t: t, ctx: context.Background(), users: users, user: user}
+}
+
+func (s *refreshSession) login() *login {
+	token, refresh, err := IssueTokens(s.ctx, s.users, s.user)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	l := &login{token: token, refresh: refresh}
+	s.logins = append(s.logins, l)
+	return l
+}
+
+// refresh exchanges the login's latest refresh token, or replays the one it
+// exchanged before when replay is set.
+func (s *refreshSession) refresh(l *login, replay bool) error {
+	presented := l.refresh
+	if replay {
+		presented = l.used[len(l.used)-1]
+	}
+	token, refresh, err := RefreshTokens(s.ctx, s.users, presented)
+	if err == nil {
+		l.used = append(l.used, presented)
+		l.token, l.refresh = token, refresh
+	}
+	return err
+}
+
+func TestRefreshTokens(t *testing.T) {
+	type step struct {
+		login   int
+		replay  bool
+		wantErr error
+	}
+	tests := []struct {
+		name   string
+		logins int
+		// revoke logs the user out everywhere before the steps.
+		revoke bool
+		steps  []step
+	}{
+		{
+			name:   "each refresh rotates",
+			logins: 1,
+			steps:  []step{{login: 0}, {login: 0}, {login: 0}},
+		},
+		{
+			name:   "replay revokes the family",
+			logins: 1,
+			steps: []step{
+				{login: 0},
+				{login: 0, replay: true, wantErr: ErrRefreshTokenReused},
+				{login: 0, wantErr: ErrInvalidRefreshToken},
+			},
+		},
+		{
+			name:   "replay leaves other logins alone",
+			logins: 2,
+			steps: []step{
+				{login: 0},
+				{login: 1},
+				{login: 0, replay: true, wantErr: ErrRefreshTokenReused},
+				{login: 1},
+			},
+		},
+		{
+			name:   "logout ends every family",
+			logins: 2,
+			revoke: true,
+			steps: []step{
+				{login: 0, wantErr: ErrInvalidRefreshToken},
+				{login: 1, wantErr: ErrInvalidRefreshToken},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRefreshSession(t)
+			for i := 0; i < tt.logins; i++ {
+				s.login()
+			}
+			if tt.revoke {
+				if err := s.users.RevokeTokens(s.ctx, s.user.UserID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, step := range tt.steps {
+				if err := s.refresh(s.logins[step.login], step.replay); !errors.Is(err, step.wantErr) {
+					t.Fatalf("step %d: RefreshTokens() error = %v, want %v", i, err, step.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshTokensRejectsOtherTokens(t *testing.T) {
+	s := newRefreshSession(t)
+	l := s.login()
+	challenge, err := TwoFactorChallengeToken(s.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"access token": l.token, "challenge": challenge, "garbage": "not.a.token"} {
+		if _, _, err := RefreshTokens(s.ctx, s.users, token); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("%s: RefreshTokens() error = %v, want %v", name, err, ErrInvalidRefreshToken)
+		}
+	}
+}
+
+func TestRefreshTokensAdoptsLegacyToken(t *testing.T) {
+	id := primitive.NewObjectID()
+	user := models.User{ID: id, UserID: id.Hex(), Email: "ann@example.com", Role: models.RoleCustomer}
+	// A refresh token stored before families were tracked.
+	_, legacy, err := TokenGenerator(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.RefreshToken = legacy
+	s := newRefreshSessionFor(t, user)
+
+	l := &login{refresh: legacy}
+	if err = s.refresh(l, false); err != nil {
+		t.Fatalf("RefreshTokens() error = %v, want the legacy token adopted", err)
+	}
+	if err = s.refresh(l, true); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replaying the legacy token: error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if err = s.refresh(l, false); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("after the replay: error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
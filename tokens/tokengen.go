@@ -2,112 +2,189 @@ package tokens
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/mukulmantosh/ecommerce-gin/config"
 	"github.com/mukulmantosh/ecommerce-gin/database"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/mukulmantosh/ecommerce-gin/models"
 	"log"
-	"os"
 	"time"
 )
 
-var UserData *mongo.Collection = database.UserData(database.Client, "Users")
+var (
+	SecretKey  string
+	accessTTL  = 2 * time.Hour
+	refreshTTL = 7 * 24 * time.Hour
+	// keys signs and verifies tokens when an asymmetric algorithm is
+	// configured; nil means HS256 with SecretKey.
+	keys *KeySet
+)
+
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
 
-var SecretKey string = os.Getenv("SECRET_KEY")
+// Configure sets the signing keys and token lifetimes. It must be called
+// before any token is generated or validated. For RS256 and EdDSA it loads
+// or creates the key set and starts rotating it every cfg.RotationInterval.
+func Configure(cfg config.Tokens) error {
+	SecretKey = cfg.SecretKey
+	accessTTL = cfg.AccessTTL
+	refreshTTL = cfg.RefreshTTL
+	emailVerificationTTL = cfg.EmailVerificationTTL
+	twoFactorChallengeTTL = cfg.TwoFactorChallengeTTL
+	keys = nil
+
+	if cfg.Algorithm == jwt.SigningMethodHS256.Alg() {
+		return nil
+	}
+	retention := refreshTTL
+	if accessTTL > retention {
+		retention = accessTTL
+	}
+	keySet, err := NewKeySet(cfg.Algorithm, cfg.KeyDir, retention)
+	if err != nil {
+		return err
+	}
+	keySet.StartRotation(cfg.RotationInterval, nil)
+	keys = keySet
+	return nil
+}
+
+// sign signs claims with the configured algorithm.
+func sign(claims jwt.Claims) (string, error) {
+	if keys != nil {
+		return keys.sign(claims)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SecretKey))
+}
 
 type SignedDetails struct {
 	Email     string
 	FirstName string
 	LastName  string
 	UID       string
+	// Version is the user's token version when the token was issued. Logging
+	// out bumps the stored version, which revokes every older token.
+	Version int
+	// Role is the user's role when the token was issued, for services that
+	// verify our tokens. Our own middleware reads the current role instead.
+	Role string
+	// TokenType is AccessToken, RefreshToken, EmailVerification or
+	// TwoFactorChallenge; only access tokens open the authenticated routes.
+	TokenType string
+	// Family ties the tokens issued from one login together, so a replayed
+	// refresh token can revoke that login alone.
+	Family string
 	jwt.RegisteredClaims
 }
 
-func TokenGenerator(email string, firstname string, lastname string, uid string) (signedToken string, signedRefreshToken string, err error) {
-	timer := time.Now().Local().Add(time.Hour * time.Duration(2)).Unix()
+func TokenGenerator(user models.User) (signedToken string, signedRefreshToken string, err error) {
+	signedToken, signedRefreshToken, _, err = generatePair(user, newTokenID())
+	return signedToken, signedRefreshToken, err
+}
+
+// generatePair signs an access and a refresh token in family and returns
+// them with the refresh token's id.
+func generatePair(user models.User, family string) (signedToken string, signedRefreshToken string, refreshID string, err error) {
+	now := time.Now()
 
 	claims := &SignedDetails{
-		Email:     email,
-		FirstName: firstname,
-		LastName:  lastname,
-		UID:       uid,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		UID:       user.UserID,
+		Version:   user.TokenVersion,
+		Role:      user.Role,
+		TokenType: AccessToken,
+		Family:    family,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Unix(timer, 0)),
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
 		},
 	}
 
 	refreshClaims := &SignedDetails{
-		Email:     email,
-		FirstName: firstname,
-		LastName:  lastname,
-		UID:       uid,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		UID:       user.UserID,
+		Version:   user.TokenVersion,
+		Role:      user.Role,
+		TokenType: RefreshToken,
+		Family:    family,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Unix(timer, 0)),
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(refreshTTL)),
 		},
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SecretKey))
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SecretKey))
-
+	token, err := sign(claims)
 	if err != nil {
-		log.Panic(err)
-		return
+		return "", "", "", err
+	}
+	refreshToken, err := sign(refreshClaims)
+	if err != nil {
+		return "", "", "", err
 	}
 
-	return token, refreshToken, err
+	return token, refreshToken, refreshClaims.ID, nil
 }
 
-func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
-	token, err := jwt.ParseWithClaims(
-		signedToken,
-		&SignedDetails{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(SecretKey), nil
-		},
-	)
-
-	if err != nil {
-		msg = err.Error()
-		return
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
 	}
-	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
-		msg = fmt.Sprintf("The token is invalid")
+	return hex.EncodeToString(b)
+}
+
+func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims = &SignedDetails{}
+	if err := parse(signedToken, claims); err != nil {
 		msg = err.Error()
-		return
+		return nil, msg
 	}
 	return claims, msg
 }
 
-func UpdateAllTokens(signedToken string, signedRefreshToken string, userId string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-	var updateObj primitive.D
-	updateObj = append(updateObj, bson.E{"AccessToken", signedToken})
-	updateObj = append(updateObj, bson.E{"RefreshToken", signedRefreshToken})
-
-	UpdatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{"UpdatedAt", UpdatedAt})
-	upsert := true
-
-	filter := bson.M{"UserID": userId}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
+// parse verifies signedToken with the configured algorithm and decodes it
+// into claims.
+func parse(signedToken string, claims jwt.Claims) error {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return []byte(SecretKey), nil
+	}
+	validMethods := []string{jwt.SigningMethodHS256.Alg()}
+	if keys != nil {
+		keyFunc = keys.verificationKey
+		validMethods = []string{keys.algorithm}
 	}
 
-	_, err := UserData.UpdateOne(ctx, filter,
-		bson.D{
-			{"$set", updateObj},
-		}, &opt)
-
-	defer cancel()
-
+	token, err := jwt.ParseWithClaims(signedToken, claims, keyFunc, jwt.WithValidMethods(validMethods))
 	if err != nil {
-		log.Panic(err)
-		return
+		return err
+	}
+	if !token.Valid {
+		return fmt.Errorf("The token is invalid")
 	}
-	return
+	return nil
+}
 
+// IssueTokens signs a token pair for a new login of user and stores it as
+// the start of a new refresh token family.
+func IssueTokens(ctx context.Context, users database.UserStore, user models.User) (signedToken string, signedRefreshToken string, err error) {
+	family := models.RefreshFamily{Family: newTokenID(), StartedAt: time.Now()}
+	signedToken, signedRefreshToken, family.TokenID, err = generatePair(user, family.Family)
+	if err != nil {
+		return "", "", err
+	}
+	if err = users.UpdateTokens(ctx, user.UserID, signedToken, family); err != nil {
+		return "", "", err
+	}
+	return signedToken, signedRefreshToken, nil
 }
@@ -0,0 +1,157 @@
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP follows RFC 6238 with the parameters every authenticator app
+// understands: HMAC-SHA1, six digits, 30 second steps.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many steps either side of now are accepted, to allow
+	// for clock drift and slow typing.
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+// TwoFactorChallenge is the token type handed out after a correct password
+// when the account still has to pass its TOTP check.
+const TwoFactorChallenge = "2fa_challenge"
+
+var (
+	ErrInvalidTwoFactorCode = errors.New("the two-factor code is invalid")
+	ErrInvalidChallenge     = errors.New("the login challenge is invalid or expired")
+)
+
+// twoFactorChallengeTTL is how long the second login step may take.
+var twoFactorChallengeTTL = 5 * time.Minute
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160-bit secret in base32, as shown to users
+// who type it in by hand.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPProvisioningURI is the otpauth:// URI authenticator apps import,
+// usually by scanning it as a QR code.
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// ValidateTOTP checks code against secret at now and returns the time step
+// it matched. Stores keep the last accepted step so a code works only once.
+func ValidateTOTP(secret, code string, now time.Time) (int64, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, ErrInvalidTwoFactorCode
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidTwoFactorCode
+}
+
+// totpCode is the RFC 4226 HOTP value of key for counter step.
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// IsTOTPCode tells a TOTP code apart from a recovery code.
+func IsTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRecoveryCodes returns single-use codes for when the authenticator is
+// lost, and the hashes to store in their place.
+func NewRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode is the at-rest form of a recovery code. Case, spaces and
+// dashes are ignored so codes can be typed back loosely.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashOpaqueToken(code)
+}
+
+// TwoFactorChallengeToken proves the user got the password right. It is
+// exchanged, together with a TOTP or recovery code, for the real tokens.
+func TwoFactorChallengeToken(user models.User) (string, error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		Email:     user.Email,
+		UID:       user.UserID,
+		Version:   user.TokenVersion,
+		TokenType: TwoFactorChallenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(twoFactorChallengeTTL)),
+		},
+	}
+	return sign(claims)
+}
+
+// ParseTwoFactorChallenge returns the claims of a valid challenge token.
+func ParseTwoFactorChallenge(signedToken string) (*SignedDetails, error) {
+	claims, msg := ValidateToken(signedToken)
+	if msg != "" || claims.TokenType != TwoFactorChallenge {
+		return nil, ErrInvalidChallenge
+	}
+	return claims, nil
+}
@@ -0,0 +1,102 @@
+package tokens
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 test vectors, in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The RFC lists eight digits; these are their last six.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantErr  error
+	}{
+		{"current step", rfc6238Secret, "050471", step, nil},
+		{"lower-case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", step, nil},
+		{"previous step", rfc6238Secret, codeAt(t, step-1), step - 1, nil},
+		{"next step", rfc6238Secret, codeAt(t, step+1), step + 1, nil},
+		{"two steps back", rfc6238Secret, codeAt(t, step-2), 0, ErrInvalidTwoFactorCode},
+		{"wrong code", rfc6238Secret, "123456", 0, ErrInvalidTwoFactorCode},
+		{"short code", rfc6238Secret, "05047", 0, ErrInvalidTwoFactorCode},
+		{"malformed secret", "not base32!", "050471", 0, ErrInvalidTwoFactorCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateTOTP(tt.secret, tt.code, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateTOTP() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.wantStep {
+				t.Errorf("ValidateTOTP() = %d, want %d", got, tt.wantStep)
+			}
+		})
+	}
+}
+
+func codeAt(t *testing.T, step int64) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, step)
+}
+
+func TestIsTOTPCode(t *testing.T) {
+	tests := map[string]bool{"123456": true, "12345": false, "1234567": false, "12a456": false, "abcd-efgh": false}
+	for code, want := range tests {
+		if got := IsTOTPCode(code); got != want {
+			t.Errorf("IsTOTPCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("NewRecoveryCodes() returned %d codes and %d hashes", len(codes), len(hashes))
+	}
+	for i, code := range codes {
+		for _, typed := range []string{code, " " + code + " ", strings.ToUpper(code), code[:4] + code[5:]} {
+			if got := HashRecoveryCode(typed); got != hashes[i] {
+				t.Errorf("HashRecoveryCode(%q) does not match the hash of %q", typed, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tokens
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mukulmantosh/ecommerce-gin/models"
+	"time"
+)
+
+// EmailVerification is the token type of a mailed email verification link.
+const EmailVerification = "email_verification"
+
+var ErrInvalidVerificationToken = errors.New("verification link is invalid or expired")
+
+// emailVerificationTTL is how long a verification link works.
+var emailVerificationTTL = 24 * time.Hour
+
+// EmailVerificationToken signs a link token binding the user to the email
+// address it was sent to, so the link stops working if the address changes.
+func EmailVerificationToken(user models.User) (string, error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		Email:     user.Email,
+		UID:       user.UserID,
+		TokenType: EmailVerification,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(emailVerificationTTL)),
+		},
+	}
+	return sign(claims)
+}
+
+// ParseEmailVerificationToken returns the user id and email a verification
+// token was issued for.
+func ParseEmailVerificationToken(signedToken string) (userID string, email string, err error) {
+	claims, msg := ValidateToken(signedToken)
+	if msg != "" || claims.TokenType != EmailVerification {
+		return "", "", ErrInvalidVerificationToken
+	}
+	return claims.UID, claims.Email, nil
+}